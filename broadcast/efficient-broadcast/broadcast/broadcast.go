@@ -0,0 +1,200 @@
+// Package broadcast serves the broadcast workload, batching new values to
+// each neighbor on a timer rather than forwarding every one on arrival.
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+	"maelstrom-lib/schema"
+	"maelstrom-lib/snapshot"
+)
+
+// GossipIntervalEnv names the environment variable holding how often
+// values are gossiped to neighbors, as a Go duration such as "250ms".
+// Longer intervals batch more values per message at the cost of latency.
+const GossipIntervalEnv = "BROADCAST_GOSSIP_INTERVAL"
+
+const defaultGossipInterval = 100 * time.Millisecond
+
+// gossipInterval reads the gossip interval from the environment.
+func gossipInterval() time.Duration {
+	v := os.Getenv(GossipIntervalEnv)
+	if v == "" {
+		return defaultGossipInterval
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("%s: invalid interval %q", GossipIntervalEnv, v)
+	}
+	return d
+}
+
+// Register registers the broadcast handlers on n.
+func Register(n *maelstrom.Node) {
+	validator := schema.NewValidator(n, schema.Broadcast)
+
+	interval := gossipInterval()
+
+	var (
+		mu        sync.Mutex
+		messages  = make(map[float64]bool) // store seen messages
+		neighbors []string
+		pending   = make(map[string][]float64) // values not yet gossiped, per neighbor
+	)
+
+	// learn records new values and queues them for every neighbor except
+	// the one they came from. It must be called with mu held.
+	learn := func(values []float64, from string) {
+		var fresh []float64
+		for _, m := range values {
+			if !messages[m] {
+				messages[m] = true
+				fresh = append(fresh, m)
+			}
+		}
+		if len(fresh) == 0 {
+			return
+		}
+		for _, neighbor := range neighbors {
+			if neighbor != from {
+				pending[neighbor] = append(pending[neighbor], fresh...)
+			}
+		}
+	}
+
+	// gossip sends each neighbor everything queued for it as one message.
+	// Batches that are not acknowledged within a few intervals go back in
+	// the queue for the next round.
+	gossip := func() {
+		mu.Lock()
+		batches := pending
+		pending = make(map[string][]float64)
+		mu.Unlock()
+
+		for neighbor, values := range batches {
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*interval)
+				defer cancel()
+				if _, err := rpc.Call(ctx, n, neighbor, map[string]any{
+					"type":     "gossip",
+					"messages": values,
+				}); err == nil {
+					return
+				}
+				mu.Lock()
+				pending[neighbor] = append(pending[neighbor], values...)
+				mu.Unlock()
+			}()
+		}
+	}
+
+	// Late joiners copy the set of seen messages from a peer instead of
+	// starting empty; installing a snapshot merges it into our own set.
+	transfer := snapshot.New(n, func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		result := make([]float64, 0, len(messages))
+		for m := range messages {
+			result = append(result, m)
+		}
+		return json.Marshal(result)
+	}, func(data []byte) error {
+		var received []float64
+		if err := json.Unmarshal(data, &received); err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, m := range received {
+			messages[m] = true
+		}
+		return nil
+	})
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		go func() {
+			for range time.Tick(interval) {
+				gossip()
+			}
+		}()
+		go func() {
+			if err := transfer.Bootstrap(context.Background()); err != nil {
+				log.Printf("snapshot bootstrap: %s", err)
+			}
+		}()
+		return nil
+	})
+
+	validator.Handle("broadcast", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		learn([]float64{body["message"].(float64)}, "")
+		mu.Unlock()
+
+		return validator.Reply(msg, map[string]any{
+			"type": "broadcast_ok",
+		})
+	})
+
+	validator.Handle("read", func(msg maelstrom.Message) error {
+		mu.Lock()
+		result := make([]float64, 0, len(messages))
+		for m := range messages {
+			result = append(result, m)
+		}
+		mu.Unlock()
+
+		return validator.Reply(msg, map[string]any{
+			"type":     "read_ok",
+			"messages": result,
+		})
+	})
+
+	validator.Handle("topology", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		topology := body["topology"].(map[string]any)
+		rawNeighbors := topology[n.ID()].([]any)
+
+		mu.Lock()
+		neighbors = make([]string, 0, len(rawNeighbors))
+		for _, val := range rawNeighbors {
+			neighbors = append(neighbors, val.(string))
+		}
+		mu.Unlock()
+
+		return validator.Reply(msg, map[string]any{
+			"type": "topology_ok",
+		})
+	})
+
+	// A batch of values from a neighbor's gossip round.
+	n.Handle("gossip", func(msg maelstrom.Message) error {
+		var body struct {
+			Messages []float64 `json:"messages"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		mu.Lock()
+		learn(body.Messages, msg.Src)
+		mu.Unlock()
+
+		return n.Reply(msg, map[string]any{"type": "gossip_ok"})
+	})
+}
@@ -9,3 +9,7 @@ require (
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
+
+require maelstrom-lib v0.0.0-00010101000000-000000000000
+
+replace maelstrom-lib => ../../lib
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Values are forwarded to neighbors in deliver messages, which the
+// receiver acknowledges with an ack message listing the values it got. The
+// outbox remembers, per value, the neighbors that have not acked it yet and
+// retransmits to just those, backing off exponentially while a neighbor
+// stays silent. Once every neighbor has acked a value its entry is dropped,
+// so tracking only costs memory while deliveries are outstanding.
+//
+// A neighbor that is dead or partitioned away would keep every value since
+// pending. One that has been waited on for giveUp without a word is left
+// to anti-entropy instead: its outstanding values are forgotten, and values
+// are sent to it once, untracked, until it is heard from again.
+
+const (
+	retryInitial = 200 * time.Millisecond // first retransmission delay
+	retryMax     = 5 * time.Second        // backoff stops growing here
+	retryTick    = 50 * time.Millisecond  // how often due values are checked
+	giveUp       = 15 * time.Second       // silence after which a neighbor is no longer tracked
+)
+
+// delivery tracks one value that some neighbors have not acked.
+type delivery struct {
+	waiting map[string]bool
+	delay   time.Duration
+	due     time.Time
+}
+
+// outbox forwards values to neighbors until each has acked them.
+type outbox struct {
+	n   *maelstrom.Node
+	now func() time.Time // replaced by tests
+
+	mu          sync.Mutex
+	pending     map[float64]*delivery
+	outstanding map[string]int       // values each neighbor is waited on for
+	heard       map[string]time.Time // since when each neighbor has been silent
+	silent      map[string]bool      // neighbors no longer tracked
+}
+
+func newOutbox(n *maelstrom.Node) *outbox {
+	o := &outbox{
+		n:           n,
+		now:         time.Now,
+		pending:     make(map[float64]*delivery),
+		outstanding: make(map[string]int),
+		heard:       make(map[string]time.Time),
+		silent:      make(map[string]bool),
+	}
+	n.Handle("ack", o.handleAck)
+	return o
+}
+
+// send forwards values to peers and tracks them until acked, except for
+// silent peers.
+func (o *outbox) send(values []float64, peers []string) {
+	if len(values) == 0 || len(peers) == 0 {
+		return
+	}
+	o.mu.Lock()
+	now := o.now()
+	for _, v := range values {
+		d := o.pending[v]
+		for _, peer := range peers {
+			if o.silent[peer] || d != nil && d.waiting[peer] {
+				continue
+			}
+			if d == nil {
+				d = &delivery{waiting: make(map[string]bool), delay: retryInitial, due: now.Add(retryInitial)}
+				o.pending[v] = d
+			}
+			d.waiting[peer] = true
+			if o.outstanding[peer] == 0 {
+				o.heard[peer] = now
+			}
+			o.outstanding[peer]++
+		}
+	}
+	o.mu.Unlock()
+
+	for _, peer := range peers {
+		o.n.Send(peer, map[string]any{"type": "deliver", "messages": values})
+	}
+}
+
+// acked records that peer has values, dropping each value's entry once no
+// neighbor is left waiting for it. Hearing from a silent peer tracks it
+// again.
+func (o *outbox) acked(peer string, values []float64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.heard[peer] = o.now()
+	delete(o.silent, peer)
+	for _, v := range values {
+		o.stopWaiting(v, peer)
+	}
+}
+
+// stopWaiting drops peer from the neighbors waited on for v. Expects o.mu.
+func (o *outbox) stopWaiting(v float64, peer string) {
+	d := o.pending[v]
+	if d == nil || !d.waiting[peer] {
+		return
+	}
+	delete(d.waiting, peer)
+	if len(d.waiting) == 0 {
+		delete(o.pending, v)
+	}
+	o.outstanding[peer]--
+}
+
+// start retransmits due values every retryTick, batched per neighbor.
+func (o *outbox) start() {
+	go func() {
+		for range time.Tick(retryTick) {
+			for peer, values := range o.due(time.Now()) {
+				for batch := range slices.Chunk(values, maxValues) {
+					o.n.Send(peer, map[string]any{"type": "deliver", "messages": batch})
+				}
+			}
+		}
+	}()
+}
+
+// due collects the values whose retransmission is due, by the neighbors
+// still waiting for them, and backs each off. Neighbors silent for giveUp
+// are dropped first.
+func (o *outbox) due(now time.Time) map[string][]float64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for peer, n := range o.outstanding {
+		if n > 0 && now.Sub(o.heard[peer]) >= giveUp {
+			o.silent[peer] = true
+			for v := range o.pending {
+				o.stopWaiting(v, peer)
+			}
+		}
+	}
+
+	byPeer := make(map[string][]float64)
+	for v, d := range o.pending {
+		if now.Before(d.due) {
+			continue
+		}
+		for peer := range d.waiting {
+			byPeer[peer] = append(byPeer[peer], v)
+		}
+		d.delay = min(2*d.delay, retryMax)
+		d.due = now.Add(d.delay)
+	}
+	return byPeer
+}
+
+// handleAck records a neighbor's acknowledgement.
+func (o *outbox) handleAck(msg maelstrom.Message) error {
+	var body struct {
+		Messages []float64 `json:"messages"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	o.acked(msg.Src, body.Messages)
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// testOutbox returns an outbox on n0 whose clock the test sets, and that
+// sends into the void.
+func testOutbox() (*outbox, *time.Time) {
+	n := maelstrom.NewNode()
+	n.Init("n0", []string{"n0", "n1", "n2"})
+	n.Stdout = io.Discard
+	o := newOutbox(n)
+	now := time.Unix(1000, 0)
+	o.now = func() time.Time { return now }
+	return o, &now
+}
+
+// checkDue fails the test unless the retransmissions due at now are want.
+func checkDue(t *testing.T, o *outbox, now time.Time, want map[string][]float64) {
+	t.Helper()
+	got := o.due(now)
+	for _, values := range got {
+		slices.Sort(values)
+	}
+	if !maps.EqualFunc(got, want, slices.Equal) {
+		t.Fatalf("due at %s: %v, want %v", now.Format(time.StampMilli), got, want)
+	}
+}
+
+func TestOutboxRetransmitsUntilAcked(t *testing.T) {
+	o, now := testOutbox()
+	start := *now
+	o.send([]float64{1, 2}, []string{"n1", "n2"})
+
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	checkDue(t, o, at(retryInitial-time.Millisecond), map[string][]float64{})
+	checkDue(t, o, at(retryInitial), map[string][]float64{"n1": {1, 2}, "n2": {1, 2}})
+
+	// Only the neighbors yet to ack are sent to again, after twice the
+	// delay.
+	o.acked("n1", []float64{1, 2})
+	o.acked("n2", []float64{1})
+	checkDue(t, o, at(3*retryInitial-time.Millisecond), map[string][]float64{})
+	checkDue(t, o, at(3*retryInitial), map[string][]float64{"n2": {2}})
+
+	// Once every neighbor has acked, the value is forgotten.
+	o.acked("n2", []float64{2})
+	if len(o.pending) != 0 {
+		t.Fatalf("%d values still pending after every ack", len(o.pending))
+	}
+	checkDue(t, o, at(time.Minute), map[string][]float64{})
+}
+
+func TestOutboxBackoffIsCapped(t *testing.T) {
+	o, now := testOutbox()
+	o.send([]float64{1}, []string{"n1"})
+
+	// n1 keeps acking something else, so it is not given up on.
+	at, delay := *now, retryInitial
+	for range 10 {
+		at = at.Add(delay)
+		*now = at
+		o.acked("n1", nil)
+		checkDue(t, o, at, map[string][]float64{"n1": {1}})
+		delay = min(2*delay, retryMax)
+	}
+	if d := o.pending[1].delay; d != retryMax {
+		t.Fatalf("backed off to %s, want the cap of %s", d, retryMax)
+	}
+}
+
+func TestOutboxGivesUpOnSilentNeighbor(t *testing.T) {
+	o, now := testOutbox()
+	start := *now
+	o.send([]float64{1, 2}, []string{"n1", "n2"})
+
+	// n2 answers, n1 never does.
+	*now = start.Add(time.Second)
+	o.acked("n2", []float64{1})
+	checkDue(t, o, start.Add(giveUp-time.Millisecond), map[string][]float64{"n1": {1, 2}, "n2": {2}})
+	checkDue(t, o, start.Add(giveUp), map[string][]float64{})
+	if _, ok := o.pending[1]; ok {
+		t.Fatal("value 1 still pending after giving up on n1")
+	}
+	if d := o.pending[2]; d == nil || !maps.Equal(d.waiting, map[string]bool{"n2": true}) {
+		t.Fatalf("value 2 pending for %v, want n2 only", d)
+	}
+
+	// New values are sent to n1 but not tracked...
+	o.send([]float64{3}, []string{"n1"})
+	if _, ok := o.pending[3]; ok {
+		t.Fatal("value 3 tracked for a silent neighbor")
+	}
+
+	// ...until n1 is heard from again.
+	*now = start.Add(time.Minute)
+	o.acked("n1", []float64{3})
+	o.send([]float64{4}, []string{"n1"})
+	checkDue(t, o, now.Add(retryInitial), map[string][]float64{"n1": {4}})
+}
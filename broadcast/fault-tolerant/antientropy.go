@@ -0,0 +1,364 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/iblt"
+	"maelstrom-lib/rpc"
+)
+
+// Anti-entropy reconciles the value sets of neighbors without resending
+// them. Every value is placed by a 64-bit hash; a range of hashes is
+// summarized by a digest, the hash of its values in sorted order plus their
+// count. A sync session starts by comparing the digests of the whole set.
+// If they differ, the peer answers with the digests of the range's 16
+// sub-ranges, and the session recurses into those that differ until a
+// range holds only a few values, which are then exchanged outright. Two
+// sets differing in d of n values cost O(d log n) digests and values,
+// rather than O(n).
+
+// AntiEntropyInterval is how often each node syncs with its neighbors.
+const AntiEntropyInterval = time.Second
+
+// ReconcileEnv names the environment variable selecting how neighbors
+// reconcile: "ranges" (the default) descends into differing hash ranges,
+// "iblt" exchanges IBLT sketches sized by a strata estimator (see
+// reconcile.go), and "resend" naively sends every value each round, as a
+// baseline to compare against.
+const ReconcileEnv = "BROADCAST_RECONCILE"
+
+var reconcileModes = []string{"ranges", "iblt", "resend"}
+
+const (
+	rangeBits   = 4                      // each range splits into 1<<rangeBits sub-ranges
+	maxDepth    = 64 / rangeBits         // ranges at this depth are single hashes
+	leafSize    = 16                     // ranges this small are exchanged as values
+	syncTimeout = 500 * time.Millisecond // bound on each round of a session
+)
+
+// Maelstrom nodes read each message as one line of at most 64KB, so every
+// round is capped: at most maxValues values, or maxRanges digests, which
+// are about ten times the size of a value.
+const (
+	maxValues  = 2000
+	maxRanges  = maxValues / digestCost
+	digestCost = 10
+)
+
+// valueSet is a node's set of broadcast values, with the hash placing each
+// value in the ranges, and a strata estimator of the set kept up to date
+// for IBLT reconciliation. Values are also kept sorted by hash, re-sorted
+// lazily after adds, so a range's values are found by binary search.
+type valueSet struct {
+	mu     sync.Mutex
+	values map[float64]uint64
+	byHash []hashedValue
+	sorted bool
+	strata *iblt.Strata
+}
+
+type hashedValue struct {
+	hash  uint64
+	value float64
+}
+
+func newValueSet() *valueSet {
+	return &valueSet{
+		values: make(map[float64]uint64),
+		strata: iblt.NewStrata(iblt.DefaultStrata, iblt.DefaultStrataSize),
+	}
+}
+
+// add adds values to the set and returns those that were new.
+func (s *valueSet) add(values []float64) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var fresh []float64
+	for _, v := range values {
+		if _, ok := s.values[v]; !ok {
+			h := hashValue(v)
+			s.values[v] = h
+			s.byHash = append(s.byHash, hashedValue{h, v})
+			s.sorted = false
+			s.strata.Insert(math.Float64bits(v))
+			fresh = append(fresh, v)
+		}
+	}
+	return fresh
+}
+
+// list returns every value in the set.
+func (s *valueSet) list() []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]float64, 0, len(s.values))
+	for v := range s.values {
+		result = append(result, v)
+	}
+	return result
+}
+
+// within returns the values in r, sorted.
+func (s *valueSet) within(r keyRange) []float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sorted {
+		slices.SortFunc(s.byHash, func(a, b hashedValue) int { return cmp.Compare(a.hash, b.hash) })
+		s.sorted = true
+	}
+	lo, hi := r.bounds()
+	i, _ := slices.BinarySearchFunc(s.byHash, lo, func(e hashedValue, h uint64) int { return cmp.Compare(e.hash, h) })
+	var result []float64
+	for ; i < len(s.byHash) && s.byHash[i].hash <= hi; i++ {
+		result = append(result, s.byHash[i].value)
+	}
+	slices.Sort(result)
+	return result
+}
+
+// digest summarizes the values in r.
+func (s *valueSet) digest(r keyRange) rangeDigest {
+	values := s.within(r)
+	d := rangeDigest{keyRange: r, Count: len(values)}
+	if len(values) > 0 {
+		h := fnv.New64a()
+		for _, v := range values {
+			binary.Write(h, binary.BigEndian, math.Float64bits(v))
+		}
+		d.Hash = h.Sum64()
+	}
+	return d
+}
+
+func hashValue(v float64) uint64 {
+	h := fnv.New64a()
+	binary.Write(h, binary.BigEndian, math.Float64bits(v))
+	return h.Sum64()
+}
+
+// keyRange is the set of hashes whose top Depth*rangeBits bits are Prefix.
+type keyRange struct {
+	Prefix uint64 `json:"prefix,string"`
+	Depth  int    `json:"depth"`
+}
+
+// bounds returns the lowest and highest hash in r.
+func (r keyRange) bounds() (lo, hi uint64) {
+	if r.Depth == 0 {
+		return 0, math.MaxUint64
+	}
+	shift := 64 - rangeBits*r.Depth
+	return r.Prefix << shift, r.Prefix<<shift | (1<<shift - 1)
+}
+
+func (r keyRange) children() []keyRange {
+	children := make([]keyRange, 1<<rangeBits)
+	for i := range children {
+		children[i] = keyRange{Prefix: r.Prefix<<rangeBits | uint64(i), Depth: r.Depth + 1}
+	}
+	return children
+}
+
+// rangeDigest is the digest of one range. Empty ranges have a zero hash.
+type rangeDigest struct {
+	keyRange
+	Hash  uint64 `json:"hash,string"`
+	Count int    `json:"count"`
+}
+
+// syncRequest is one round of a session: the initiator's digests of the
+// ranges still in question, and values the peer was found to be missing.
+type syncRequest struct {
+	Ranges []rangeDigest `json:"ranges"`
+	Values []float64     `json:"values"`
+}
+
+// syncReply answers a round. Differing ranges that are small enough are
+// leaves: the peer sends all its values in them and expects the initiator's
+// missing ones back. Larger ones are split, with the peer's digests of
+// their non-empty children. Ranges the peer had no room for in its reply
+// are returned to be retried.
+type syncReply struct {
+	Values   []float64     `json:"values"`
+	Leaves   []keyRange    `json:"leaves"`
+	Split    []keyRange    `json:"split"`
+	Children []rangeDigest `json:"children"`
+	Retry    []keyRange    `json:"retry"`
+}
+
+// antiEntropy runs sync sessions with a node's neighbors.
+type antiEntropy struct {
+	n    *maelstrom.Node
+	set  *valueSet
+	mode string // one of reconcileModes
+
+	mu      sync.Mutex
+	running map[string]bool // neighbors with a session in progress
+}
+
+func newAntiEntropy(n *maelstrom.Node, set *valueSet, mode string) *antiEntropy {
+	a := &antiEntropy{n: n, set: set, mode: mode, running: make(map[string]bool)}
+	n.Handle("sync", a.handleSync)
+	n.Handle("reconcile_digest", a.handleDigest)
+	n.Handle("reconcile_iblt", a.handleIBLT)
+	n.Handle("reconcile_full", a.handleFull)
+	return a
+}
+
+// start syncs with every neighbor each AntiEntropyInterval.
+func (a *antiEntropy) start(neighbors func() []string) {
+	go func() {
+		for range time.Tick(AntiEntropyInterval) {
+			for _, peer := range neighbors() {
+				a.mu.Lock()
+				busy := a.running[peer]
+				a.running[peer] = true
+				a.mu.Unlock()
+				if busy {
+					continue
+				}
+				go func() {
+					if err := a.session(peer); err != nil {
+						log.Printf("anti-entropy with %s: %s", peer, err)
+					}
+					a.mu.Lock()
+					delete(a.running, peer)
+					a.mu.Unlock()
+				}()
+			}
+		}
+	}()
+}
+
+// session reconciles with peer in the configured mode.
+func (a *antiEntropy) session(peer string) error {
+	switch a.mode {
+	case "iblt":
+		return a.reconcile(peer)
+	case "resend":
+		return a.push(peer, keyRange{})
+	default:
+		return a.sync(peer)
+	}
+}
+
+// sync runs one session with peer, leaving both with the union of their
+// sets.
+func (a *antiEntropy) sync(peer string) error {
+	queue := []rangeDigest{a.set.digest(keyRange{})}
+	var pending []float64 // values the peer is missing
+	for len(queue) > 0 || len(pending) > 0 {
+		ranges := queue[:min(len(queue), maxRanges)]
+		queue = queue[len(ranges):]
+		push := pending[:min(len(pending), maxValues)]
+		pending = pending[len(push):]
+
+		msg, err := a.call(peer, map[string]any{
+			"type":   "sync",
+			"ranges": ranges,
+			"values": push,
+		})
+		if err != nil {
+			return err
+		}
+		var reply syncReply
+		if err := json.Unmarshal(msg.Body, &reply); err != nil {
+			return err
+		}
+
+		a.set.add(reply.Values)
+		theirs := make(map[float64]bool, len(reply.Values))
+		for _, v := range reply.Values {
+			theirs[v] = true
+		}
+		for _, leaf := range reply.Leaves {
+			for _, v := range a.set.within(leaf) {
+				if !theirs[v] {
+					pending = append(pending, v)
+				}
+			}
+		}
+
+		children := make(map[keyRange]rangeDigest, len(reply.Children))
+		for _, d := range reply.Children {
+			children[d.keyRange] = d
+		}
+		for _, parent := range reply.Split {
+			for _, child := range parent.children() {
+				mine := a.set.digest(child)
+				theirs := children[child]
+				if mine.Hash != theirs.Hash || mine.Count != theirs.Count {
+					queue = append(queue, mine)
+				}
+			}
+		}
+		for _, r := range reply.Retry {
+			queue = append(queue, a.set.digest(r))
+		}
+	}
+	return nil
+}
+
+// call sends one round to peer and waits up to syncTimeout for the reply.
+func (a *antiEntropy) call(peer string, body map[string]any) (maelstrom.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	return rpc.Call(ctx, a.n, peer, body)
+}
+
+// handleSync answers one round of a session started by a neighbor.
+func (a *antiEntropy) handleSync(msg maelstrom.Message) error {
+	var req syncRequest
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	a.set.add(req.Values)
+
+	reply := syncReply{Values: []float64{}}
+	budget := maxValues
+	for i, theirs := range req.Ranges {
+		if budget <= 0 {
+			for _, r := range req.Ranges[i:] {
+				reply.Retry = append(reply.Retry, r.keyRange)
+			}
+			break
+		}
+		mine := a.set.digest(theirs.keyRange)
+		small := mine.Count <= leafSize || theirs.Count <= leafSize || theirs.Depth == maxDepth
+		switch {
+		case mine.Hash == theirs.Hash && mine.Count == theirs.Count:
+		case small && mine.Count <= maxValues:
+			values := a.set.within(theirs.keyRange)
+			reply.Values = append(reply.Values, values...)
+			reply.Leaves = append(reply.Leaves, theirs.keyRange)
+			budget -= len(values)
+		default:
+			reply.Split = append(reply.Split, theirs.keyRange)
+			for _, child := range theirs.children() {
+				if d := a.set.digest(child); d.Count > 0 {
+					reply.Children = append(reply.Children, d)
+					budget -= digestCost
+				}
+			}
+		}
+	}
+
+	return a.n.Reply(msg, map[string]any{
+		"type":     "sync_ok",
+		"values":   reply.Values,
+		"leaves":   reply.Leaves,
+		"split":    reply.Split,
+		"children": reply.Children,
+		"retry":    reply.Retry,
+	})
+}
@@ -1,22 +1,83 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
-	"time"
-
 	"slices"
+	"sync"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/schema"
+	"maelstrom-lib/snapshot"
 )
 
 func main() {
 	n := maelstrom.NewNode()
 
-	var nums []float64
-	var neighbors []string
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
+	validator := schema.NewValidator(n, schema.Broadcast)
+
+	set := newValueSet()
+	var (
+		mu        sync.Mutex
+		neighbors []string
+	)
+
+	// Neighbors reconcile their sets periodically, so values lost to
+	// partitions are repaired at a cost proportional to what is missing.
+	mode := os.Getenv(ReconcileEnv)
+	if mode == "" {
+		mode = "ranges"
+	}
+	if !slices.Contains(reconcileModes, mode) {
+		log.Fatalf("%s: unknown mode %q, want one of %v", ReconcileEnv, mode, reconcileModes)
+	}
+	reconciler := newAntiEntropy(n, set, mode)
+
+	// New values are forwarded to neighbors and retransmitted until acked.
+	out := newOutbox(n)
+	forward := func(values []float64, from string) {
+		mu.Lock()
+		peers := make([]string, 0, len(neighbors))
+		for _, neighbor := range neighbors {
+			if neighbor != from {
+				peers = append(peers, neighbor)
+			}
+		}
+		mu.Unlock()
+		out.send(values, peers)
+	}
+
+	// Late joiners copy the set of seen messages from a peer instead of
+	// starting empty; installing a snapshot merges it into our own set.
+	transfer := snapshot.New(n, func() ([]byte, error) {
+		return json.Marshal(set.list())
+	}, func(data []byte) error {
+		var received []float64
+		if err := json.Unmarshal(data, &received); err != nil {
+			return err
+		}
+		set.add(received)
+		return nil
+	})
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		out.start()
+		reconciler.start(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return neighbors
+		})
+		go func() {
+			if err := transfer.Bootstrap(context.Background()); err != nil {
+				log.Printf("snapshot bootstrap: %s", err)
+			}
+		}()
+		return nil
+	})
+
+	validator.Handle("broadcast", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -25,27 +86,17 @@ func main() {
 		// extract the message from the body
 		message := body["message"].(float64)
 
-		// if the message is not already in the nums slice, add it to the slice and send it to all neighbors
-		if !slices.Contains(nums, message) {
-
-			for _, neighbor := range neighbors {
-				// send the message to all neighbors except the sender
-				if neighbor != msg.Src {
-					// send the message to the neighbor
-					n.Send(neighbor, body)
-				}
-			}
-			nums = append(nums, message)
-		}
+		// if the message is new, add it to the set and send it to all neighbors
+		forward(set.add([]float64{message}), msg.Src)
 
 		body["type"] = "broadcast_ok"
 		delete(body, "message")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+	validator.Handle("read", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -55,13 +106,13 @@ func main() {
 		// Update the message type.
 		body["type"] = "read_ok"
 		// add the "messages" key to the body
-		body["messages"] = nums
+		body["messages"] = set.list()
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
+	validator.Handle("topology", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -74,36 +125,32 @@ func main() {
 		topology := body["topology"].(map[string]any)
 		rawNeighbors := topology[n.ID()].([]any)
 
+		mu.Lock()
 		neighbors = make([]string, 0, len(rawNeighbors))
 		for _, val := range rawNeighbors {
 			neighbors = append(neighbors, val.(string))
 		}
+		mu.Unlock()
 		// remove the "topology" key from the body
 		delete(body, "topology")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
-	})
-
-	n.Handle("broadcast_ok", func(msg maelstrom.Message) error {
-		return nil
+		return validator.Reply(msg, body)
 	})
 
-	// Start a goroutine to send broadcast messages to neighbors every 2 seconds
-	go func() {
-		for {
-			// Wait for 2 seconds before sending the broadcast message
-			time.Sleep(2 * time.Second)
-			for _, message := range nums {
-				for _, neighbor := range neighbors {
-					n.Send(neighbor, map[string]any{
-						"type":    "broadcast",
-						"message": message,
-					})
-				}
-			}
+	n.Handle("deliver", func(msg maelstrom.Message) error {
+		var body struct {
+			Messages []float64 `json:"messages"`
 		}
-	}()
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		// The sender has these, so it needs neither them back nor to be
+		// waited on for them.
+		out.acked(msg.Src, body.Messages)
+		forward(set.add(body.Messages), msg.Src)
+		return n.Send(msg.Src, map[string]any{"type": "ack", "messages": body.Messages})
+	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
 	if err := n.Run(); err != nil {
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/iblt"
+)
+
+// IBLT reconciliation takes at most three rounds. The initiator first sends
+// the digest of its whole set; if the peer's differs, the peer answers with
+// its strata estimator. The initiator estimates the size of the difference,
+// sends IBLTs of its set sized for it, and the peer subtracts its own tables
+// and decodes exactly which values each side is missing. Values are their
+// own keys (the bits of the float64), so the decoded keys are the values to
+// transfer. A large difference is split across the hash ranges of one
+// depth, one table per range, so no message outgrows maxTableCells. Ranges
+// that fail to decode fall back to exchanging all their values.
+
+// maxTableCells bounds the IBLT cells sent in one message, about 40KB once
+// base64 encoded.
+const maxTableCells = 1500
+
+// strataSnapshot returns a copy of the set's estimator.
+func (s *valueSet) strataSnapshot() *iblt.Strata {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.strata.Clone()
+}
+
+// table returns an IBLT of the values in r with size cells.
+func (s *valueSet) table(r keyRange, size int) *iblt.Table {
+	t := iblt.New(size)
+	for _, v := range s.within(r) {
+		t.Insert(math.Float64bits(v))
+	}
+	return t
+}
+
+// rangeTable is the IBLT of one range.
+type rangeTable struct {
+	Range keyRange    `json:"range"`
+	Table *iblt.Table `json:"table"`
+}
+
+// reconcile runs an IBLT session with peer.
+func (a *antiEntropy) reconcile(peer string) error {
+	root := a.set.digest(keyRange{})
+	msg, err := a.call(peer, map[string]any{
+		"type":  "reconcile_digest",
+		"hash":  strconv.FormatUint(root.Hash, 10),
+		"count": root.Count,
+	})
+	if err != nil {
+		return err
+	}
+	var digest struct {
+		InSync bool         `json:"in_sync"`
+		Strata *iblt.Strata `json:"strata"`
+	}
+	if err := json.Unmarshal(msg.Body, &digest); err != nil {
+		return err
+	}
+	if digest.InSync {
+		return nil
+	}
+
+	// A peer whose estimator is missing or unlike ours gets the whole set
+	// exchanged, as a range that failed to decode would.
+	estimate, err := a.set.strataSnapshot().Estimate(digest.Strata)
+	if err != nil {
+		log.Printf("reconcile with %s: %s; exchanging everything", peer, err)
+		return a.exchange(peer, keyRange{})
+	}
+	parts := []keyRange{{}}
+	for iblt.SizeFor(estimate/len(parts)) > maxTableCells && parts[0].Depth < maxDepth {
+		var next []keyRange
+		for _, r := range parts {
+			next = append(next, r.children()...)
+		}
+		parts = next
+	}
+	size := iblt.SizeFor(estimate / len(parts))
+
+	var failed []keyRange
+	perMessage := max(1, maxTableCells/size)
+	for batch := range slices.Chunk(parts, perMessage) {
+		tables := make([]rangeTable, len(batch))
+		for i, r := range batch {
+			tables[i] = rangeTable{Range: r, Table: a.set.table(r, size)}
+		}
+		msg, err = a.call(peer, map[string]any{
+			"type":   "reconcile_iblt",
+			"tables": tables,
+		})
+		if err != nil {
+			return err
+		}
+		var decoded struct {
+			Values []float64  `json:"values"`
+			Failed []keyRange `json:"failed"`
+		}
+		if err := json.Unmarshal(msg.Body, &decoded); err != nil {
+			return err
+		}
+		a.set.add(decoded.Values)
+		failed = append(failed, decoded.Failed...)
+	}
+
+	// The estimate was too low for these; exchange them outright.
+	for _, r := range failed {
+		if err := a.exchange(peer, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// push sends peer every value in r, maxValues at a time.
+func (a *antiEntropy) push(peer string, r keyRange) error {
+	for values := range slices.Chunk(a.set.within(r), maxValues) {
+		if _, err := a.call(peer, map[string]any{"type": "reconcile_full", "values": values}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exchange leaves both sides with every value either has in r: it pushes
+// ours, then pages through the peer's.
+func (a *antiEntropy) exchange(peer string, r keyRange) error {
+	if err := a.push(peer, r); err != nil {
+		return err
+	}
+	body := map[string]any{"type": "reconcile_full", "range": r}
+	for {
+		msg, err := a.call(peer, body)
+		if err != nil {
+			return err
+		}
+		var page struct {
+			Values []float64 `json:"values"`
+			More   bool      `json:"more"`
+		}
+		if err := json.Unmarshal(msg.Body, &page); err != nil {
+			return err
+		}
+		a.set.add(page.Values)
+		if !page.More || len(page.Values) == 0 {
+			return nil
+		}
+		body["after"] = page.Values[len(page.Values)-1]
+	}
+}
+
+// handleDigest answers the first round: whether the sets match, and if not
+// the estimator to size the IBLTs with.
+func (a *antiEntropy) handleDigest(msg maelstrom.Message) error {
+	var req struct {
+		Hash  uint64 `json:"hash,string"`
+		Count int    `json:"count"`
+	}
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	if root := a.set.digest(keyRange{}); root.Hash == req.Hash && root.Count == req.Count {
+		return a.n.Reply(msg, map[string]any{"type": "reconcile_digest_ok", "in_sync": true})
+	}
+	return a.n.Reply(msg, map[string]any{
+		"type":   "reconcile_digest_ok",
+		"strata": a.set.strataSnapshot(),
+	})
+}
+
+// handleIBLT decodes the difference between each of the initiator's tables
+// and ours, takes the values only the initiator has and returns those only
+// we have, along with the ranges that did not decode. A missing or empty
+// table, or one sized unlike the first, counts as one that did not decode.
+func (a *antiEntropy) handleIBLT(msg maelstrom.Message) error {
+	var req struct {
+		Tables []rangeTable `json:"tables"`
+	}
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	ours := []float64{}
+	failed := []keyRange{}
+	size := 0
+	for _, t := range req.Tables {
+		if t.Table == nil || t.Table.Len() == 0 || size != 0 && t.Table.Len() != size {
+			failed = append(failed, t.Range)
+			continue
+		}
+		size = t.Table.Len()
+		diff, err := t.Table.Subtract(a.set.table(t.Range, t.Table.Len()))
+		if err != nil {
+			return err
+		}
+		added, removed, err := diff.Decode()
+		if err != nil {
+			failed = append(failed, t.Range)
+			continue
+		}
+		a.set.add(fromKeys(added))
+		ours = append(ours, fromKeys(removed)...)
+	}
+	return a.n.Reply(msg, map[string]any{
+		"type":   "reconcile_iblt_ok",
+		"values": ours,
+		"failed": failed,
+	})
+}
+
+// handleFull merges the values the sender pushed, and if it asked for a
+// range, returns the next page of ours in it after the value it last saw.
+func (a *antiEntropy) handleFull(msg maelstrom.Message) error {
+	var req struct {
+		Values []float64 `json:"values"`
+		Range  *keyRange `json:"range"`
+		After  *float64  `json:"after"`
+	}
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+	a.set.add(req.Values)
+	if req.Range == nil {
+		return a.n.Reply(msg, map[string]any{"type": "reconcile_full_ok"})
+	}
+
+	values := a.set.within(*req.Range)
+	if req.After != nil {
+		i, found := slices.BinarySearch(values, *req.After)
+		if found {
+			i++
+		}
+		values = values[i:]
+	}
+	more := len(values) > maxValues
+	return a.n.Reply(msg, map[string]any{
+		"type":   "reconcile_full_ok",
+		"values": values[:min(len(values), maxValues)],
+		"more":   more,
+	})
+}
+
+func fromKeys(keys []uint64) []float64 {
+	values := make([]float64, len(keys))
+	for i, k := range keys {
+		values[i] = math.Float64frombits(k)
+	}
+	return values
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+	"maelstrom-lib/iblt"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// pair is two nodes, n0 and n1, that reconcile in one mode when a client
+// asks n0 to.
+type pair struct {
+	c    *harness.Cluster
+	mu   sync.Mutex
+	sets map[string]*valueSet
+}
+
+// startPair starts the nodes; strata, if set, replaces n1's estimator.
+func startPair(tb testing.TB, mode string, strata *iblt.Strata) *pair {
+	tb.Helper()
+	p := &pair{sets: make(map[string]*valueSet)}
+	setup := func(n *maelstrom.Node) {
+		set := newValueSet()
+		a := newAntiEntropy(n, set, mode)
+		n.Handle("init", func(maelstrom.Message) error {
+			if n.ID() == "n1" && strata != nil {
+				set.strata = strata
+			}
+			p.mu.Lock()
+			p.sets[n.ID()] = set
+			p.mu.Unlock()
+			return nil
+		})
+		n.Handle("session", func(msg maelstrom.Message) error {
+			if err := a.session("n1"); err != nil {
+				return maelstrom.NewRPCError(maelstrom.Crash, err.Error())
+			}
+			return n.Reply(msg, map[string]any{"type": "session_ok"})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 2})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	p.c = c
+	return p
+}
+
+func (p *pair) set(id string) *valueSet {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.sets[id]
+}
+
+// fill gives both nodes shared values, and each node its own extras.
+func (p *pair) fill(shared, only0, only1 int) {
+	values := func(from, count int) []float64 {
+		vs := make([]float64, count)
+		for i := range vs {
+			vs[i] = float64(from + i)
+		}
+		return vs
+	}
+	common := values(0, shared)
+	p.set("n0").add(append(common, values(shared, only0)...))
+	p.set("n1").add(append(common, values(shared+only0, only1)...))
+}
+
+// session runs one reconciliation session from n0 with n1.
+func (p *pair) session(tb testing.TB) {
+	tb.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := p.c.RPC(ctx, "c1", "n0", map[string]any{"type": "session"}); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func sortedValues(s *valueSet) []float64 {
+	values := s.list()
+	slices.Sort(values)
+	return values
+}
+
+func TestSessionsConverge(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		strata *iblt.Strata
+	}{
+		{"ranges", "ranges", nil},
+		{"iblt", "iblt", nil},
+		// A peer whose estimator differs in shape falls back to a full
+		// exchange instead of failing the session.
+		{"iblt with a mismatched estimator", "iblt", iblt.NewStrata(iblt.DefaultStrata/2, iblt.DefaultStrataSize)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := startPair(t, tt.mode, tt.strata)
+			defer p.c.Stop()
+			p.fill(3000, 150, 150)
+			p.session(t)
+			got0, got1 := sortedValues(p.set("n0")), sortedValues(p.set("n1"))
+			if len(got0) != 3300 || !slices.Equal(got0, got1) {
+				t.Fatalf("after a session n0 has %d values and n1 %d, want 3300 each", len(got0), len(got1))
+			}
+		})
+	}
+}
+
+func TestResendCopiesEverything(t *testing.T) {
+	p := startPair(t, "resend", nil)
+	defer p.c.Stop()
+	p.fill(3000, 150, 0)
+	p.session(t)
+	if got := len(p.set("n1").list()); got != 3150 {
+		t.Fatalf("n1 has %d values after a resend, want 3150", got)
+	}
+}
+
+// BenchmarkReconcile compares the modes on sets of 10000 values of which
+// n1 is missing a few: resend's cost grows with the set, while ranges and
+// iblt grow with the difference. Besides time, it reports the messages and
+// bytes sent between the nodes per session.
+func BenchmarkReconcile(b *testing.B) {
+	const size = 10000
+	for _, mode := range reconcileModes {
+		for _, diff := range []int{10, 100, 1000} {
+			b.Run(fmt.Sprintf("%s/diff=%d", mode, diff), func(b *testing.B) {
+				var msgs, bytes int64
+				b.StopTimer()
+				for range b.N {
+					p := startPair(b, mode, nil)
+					p.fill(size-diff, diff, 0)
+					before := p.c.Stats()
+					b.StartTimer()
+					p.session(b)
+					b.StopTimer()
+					after := p.c.Stats()
+					msgs += after.ServerMessages - before.ServerMessages
+					bytes += after.ServerBytes - before.ServerBytes
+					if got := len(p.set("n1").list()); got != size {
+						b.Fatalf("n1 has %d values after a session, want %d", got, size)
+					}
+					p.c.Stop()
+				}
+				b.ReportMetric(float64(msgs)/float64(b.N), "msgs/op")
+				b.ReportMetric(float64(bytes)/float64(b.N), "wire-B/op")
+			})
+		}
+	}
+}
+
+func TestMalformedTablesFail(t *testing.T) {
+	p := startPair(t, "iblt", nil)
+	defer p.c.Stop()
+	p.fill(100, 0, 0)
+
+	ranges := keyRange{}.children()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := p.c.RPC(ctx, "c1", "n1", map[string]any{
+		"type": "reconcile_iblt",
+		"tables": []map[string]any{
+			{"range": ranges[0], "table": p.set("n0").table(ranges[0], 40)},
+			{"range": ranges[1], "table": nil},
+			{"range": ranges[1], "table": iblt.New(0)},
+			{"range": ranges[1], "table": p.set("n0").table(ranges[1], 80)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply struct {
+		Values []float64  `json:"values"`
+		Failed []keyRange `json:"failed"`
+	}
+	if err := json.Unmarshal(resp.Body, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Values) != 0 || !slices.Equal(reply.Failed, []keyRange{ranges[1], ranges[1], ranges[1]}) {
+		t.Fatalf("replied with values %v and failed ranges %v, want only the three malformed tables failed", reply.Values, reply.Failed)
+	}
+}
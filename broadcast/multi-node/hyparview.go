@@ -0,0 +1,490 @@
+package main
+
+import (
+	"encoding/json"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// HyParView (Leitão et al., "HyParView: a membership protocol for reliable
+// gossip-based broadcast") builds the overlay Plumtree runs on, in place of
+// Maelstrom's topology. Each node keeps a small symmetric active view, its
+// neighbors, and a larger passive view of backups. A new node joins through
+// a contact, which spreads it with random walks: the node lands in the
+// active view where a walk ends and in passive views along the way. When an
+// active neighbor stops answering heartbeats, it is replaced by asking
+// passive nodes in turn, and periodic shuffles keep passive views fresh
+// with random samples of the cluster.
+//
+// Maelstrom partitions look like failures to both sides, which refill their
+// views from their own side. A failed neighbor is therefore remembered as
+// lost for lostExpiry and probed with high-priority requests, which are
+// always accepted, so that links across a healed partition are restored.
+// Peers that stay silent longer, however long, are never forgotten: they
+// are probed now and then with low priority, so that a partition outlasting
+// lostExpiry still heals. A node whose passive view runs out asks any other
+// node, and one left without neighbors joins again, through each other
+// node in turn.
+
+const (
+	activeSize     = 5 // about log2(n)+1 for clusters of up to 25 nodes
+	passiveSize    = 30
+	activeWalk     = 6 // random walk length for joins and shuffles
+	passiveWalk    = 3 // joining nodes are added to passive views at this step
+	shuffleActive  = 3 // active peers included in a shuffle sample
+	shufflePassive = 4 // passive peers included in a shuffle sample
+
+	heartbeatInterval = 500 * time.Millisecond
+	failureTimeout    = 2 * time.Second // silence after which a neighbor has failed
+	neighborTimeout   = time.Second     // wait for a neighbor request to be answered
+	shuffleEvery      = 4               // heartbeats between shuffles
+	lostExpiry        = time.Minute     // how long failed neighbors are probed with high priority
+)
+
+// hyparview maintains one node's views. up and down are called as peers
+// join and leave the active view.
+type hyparview struct {
+	n          *maelstrom.Node
+	up, down   func(string)
+	lostExpiry time.Duration // lostExpiry, unless a test shortens it
+
+	mu       sync.Mutex
+	started  bool
+	active   map[string]time.Time // neighbors, by when we last heard from them
+	passive  map[string]bool
+	lost     map[string]time.Time // failed neighbors, by when they failed
+	failed   map[string]bool      // silent for longer; asked when nothing else is left
+	asking   string               // candidate with a neighbor request outstanding
+	askedAt  time.Time
+	contacts []string // other nodes to join through, in the order to try them
+	next     int      // index in contacts of the next to join through
+	joined   bool     // whether a contact has answered a join
+}
+
+func newHyParView(n *maelstrom.Node, up, down func(string)) *hyparview {
+	h := &hyparview{
+		n:          n,
+		up:         up,
+		down:       down,
+		lostExpiry: lostExpiry,
+		active:     make(map[string]time.Time),
+		passive:    make(map[string]bool),
+		lost:       make(map[string]time.Time),
+		failed:     make(map[string]bool),
+	}
+	n.Handle("join", h.whenStarted(h.handleJoin))
+	n.Handle("join_ok", h.whenStarted(h.handleJoinOK))
+	n.Handle("forward_join", h.whenStarted(h.handleForwardJoin))
+	n.Handle("neighbor", h.whenStarted(h.handleNeighbor))
+	n.Handle("neighbor_reply", h.whenStarted(h.handleNeighborReply))
+	n.Handle("disconnect", h.whenStarted(h.handleDisconnect))
+	n.Handle("heartbeat", h.whenStarted(h.handleHeartbeat))
+	n.Handle("shuffle", h.whenStarted(h.handleShuffle))
+	n.Handle("shuffle_reply", h.whenStarted(h.handleShuffleReply))
+	return h
+}
+
+// whenStarted drops messages from nodes that were initialized before us:
+// until start, answering them would race with the node learning its own
+// ID. Joining nodes retry until they have neighbors.
+func (h *hyparview) whenStarted(handle maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+	return func(msg maelstrom.Message) error {
+		h.mu.Lock()
+		started := h.started
+		h.mu.Unlock()
+		if !started {
+			return nil
+		}
+		return handle(msg)
+	}
+}
+
+// start joins the overlay, trying each other node in turn until one
+// answers, then sends heartbeats, repairs the active view and shuffles.
+func (h *hyparview) start() {
+	h.mu.Lock()
+	h.started = true
+	h.contacts = contacts(h.n.ID(), h.n.NodeIDs())
+	if len(h.contacts) == 0 {
+		h.mu.Unlock()
+		return
+	}
+	h.join()
+	h.mu.Unlock()
+
+	go func() {
+		tick := 0
+		for range time.Tick(heartbeatInterval) {
+			tick++
+			h.maintain(time.Now(), tick%shuffleEvery == 0)
+		}
+	}()
+}
+
+// contacts returns the nodes other than self, starting after it and
+// wrapping around, so that nodes starting together join through different
+// contacts rather than all through the first node.
+func contacts(self string, ids []string) []string {
+	i := slices.Index(ids, self)
+	return append(slices.Clone(ids[i+1:]), ids[:max(i, 0)]...)
+}
+
+// join asks the next contact to take this node into the overlay. Expects
+// h.mu.
+func (h *hyparview) join() {
+	contact := h.contacts[h.next%len(h.contacts)]
+	h.next++
+	h.n.Send(contact, map[string]any{"type": "join"})
+}
+
+func (h *hyparview) maintain(now time.Time, shuffle bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for peer, heard := range h.active {
+		if now.Sub(heard) > failureTimeout {
+			h.removeActive(peer)
+			h.lost[peer] = now
+		}
+	}
+	for peer := range h.active {
+		h.n.Send(peer, map[string]any{"type": "heartbeat"})
+	}
+
+	// Replace missing neighbors from the passive view, one request at a
+	// time; a passive peer that does not answer is presumed failed. With
+	// the passive view used up, any other node will do: failed peers, and
+	// nodes never heard of, such as those of a clique that lost every link
+	// to the rest.
+	if h.asking != "" && now.Sub(h.askedAt) > neighborTimeout {
+		if h.passive[h.asking] {
+			delete(h.passive, h.asking)
+			if _, ok := h.lost[h.asking]; !ok {
+				h.lost[h.asking] = now
+			}
+		}
+		h.asking = ""
+	}
+	if len(h.active) < activeSize && h.asking == "" {
+		candidates := keys(h.passive)
+		if len(candidates) == 0 {
+			candidates = slices.DeleteFunc(slices.Clone(h.contacts), func(id string) bool {
+				_, ok := h.active[id]
+				return ok
+			})
+		}
+		if peer, ok := pick(candidates); ok {
+			h.asking, h.askedAt = peer, now
+			h.requestNeighbor(peer, len(h.active) == 0)
+		}
+	}
+
+	for peer, failed := range h.lost {
+		if _, ok := h.active[peer]; ok {
+			delete(h.lost, peer)
+		} else if now.Sub(failed) > h.lostExpiry {
+			delete(h.lost, peer)
+			if !h.passive[peer] {
+				h.failed[peer] = true
+			}
+		}
+	}
+	if peer, ok := pick(keys(h.lost)); ok {
+		h.requestNeighbor(peer, true)
+	}
+
+	// Until a contact answers, or without neighbors, join again, through
+	// the next contact each time in case this one is down or cut off.
+	if !h.joined || len(h.active) == 0 {
+		h.join()
+	}
+
+	if shuffle {
+		// Failed peers that came back are taken on if they have room.
+		if peer, ok := pick(keys(h.failed)); ok {
+			h.requestNeighbor(peer, false)
+		}
+		if peer, ok := pick(keys(h.active)); ok {
+			h.n.Send(peer, map[string]any{
+				"type":   "shuffle",
+				"origin": h.n.ID(),
+				"ttl":    activeWalk,
+				"sample": h.sample(),
+			})
+		}
+	}
+}
+
+func (h *hyparview) requestNeighbor(peer string, high bool) {
+	priority := "low"
+	if high {
+		priority = "high"
+	}
+	h.n.Send(peer, map[string]any{"type": "neighbor", "priority": priority})
+}
+
+// addActive makes peer a neighbor, evicting a random one if the view is
+// full. Expects h.mu.
+func (h *hyparview) addActive(peer string) {
+	if peer == h.n.ID() {
+		return
+	}
+	if _, ok := h.active[peer]; ok {
+		return
+	}
+	if len(h.active) >= activeSize {
+		evicted, _ := pick(keys(h.active))
+		h.n.Send(evicted, map[string]any{"type": "disconnect"})
+		h.removeActive(evicted)
+	}
+	delete(h.passive, peer)
+	delete(h.lost, peer)
+	delete(h.failed, peer)
+	h.active[peer] = time.Now()
+	h.up(peer)
+}
+
+// removeActive demotes a neighbor to the passive view. Expects h.mu.
+func (h *hyparview) removeActive(peer string) {
+	if _, ok := h.active[peer]; !ok {
+		return
+	}
+	delete(h.active, peer)
+	h.down(peer)
+	h.addPassive(peer)
+}
+
+// addPassive adds peer to the passive view, evicting a random one if the
+// view is full. Expects h.mu.
+func (h *hyparview) addPassive(peer string) {
+	if peer == h.n.ID() || h.passive[peer] {
+		return
+	}
+	if _, ok := h.active[peer]; ok {
+		return
+	}
+	if len(h.passive) >= passiveSize {
+		evicted, _ := pick(keys(h.passive))
+		delete(h.passive, evicted)
+	}
+	delete(h.failed, peer)
+	h.passive[peer] = true
+}
+
+// sample returns this node and a few random active and passive peers, to
+// offer in a shuffle. Expects h.mu.
+func (h *hyparview) sample() []string {
+	sample := []string{h.n.ID()}
+	active, passive := keys(h.active), keys(h.passive)
+	rand.Shuffle(len(active), func(i, j int) { active[i], active[j] = active[j], active[i] })
+	rand.Shuffle(len(passive), func(i, j int) { passive[i], passive[j] = passive[j], passive[i] })
+	sample = append(sample, active[:min(len(active), shuffleActive)]...)
+	return append(sample, passive[:min(len(passive), shufflePassive)]...)
+}
+
+// handleJoin takes a new node as a neighbor and walks it through the
+// overlay from every other neighbor.
+func (h *hyparview) handleJoin(msg maelstrom.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.addActive(msg.Src)
+	h.requestNeighbor(msg.Src, true)
+	for peer := range h.active {
+		if peer != msg.Src {
+			h.n.Send(peer, map[string]any{"type": "forward_join", "node": msg.Src, "ttl": activeWalk})
+		}
+	}
+	return h.n.Send(msg.Src, map[string]any{"type": "join_ok"})
+}
+
+// handleJoinOK stops trying further contacts.
+func (h *hyparview) handleJoinOK(msg maelstrom.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.joined = true
+	return nil
+}
+
+// handleForwardJoin continues a join's random walk, taking the joining node
+// as a neighbor where the walk ends.
+func (h *hyparview) handleForwardJoin(msg maelstrom.Message) error {
+	var body struct {
+		Node string `json:"node"`
+		TTL  int    `json:"ttl"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	if body.Node == h.n.ID() {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if body.TTL == passiveWalk {
+		h.addPassive(body.Node)
+	}
+	var next []string
+	for peer := range h.active {
+		if peer != msg.Src && peer != body.Node {
+			next = append(next, peer)
+		}
+	}
+	peer, ok := pick(next)
+	if body.TTL == 0 || len(h.active) <= 1 || !ok {
+		h.addActive(body.Node)
+		h.requestNeighbor(body.Node, true)
+		return nil
+	}
+	return h.n.Send(peer, map[string]any{"type": "forward_join", "node": body.Node, "ttl": body.TTL - 1})
+}
+
+// handleNeighbor accepts the sender as a neighbor if it asked with high
+// priority, because it has no neighbors or lost us to a failure, or if
+// there is room.
+func (h *hyparview) handleNeighbor(msg maelstrom.Message) error {
+	var body struct {
+		Priority string `json:"priority"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, accepted := h.active[msg.Src]
+	if !accepted && (body.Priority == "high" || len(h.active) < activeSize) {
+		h.addActive(msg.Src)
+		accepted = true
+	}
+	if !accepted {
+		h.addPassive(msg.Src)
+	}
+	return h.n.Send(msg.Src, map[string]any{"type": "neighbor_reply", "accepted": accepted})
+}
+
+func (h *hyparview) handleNeighborReply(msg maelstrom.Message) error {
+	var body struct {
+		Accepted bool `json:"accepted"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.asking == msg.Src {
+		h.asking = ""
+	}
+	delete(h.lost, msg.Src)
+	if body.Accepted {
+		h.addActive(msg.Src)
+	} else {
+		h.addPassive(msg.Src)
+	}
+	return nil
+}
+
+// handleDisconnect demotes a neighbor that dropped us from its view.
+func (h *hyparview) handleDisconnect(msg maelstrom.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.removeActive(msg.Src)
+	return nil
+}
+
+// handleHeartbeat keeps a neighbor alive. A heartbeat from a node that is
+// not our neighbor means the views disagree: we take it on if there is
+// room and otherwise tell it to drop us.
+func (h *hyparview) handleHeartbeat(msg maelstrom.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.active[msg.Src]; ok {
+		h.active[msg.Src] = time.Now()
+		return nil
+	}
+	if len(h.active) < activeSize {
+		h.addActive(msg.Src)
+		return nil
+	}
+	return h.n.Send(msg.Src, map[string]any{"type": "disconnect"})
+}
+
+// handleShuffle continues a shuffle's random walk. Where it ends, the
+// origin is sent a sample of our passive view, and we keep its sample.
+func (h *hyparview) handleShuffle(msg maelstrom.Message) error {
+	var body struct {
+		Origin string   `json:"origin"`
+		TTL    int      `json:"ttl"`
+		Sample []string `json:"sample"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var next []string
+	for peer := range h.active {
+		if peer != msg.Src && peer != body.Origin {
+			next = append(next, peer)
+		}
+	}
+	if peer, ok := pick(next); ok && body.TTL > 0 {
+		return h.n.Send(peer, map[string]any{
+			"type":   "shuffle",
+			"origin": body.Origin,
+			"ttl":    body.TTL - 1,
+			"sample": body.Sample,
+		})
+	}
+
+	passive := keys(h.passive)
+	rand.Shuffle(len(passive), func(i, j int) { passive[i], passive[j] = passive[j], passive[i] })
+	reply := passive[:min(len(passive), len(body.Sample))]
+	if body.Origin != h.n.ID() {
+		h.n.Send(body.Origin, map[string]any{"type": "shuffle_reply", "sample": reply})
+	}
+	for _, peer := range body.Sample {
+		h.addPassive(peer)
+	}
+	return nil
+}
+
+func (h *hyparview) handleShuffleReply(msg maelstrom.Message) error {
+	var body struct {
+		Sample []string `json:"sample"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, peer := range body.Sample {
+		h.addPassive(peer)
+	}
+	return nil
+}
+
+// keys returns the keys of m in order.
+func keys[V any](m map[string]V) []string {
+	result := make([]string, 0, len(m))
+	for k := range m {
+		result = append(result, k)
+	}
+	slices.Sort(result)
+	return result
+}
+
+// pick returns a random element of s, if there is one.
+func pick(s []string) (string, bool) {
+	if len(s) == 0 {
+		return "", false
+	}
+	return s[rand.IntN(len(s))], true
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// startOverlay runs nodes that only build the HyParView overlay, with lost
+// neighbors probed for just a second, so that a short partition outlasts
+// the high-priority probes as a long one would.
+func startOverlay(t *testing.T, nodes int) *harness.Cluster {
+	t.Helper()
+	setup := func(n *maelstrom.Node) {
+		h := newHyParView(n, func(string) {}, func(string) {})
+		h.lostExpiry = time.Second
+		n.Handle("init", func(maelstrom.Message) error {
+			h.start()
+			return nil
+		})
+		n.Handle("view", func(msg maelstrom.Message) error {
+			h.mu.Lock()
+			active := keys(h.active)
+			h.mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "view_ok", "active": active})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	return c
+}
+
+// views returns the active view of each of nodes.
+func views(t *testing.T, c *harness.Cluster, nodes []string) map[string][]string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result := make(map[string][]string, len(nodes))
+	for _, id := range nodes {
+		resp, err := c.RPC(ctx, "c1", id, map[string]any{"type": "view"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Active []string `json:"active"`
+		}
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		result[id] = body.Active
+	}
+	return result
+}
+
+// components groups nodes by which of them their active views connect,
+// counting only links between nodes.
+func components(views map[string][]string) [][]string {
+	links := make(map[string][]string)
+	for id, active := range views {
+		for _, peer := range active {
+			if _, ok := views[peer]; ok {
+				links[id] = append(links[id], peer)
+				links[peer] = append(links[peer], id)
+			}
+		}
+	}
+	var result [][]string
+	seen := make(map[string]bool)
+	for _, id := range keys(views) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		component := []string{}
+		for queue := []string{id}; len(queue) > 0; queue = queue[1:] {
+			component = append(component, queue[0])
+			for _, peer := range links[queue[0]] {
+				if !seen[peer] {
+					seen[peer] = true
+					queue = append(queue, peer)
+				}
+			}
+		}
+		slices.Sort(component)
+		result = append(result, component)
+	}
+	return result
+}
+
+// waitConnected waits until the active views of nodes form one connected
+// overlay, and every node's neighbors are among nodes.
+func waitConnected(t *testing.T, c *harness.Cluster, nodes []string, within time.Duration, what string) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for {
+		current := views(t, c, nodes)
+		parts := components(current)
+		stale := false
+		for _, active := range current {
+			for _, peer := range active {
+				stale = stale || !slices.Contains(nodes, peer)
+			}
+		}
+		if len(parts) == 1 && !stale {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s, the overlay is split into %v with views %v", what, parts, current)
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+func nodeIDs(from, to int) []string {
+	var ids []string
+	for i := from; i < to; i++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	return ids
+}
+
+func TestOverlayHealsAfterPartition(t *testing.T) {
+	c := startOverlay(t, 10)
+	waitConnected(t, c, c.NodeIDs(), 5*time.Second, "after starting")
+
+	// Long enough for every link across to fail and stop being probed
+	// with high priority.
+	left, right := nodeIDs(0, 5), nodeIDs(5, 10)
+	c.Partition(left, right)
+	deadline := time.Now().Add(10 * time.Second)
+	for len(components(views(t, c, c.NodeIDs()))) == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("the overlay stayed connected across a partition")
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	time.Sleep(2 * time.Second)
+
+	c.Heal()
+	waitConnected(t, c, c.NodeIDs(), 15*time.Second, "after healing the partition")
+}
+
+func TestOverlaySurvivesContactFailure(t *testing.T) {
+	c := startOverlay(t, 6)
+	waitConnected(t, c, c.NodeIDs(), 5*time.Second, "after starting")
+
+	// n1 is the first contact of n0, which restarts with empty views and
+	// has to join through another node.
+	if err := c.Kill("n1"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Restart(ctx, "n0"); err != nil {
+		t.Fatal(err)
+	}
+	waitConnected(t, c, c.Alive(), 15*time.Second, "after killing n1 and restarting n0")
+}
+
+func TestLargeOverlaySurvivesKills(t *testing.T) {
+	c := startOverlay(t, 30)
+	waitConnected(t, c, c.NodeIDs(), 15*time.Second, "after starting")
+
+	// Kill a sixth of the nodes at once, spread across the cluster, so that
+	// many survivors lose active neighbors together.
+	for _, id := range []string{"n2", "n7", "n13", "n19", "n24"} {
+		if err := c.Kill(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitConnected(t, c, c.Alive(), 20*time.Second, "after killing five nodes")
+}
@@ -1,21 +1,94 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
+	"sync"
 
 	"slices"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/schema"
+	"maelstrom-lib/snapshot"
 )
 
 func main() {
 	n := maelstrom.NewNode()
+	register(n)
 
-	var nums []float64
-	var neighbors []string
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
+	// Execute the node's message loop. This will run until STDIN is closed.
+	if err := n.Run(); err != nil {
+		log.Printf("ERROR: %s", err)
+		os.Exit(1)
+	}
+}
+
+// register sets up the broadcast node on n.
+func register(n *maelstrom.Node) {
+	validator := schema.NewValidator(n, schema.Broadcast)
+
+	var mu sync.Mutex
+	nums := []float64{}
+	seen := make(map[float64]bool)
+	deliver := func(message float64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if seen[message] {
+			return false
+		}
+		seen[message] = true
+		nums = append(nums, message)
+		return true
+	}
+	has := func(message float64) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[message]
+	}
+
+	all := func() []float64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(nums)
+	}
+
+	// Values spread along a Plumtree broadcast tree over a HyParView
+	// overlay, which the nodes build themselves instead of using the
+	// topology Maelstrom sends.
+	tree := newPlumtree(n, deliver, has, all)
+	membership := newHyParView(n, tree.neighborUp, tree.neighborDown)
+
+	// Late joiners copy the set of seen messages from a peer instead of
+	// starting empty; installing a snapshot merges it into our own set.
+	transfer := snapshot.New(n, func() ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return json.Marshal(nums)
+	}, func(data []byte) error {
+		var received []float64
+		if err := json.Unmarshal(data, &received); err != nil {
+			return err
+		}
+		for _, message := range received {
+			deliver(message)
+		}
+		return nil
+	})
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		tree.start()
+		membership.start()
+		go func() {
+			if err := transfer.Bootstrap(context.Background()); err != nil {
+				log.Printf("snapshot bootstrap: %s", err)
+			}
+		}()
+		return nil
+	})
+
+	validator.Handle("broadcast", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -24,27 +97,19 @@ func main() {
 		// extract the message from the body
 		message := body["message"].(float64)
 
-		// if the message is not already in the nums slice, add it to the slice and send it to all neighbors
-		if !slices.Contains(nums, message) {
-
-			for _, neighbor := range neighbors {
-				// send the message to all neighbors except the sender
-				if neighbor != msg.Src {
-					// send the message to the neighbor
-					n.Send(neighbor, body)
-				}
-			}
-			nums = append(nums, message)
+		// if the message is new, add it to the set and send it down the tree
+		if deliver(message) {
+			tree.broadcast(message, n.ID(), msg.Src)
 		}
 
 		body["type"] = "broadcast_ok"
 		delete(body, "message")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+	validator.Handle("read", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -54,39 +119,28 @@ func main() {
 		// Update the message type.
 		body["type"] = "read_ok"
 		// add the "messages" key to the body
-		body["messages"] = nums
+		mu.Lock()
+		body["messages"] = slices.Clone(nums)
+		mu.Unlock()
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
+	validator.Handle("topology", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
-		// Update the message type.
+		// Update the message type. The suggested topology is ignored, since
+		// HyParView chooses each node's neighbors.
 		body["type"] = "topology_ok"
-		// store the neighbors in the node
-		topology := body["topology"].(map[string]any)
-		rawNeighbors := topology[n.ID()].([]any)
-
-		neighbors = make([]string, 0, len(rawNeighbors))
-		for _, val := range rawNeighbors {
-			neighbors = append(neighbors, val.(string))
-		}
 		// remove the "topology" key from the body
 		delete(body, "topology")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
-
-	// Execute the node's message loop. This will run until STDIN is closed.
-	if err := n.Run(); err != nil {
-		log.Printf("ERROR: %s", err)
-		os.Exit(1)
-	}
 }
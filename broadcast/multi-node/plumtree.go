@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Plumtree (Leitão et al., "Epidemic Broadcast Trees") broadcasts along
+// spanning trees that emerge from the overlay, one per root: the node a
+// client broadcast a value to. Every neighbor starts as an eager peer, sent
+// each new value in full. A peer that sends us a value we already had is on
+// a redundant path for that value's root: we PRUNE it, and both ends demote
+// the link to lazy for the root, over which values are only announced in
+// batched IHAVE messages. If an announced value does not arrive through the
+// tree within graftTimeout, we GRAFT the announcer, promoting the link back
+// to eager and asking it for the value, which repairs the tree. A single
+// tree shared by every root would never settle: values from different
+// roots meet on different edges of the same cycle and prune them all.
+//
+// Maelstrom drops messages across partitions without telling anyone, so
+// every value sent or announced to a peer is also acknowledged in batches;
+// values a peer has not acked are re-announced every announceRetry until it
+// does, which heals whatever a partition cut off.
+
+const (
+	lazyInterval  = 250 * time.Millisecond // IHAVE batches are flushed this often
+	ackEvery      = 4                      // lazy intervals between ack batches
+	graftTimeout  = 500 * time.Millisecond // wait for an announced value before grafting
+	announceRetry = 2 * time.Second        // re-announce values a peer has not acked
+	maxBatch      = 2000                   // values per message, within Maelstrom's 64KB lines
+)
+
+// missingValue is a value we have been told of but not received.
+type missingValue struct {
+	root     string
+	sources  []string // announcers, the next to graft first
+	deadline time.Time
+}
+
+// plumtree runs the protocol for one node. deliver adds a value to the
+// node's set and reports whether it was new; has reports whether it is
+// already there, and all lists the set.
+type plumtree struct {
+	n       *maelstrom.Node
+	deliver func(float64) bool
+	has     func(float64) bool
+	all     func() []float64
+
+	mu        sync.Mutex
+	neighbors map[string]bool
+	lazy      map[string]map[string]bool       // neighbors demoted to lazy, by root
+	roots     map[float64]string               // the root of each value we have
+	ihave     map[string][]float64             // announcements to flush, by peer
+	acks      map[string][]float64             // acknowledgements to flush, by peer
+	unacked   map[string]map[float64]time.Time // values sent or announced, by peer
+	missing   map[float64]*missingValue
+}
+
+func newPlumtree(n *maelstrom.Node, deliver, has func(float64) bool, all func() []float64) *plumtree {
+	t := &plumtree{
+		n:         n,
+		deliver:   deliver,
+		has:       has,
+		all:       all,
+		neighbors: make(map[string]bool),
+		lazy:      make(map[string]map[string]bool),
+		roots:     make(map[float64]string),
+		ihave:     make(map[string][]float64),
+		acks:      make(map[string][]float64),
+		unacked:   make(map[string]map[float64]time.Time),
+		missing:   make(map[float64]*missingValue),
+	}
+	n.Handle("gossip", t.handleGossip)
+	n.Handle("ihave", t.handleIHave)
+	n.Handle("graft", t.handleGraft)
+	n.Handle("prune", t.handlePrune)
+	n.Handle("ack", t.handleAck)
+	return t
+}
+
+// neighborUp adds a new neighbor as an eager peer for every root. It may
+// have missed any of our values, for instance across a partition, so all
+// of them are announced to it; it grafts whichever it lacks.
+func (t *plumtree) neighborUp(peer string) {
+	values := t.all()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.neighbors[peer] = true
+	for _, lazy := range t.lazy {
+		delete(lazy, peer)
+	}
+	now := time.Now()
+	t.unacked[peer] = make(map[float64]time.Time, len(values))
+	for _, v := range values {
+		t.unacked[peer][v] = now
+	}
+	t.ihave[peer] = append(t.ihave[peer], values...)
+}
+
+// neighborDown forgets a neighbor that left the membership view.
+func (t *plumtree) neighborDown(peer string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.neighbors, peer)
+	for _, lazy := range t.lazy {
+		delete(lazy, peer)
+	}
+	delete(t.unacked, peer)
+	delete(t.ihave, peer)
+	delete(t.acks, peer)
+	for v, m := range t.missing {
+		m.sources = slices.DeleteFunc(m.sources, func(s string) bool { return s == peer })
+		if len(m.sources) == 0 {
+			delete(t.missing, v)
+		}
+	}
+}
+
+// broadcast sends a value new to this node on to its neighbors, except the
+// one it came from: in full to those eager for its root and as an
+// announcement to the rest.
+func (t *plumtree) broadcast(v float64, root, from string) {
+	t.mu.Lock()
+	t.roots[v] = root
+	now := time.Now()
+	var eager []string
+	for peer := range t.neighbors {
+		if peer == from {
+			continue
+		}
+		if t.lazy[root][peer] {
+			t.ihave[peer] = append(t.ihave[peer], v)
+		} else {
+			eager = append(eager, peer)
+		}
+		t.unacked[peer][v] = now
+	}
+	t.mu.Unlock()
+
+	for _, peer := range eager {
+		t.n.Send(peer, map[string]any{"type": "gossip", "message": v, "root": root})
+	}
+}
+
+// promote makes peer eager for root and demote makes it lazy. Both expect
+// t.mu.
+func (t *plumtree) promote(root, peer string) {
+	delete(t.lazy[root], peer)
+}
+
+func (t *plumtree) demote(root, peer string) {
+	if !t.neighbors[peer] {
+		return
+	}
+	if t.lazy[root] == nil {
+		t.lazy[root] = make(map[string]bool)
+	}
+	t.lazy[root][peer] = true
+}
+
+// received notes that peer has values, so they need neither acking by it
+// nor announcing to it, and queues our acknowledgement. Expects t.mu.
+func (t *plumtree) received(peer string, values []float64) {
+	for _, v := range values {
+		delete(t.unacked[peer], v)
+	}
+	t.acks[peer] = append(t.acks[peer], values...)
+}
+
+// start flushes batches, grafts missing values and re-announces unacked
+// ones every lazyInterval.
+func (t *plumtree) start() {
+	go func() {
+		tick := 0
+		for range time.Tick(lazyInterval) {
+			tick++
+			t.flush(time.Now(), tick%ackEvery == 0)
+		}
+	}()
+}
+
+func (t *plumtree) flush(now time.Time, withAcks bool) {
+	t.mu.Lock()
+	grafts := make(map[string][]float64)
+	for v, m := range t.missing {
+		if t.has(v) {
+			delete(t.missing, v) // arrived some other way, e.g. a snapshot
+			continue
+		}
+		if now.Before(m.deadline) {
+			continue
+		}
+		peer := m.sources[0]
+		m.sources = append(m.sources[1:], peer)
+		m.deadline = now.Add(graftTimeout)
+		t.promote(m.root, peer)
+		grafts[peer] = append(grafts[peer], v)
+	}
+	for peer, values := range t.unacked {
+		for v, sent := range values {
+			if now.Sub(sent) >= announceRetry {
+				t.ihave[peer] = append(t.ihave[peer], v)
+				values[v] = now
+			}
+		}
+	}
+	// Announcements carry each value's root, so that a graft promotes the
+	// link in the right tree.
+	type announcement struct {
+		peer   string
+		values []float64
+		roots  []string
+	}
+	var ihave []announcement
+	for peer, values := range t.ihave {
+		for batch := range slices.Chunk(values, maxBatch) {
+			a := announcement{peer: peer, values: batch, roots: make([]string, len(batch))}
+			for i, v := range batch {
+				a.roots[i] = t.roots[v]
+			}
+			ihave = append(ihave, a)
+		}
+	}
+	t.ihave = make(map[string][]float64)
+	var acks map[string][]float64
+	if withAcks {
+		acks = t.acks
+		t.acks = make(map[string][]float64)
+	}
+	t.mu.Unlock()
+
+	for _, a := range ihave {
+		t.n.Send(a.peer, map[string]any{"type": "ihave", "messages": a.values, "roots": a.roots})
+	}
+	send := func(kind string, batches map[string][]float64) {
+		for peer, values := range batches {
+			for batch := range slices.Chunk(values, maxBatch) {
+				t.n.Send(peer, map[string]any{"type": kind, "messages": batch})
+			}
+		}
+	}
+	send("graft", grafts)
+	send("ack", acks)
+}
+
+// handleGossip delivers a value pushed to us. A duplicate means the sender
+// is not our parent in the root's tree, so the link is pruned for it.
+func (t *plumtree) handleGossip(msg maelstrom.Message) error {
+	var body struct {
+		Message float64 `json:"message"`
+		Root    string  `json:"root"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+	v := body.Message
+
+	fresh := t.deliver(v)
+	t.mu.Lock()
+	t.received(msg.Src, []float64{v})
+	if fresh {
+		delete(t.missing, v)
+		t.promote(body.Root, msg.Src)
+	} else {
+		t.demote(body.Root, msg.Src)
+	}
+	t.mu.Unlock()
+
+	if !fresh {
+		return t.n.Send(msg.Src, map[string]any{"type": "prune", "root": body.Root})
+	}
+	t.broadcast(v, body.Root, msg.Src)
+	return nil
+}
+
+// handleIHave notes announced values we lack, to be grafted if they do not
+// arrive in time.
+func (t *plumtree) handleIHave(msg maelstrom.Message) error {
+	var body struct {
+		Messages []float64 `json:"messages"`
+		Roots    []string  `json:"roots"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.received(msg.Src, body.Messages)
+	for i, v := range body.Messages {
+		if t.has(v) {
+			continue
+		}
+		m := t.missing[v]
+		if m == nil {
+			m = &missingValue{deadline: time.Now().Add(graftTimeout)}
+			if i < len(body.Roots) {
+				m.root = body.Roots[i]
+			}
+			t.missing[v] = m
+		}
+		if !slices.Contains(m.sources, msg.Src) {
+			m.sources = append(m.sources, msg.Src)
+		}
+	}
+	return nil
+}
+
+// handleGraft makes the sender eager again for the roots of the values it
+// asked for, and sends them.
+func (t *plumtree) handleGraft(msg maelstrom.Message) error {
+	var body struct {
+		Messages []float64 `json:"messages"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	for _, v := range body.Messages {
+		if !t.has(v) {
+			continue
+		}
+		t.mu.Lock()
+		root := t.roots[v]
+		t.promote(root, msg.Src)
+		t.mu.Unlock()
+		t.n.Send(msg.Src, map[string]any{"type": "gossip", "message": v, "root": root})
+	}
+	return nil
+}
+
+// handlePrune demotes the sender to lazy for a root.
+func (t *plumtree) handlePrune(msg maelstrom.Message) error {
+	var body struct {
+		Root string `json:"root"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.demote(body.Root, msg.Src)
+	return nil
+}
+
+// handleAck forgets values the sender has confirmed.
+func (t *plumtree) handleAck(msg maelstrom.Message) error {
+	var body struct {
+		Messages []float64 `json:"messages"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, v := range body.Messages {
+		delete(t.unacked[msg.Src], v)
+	}
+	return nil
+}
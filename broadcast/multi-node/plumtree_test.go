@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+)
+
+// broadcastTo sends values to nodes in turn, as clients do.
+func broadcastTo(t *testing.T, c *harness.Cluster, nodes []string, values ...int) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, v := range values {
+		if _, err := c.RPC(ctx, "c1", nodes[i%len(nodes)], map[string]any{"type": "broadcast", "message": v}); err != nil {
+			t.Fatalf("broadcast %d: %s", v, err)
+		}
+	}
+}
+
+// read returns the values node id has, sorted.
+func read(t *testing.T, c *harness.Cluster, id string) []int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := c.RPC(ctx, "c1", id, map[string]any{"type": "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Messages []int `json:"messages"`
+	}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(body.Messages)
+	return body.Messages
+}
+
+// waitRead waits until every one of nodes reads exactly want.
+func waitRead(t *testing.T, c *harness.Cluster, nodes []string, want []int, within time.Duration, what string) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for _, id := range nodes {
+		for {
+			got := read(t, c, id)
+			if slices.Equal(got, want) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s, %s reads %v, want %v", what, id, got, want)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+func TestBroadcastHealsAfterPartition(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, register, harness.Options{NodeCount: 8, Latency: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	ids := c.NodeIDs()
+
+	broadcastTo(t, c, ids, 1, 2, 3, 4)
+	waitRead(t, c, ids, []int{1, 2, 3, 4}, 5*time.Second, "before the partition")
+
+	// Each side keeps spreading its own values, which the other cannot
+	// hear of until the partition heals.
+	left, right := ids[:4], ids[4:]
+	c.Partition(left, right)
+	broadcastTo(t, c, left, 10, 11, 12)
+	broadcastTo(t, c, right, 20, 21, 22)
+	waitRead(t, c, left, []int{1, 2, 3, 4, 10, 11, 12}, 5*time.Second, "in the partition")
+	waitRead(t, c, right, []int{1, 2, 3, 4, 20, 21, 22}, 5*time.Second, "in the partition")
+
+	c.Heal()
+	waitRead(t, c, ids, []int{1, 2, 3, 4, 10, 11, 12, 20, 21, 22}, 20*time.Second, "after healing")
+}
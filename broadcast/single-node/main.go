@@ -6,14 +6,17 @@ import (
 	"os"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/schema"
 )
 
 func main() {
 	n := maelstrom.NewNode()
 
-	var nums []float64
+	validator := schema.NewValidator(n, schema.Broadcast)
+
+	nums := []float64{}
 	// var topology map[string]any
-	n.Handle("broadcast", func(msg maelstrom.Message) error {
+	validator.Handle("broadcast", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -27,10 +30,10 @@ func main() {
 		delete(body, "message")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("read", func(msg maelstrom.Message) error {
+	validator.Handle("read", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -43,10 +46,10 @@ func main() {
 		body["messages"] = nums
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
-	n.Handle("topology", func(msg maelstrom.Message) error {
+	validator.Handle("topology", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -59,7 +62,7 @@ func main() {
 		delete(body, "topology")
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
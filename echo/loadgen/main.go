@@ -0,0 +1,151 @@
+// Command loadgen drives echo requests against a cluster of node binaries to
+// measure the baseline cost of the transport, before any workload logic.
+//
+// In closed-loop mode each of -concurrency clients sends its next request as
+// soon as the previous reply arrives, so throughput adapts to the nodes. In
+// open-loop mode requests are issued at -rate regardless of replies, and
+// latency is measured from when each request was due, so a stalled node
+// shows up in the tail instead of silently lowering the rate.
+//
+// Usage:
+//
+//	loadgen -bin ~/go/bin/maelstrom-echo -node-count 3 -mode open -rate 2000 -payload 256 -duration 10s
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"maelstrom-lib/harness"
+	"maelstrom-lib/histogram"
+)
+
+func main() {
+	var (
+		bin         = flag.String("bin", "", "path to the node binary; any binary that answers echo requests")
+		nodeCount   = flag.Int("node-count", 1, "number of nodes")
+		mode        = flag.String("mode", "closed", "closed: each client waits for its reply; open: issue requests at -rate")
+		concurrency = flag.Int("concurrency", 4, "number of clients in closed-loop mode")
+		rate        = flag.Float64("rate", 1000, "requests per second in open-loop mode")
+		payload     = flag.Int("payload", 16, "size of the echo payload in bytes")
+		duration    = flag.Duration("duration", 10*time.Second, "how long to generate load for")
+		warmup      = flag.Duration("warmup", time.Second, "load to discard before measuring")
+		timeout     = flag.Duration("timeout", 5*time.Second, "request timeout")
+		latency     = flag.Duration("latency", 0, "maximum random delay on inter-node messages")
+	)
+	flag.Parse()
+
+	if *bin == "" || (*mode != "open" && *mode != "closed") {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	cluster, err := harness.Start(ctx, *bin, harness.Options{NodeCount: *nodeCount, Latency: *latency})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g := &generator{
+		cluster: cluster,
+		payload: strings.Repeat("x", *payload),
+		timeout: *timeout,
+		hist:    histogram.New(),
+	}
+	start := time.Now()
+	g.measureFrom = start.Add(*warmup)
+	deadline := g.measureFrom.Add(*duration)
+	if *mode == "open" {
+		g.openLoop(deadline, *rate)
+	} else {
+		g.closedLoop(deadline, *concurrency)
+	}
+	elapsed := time.Since(g.measureFrom)
+
+	if err := cluster.Stop(); err != nil {
+		log.Printf("stop: %s", err)
+	}
+
+	stats := cluster.Stats()
+	ok, failed := g.hist.Count(), g.failed.Load()
+	fmt.Printf("mode:        %s, %d node(s), %d byte payload\n", *mode, *nodeCount, *payload)
+	fmt.Printf("requests:    %d ok, %d failed in %s\n", ok, failed, elapsed.Round(time.Millisecond))
+	fmt.Printf("throughput:  %.1f req/s\n", float64(ok)/elapsed.Seconds())
+	fmt.Printf("messages:    %d client, %d server\n", stats.ClientMessages, stats.ServerMessages)
+	fmt.Println()
+	g.hist.Report(os.Stdout, "ms", 1000)
+}
+
+type generator struct {
+	cluster     *harness.Cluster
+	payload     string
+	timeout     time.Duration
+	measureFrom time.Time
+	next        atomic.Int64 // round-robins requests over the nodes
+
+	failed atomic.Int64
+
+	mu   sync.Mutex
+	hist *histogram.Histogram // latencies in microseconds
+}
+
+// request sends one echo as client and records its latency measured from
+// due, the time the request should have been sent.
+func (g *generator) request(client string, due time.Time) {
+	ids := g.cluster.NodeIDs()
+	node := ids[int(g.next.Add(1))%len(ids)]
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	_, err := g.cluster.RPC(ctx, client, node, map[string]any{"type": "echo", "echo": g.payload})
+	if due.Before(g.measureFrom) {
+		return // warming up
+	}
+	if err != nil {
+		g.failed.Add(1)
+		return
+	}
+
+	g.mu.Lock()
+	g.hist.Record(time.Since(due).Microseconds())
+	g.mu.Unlock()
+}
+
+// closedLoop runs concurrency clients back to back until deadline.
+func (g *generator) closedLoop(deadline time.Time, concurrency int) {
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		client := fmt.Sprintf("c%d", i+1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				g.request(client, time.Now())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// openLoop issues requests on a fixed schedule until deadline, each from its
+// own goroutine so that slow replies do not delay later requests.
+func (g *generator) openLoop(deadline time.Time, rate float64) {
+	interval := time.Duration(float64(time.Second) / rate)
+	var wg sync.WaitGroup
+	for due, i := time.Now(), 0; due.Before(deadline); due, i = due.Add(interval), i+1 {
+		time.Sleep(time.Until(due))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.request(fmt.Sprintf("c%d", i%64+1), due)
+		}()
+	}
+	wg.Wait()
+}
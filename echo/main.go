@@ -6,13 +6,17 @@ import (
 	"os"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/probe"
+	"maelstrom-lib/schema"
 )
 
 func main() {
 	n := maelstrom.NewNode()
 
+	validator := schema.NewValidator(n, schema.Echo)
+
 	// Register a handler for the "echo" message that responds with an "echo_ok".
-	n.Handle("echo", func(msg maelstrom.Message) error {
+	validator.Handle("echo", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -23,7 +27,22 @@ func main() {
 		body["type"] = "echo_ok"
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
+	})
+
+	// Ping every other node in the background to learn round-trip times.
+	prober := probe.New(n)
+	n.Handle("init", func(msg maelstrom.Message) error {
+		prober.Start()
+		return nil
+	})
+
+	// Report the RTTs in milliseconds between every pair of nodes measured so far.
+	validator.Handle("rtt_matrix", func(msg maelstrom.Message) error {
+		return validator.Reply(msg, map[string]any{
+			"type":   "rtt_matrix_ok",
+			"matrix": prober.Matrix(),
+		})
 	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
@@ -0,0 +1,142 @@
+// Package counter serves the g-counter workload: a grow-only counter kept
+// in seq-kv, with each node adding to its own key.
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand"
+	"sync"
+	"time"
+
+	"slices"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/dedup"
+	"maelstrom-lib/schema"
+)
+
+// Register registers the g-counter handlers on n.
+func Register(n *maelstrom.Node) {
+	validator := schema.NewValidator(n, schema.GCounter)
+	kv := maelstrom.NewSeqKV(n)
+
+	// Remember add replies so that a retried add is not counted twice,
+	// whichever node the retry reaches.
+	adds := dedup.New(validator, maelstrom.NewLinKV(n), dedup.DefaultOptions)
+	var registerMu sync.Mutex
+	registered := false
+
+	// backoff sleeps before retrying a failed compare-and-swap, twice as
+	// long each attempt up to a limit, with jitter so that contending
+	// nodes spread out.
+	backoff := func(attempt int) {
+		d := min(5*time.Millisecond<<min(attempt, 7), 500*time.Millisecond)
+		time.Sleep(d/2 + time.Duration(rand.Int63n(int64(d/2))))
+	}
+
+	registerSelfIfNeeded := func(nodeId string) {
+		registerMu.Lock()
+		defer registerMu.Unlock()
+		if registered {
+			return
+		}
+
+		ctx := context.Background()
+		for attempt := 0; ; attempt++ {
+			// Read current value + version
+			val, _ := kv.Read(ctx, "participants")
+
+			// extract participants from the value; the KV hands back JSON
+			// arrays as []any, not []string
+			participants := []string{}
+			if ids, ok := val.([]any); ok {
+				for _, id := range ids {
+					participants = append(participants, id.(string))
+				}
+			}
+
+			// Check if self is already registered
+			if slices.Contains(participants, nodeId) {
+				registered = true
+				return
+			}
+
+			newParticipants := append(participants, nodeId)
+
+			// Try to atomically update with CAS
+			err := kv.CompareAndSwap(ctx, "participants", participants, newParticipants, true)
+			if err == nil {
+				registered = true
+				return
+			}
+
+			// Retry on CAS failure
+			backoff(attempt)
+		}
+	}
+
+	validator.Handle("read", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		total := 0
+		val, _ := kv.Read(context.Background(), "participants")
+
+		// extract participants from the value
+		participants, ok := val.([]any)
+		if ok {
+			for _, id := range participants {
+				// Add count of each participant; a participant that has
+				// not written its count yet has added nothing
+				count, err := kv.ReadInt(context.Background(), id.(string))
+				if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+					return err
+				}
+				total += count
+			}
+		}
+
+		return validator.Reply(msg, map[string]any{
+			"type":  "read_ok",
+			"value": total,
+		})
+	})
+
+	validator.Handle("add", adds.Wrap(func(msg maelstrom.Message) error {
+		// The node ID is only known once the node has been initialized.
+		key := n.ID()
+		registerSelfIfNeeded(key)
+
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		delta := int(body["delta"].(float64))
+
+		for attempt := 0; ; attempt++ {
+			value, err := kv.ReadInt(context.Background(), key)
+			if err != nil && maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+				return err
+			}
+
+			// CAS rather than write, so concurrent adds on this node
+			// cannot overwrite each other
+			err = kv.CompareAndSwap(context.Background(), key, value, value+delta, true)
+			if err == nil {
+				break
+			}
+			if maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+				return err
+			}
+			backoff(attempt)
+		}
+
+		return adds.Reply(msg, map[string]any{
+			"type": "add_ok",
+		})
+	}))
+}
@@ -0,0 +1,87 @@
+package counter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/dedup"
+	"maelstrom-lib/harness"
+)
+
+// TestConcurrentAddsAreCounted checks the adds of every node, made at once
+// and retried on other nodes, all count once. Register runs before
+// Maelstrom tells each node its ID.
+func TestConcurrentAddsAreCounted(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, Register, harness.Options{NodeCount: 3, Latency: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	ids := c.NodeIDs()
+
+	const perNode = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, len(ids)*perNode)
+	for i, id := range ids {
+		for j := range perNode {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				req := map[string]any{"type": "add", "delta": 1, dedup.KeyField: fmt.Sprintf("%s-%d", id, j)}
+				if _, err := c.RPC(ctx, "c1", id, req); err != nil {
+					errs <- err
+					return
+				}
+				// The client retries, having missed the reply, through the
+				// next node.
+				if _, err := c.RPC(ctx, "c1", ids[(i+1)%len(ids)], req); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Each node counts under its own key, so every one of them registered.
+	resp, err := c.RPC(ctx, "c1", "seq-kv", map[string]any{"type": "read", "key": "participants"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var participants struct {
+		Value []string `json:"value"`
+	}
+	if err := json.Unmarshal(resp.Body, &participants); err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(participants.Value)
+	if !slices.Equal(participants.Value, ids) {
+		t.Fatalf("participants %v, want %v", participants.Value, ids)
+	}
+
+	for _, id := range ids {
+		resp, err := c.RPC(ctx, "c1", id, map[string]any{"type": "read"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Value int `json:"value"`
+		}
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if want := len(ids) * perNode; body.Value != want {
+			t.Fatalf("%s reads %d, want %d", id, body.Value, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// kafkaKeys is the number of distinct logs the kafka workload writes to.
+const kafkaKeys = 5
+
+// kafkaWorkload checks that offsets are unique per key, that polls agree
+// with acknowledged sends, that committed offsets were actually committed by
+// some client and that every acknowledged send is still in the log at the
+// end.
+type kafkaWorkload struct {
+	noSetup
+	anomalies
+	next atomic.Int64
+
+	mu        sync.Mutex
+	sends     map[string]map[int64]int64 // key -> offset -> msg
+	committed map[string]int64           // highest attempted commit per key
+	cursors   map[string]map[string]int64
+}
+
+func newKafkaWorkload() workload {
+	return &kafkaWorkload{
+		sends:     make(map[string]map[int64]int64),
+		committed: make(map[string]int64),
+		cursors:   make(map[string]map[string]int64),
+	}
+}
+
+func (w *kafkaWorkload) op(ctx context.Context, c *client) error {
+	key := fmt.Sprintf("k%d", c.rand.Intn(kafkaKeys))
+	switch p := c.rand.Intn(100); {
+	case p < 50:
+		return w.send(ctx, c, key)
+	case p < 75:
+		return w.poll(ctx, c, key)
+	case p < 90:
+		return w.commit(ctx, c, key)
+	default:
+		return w.listCommitted(ctx, c, key)
+	}
+}
+
+func (w *kafkaWorkload) send(ctx context.Context, c *client, key string) error {
+	msg := w.next.Add(1)
+	var resp struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "send", "key": key, "msg": msg}, &resp); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.sends[key] == nil {
+		w.sends[key] = make(map[int64]int64)
+	}
+	if prev, ok := w.sends[key][resp.Offset]; ok {
+		w.add("%s offset %d assigned to both %d and %d", key, resp.Offset, prev, msg)
+		return nil
+	}
+	w.sends[key][resp.Offset] = msg
+	return nil
+}
+
+func (w *kafkaWorkload) poll(ctx context.Context, c *client, key string) error {
+	w.mu.Lock()
+	if w.cursors[c.id] == nil {
+		w.cursors[c.id] = make(map[string]int64)
+	}
+	from := w.cursors[c.id][key]
+	w.mu.Unlock()
+
+	msgs, err := w.pollFrom(ctx, c, c.node(), key, from)
+	if err != nil {
+		return err
+	}
+	if len(msgs) > 0 {
+		w.mu.Lock()
+		w.cursors[c.id][key] = msgs[len(msgs)-1][0] + 1
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+// pollFrom polls key from offset and checks the reply against the sends.
+func (w *kafkaWorkload) pollFrom(ctx context.Context, c *client, node, key string, from int64) ([][2]int64, error) {
+	var resp struct {
+		Msgs map[string][][2]int64 `json:"msgs"`
+	}
+	if err := c.rpc(ctx, node, map[string]any{"type": "poll", "offsets": map[string]int64{key: from}}, &resp); err != nil {
+		return nil, err
+	}
+
+	msgs := resp.Msgs[key]
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	prev := from - 1
+	for _, m := range msgs {
+		offset, msg := m[0], m[1]
+		if offset <= prev {
+			w.add("poll of %s from %d returned offset %d after %d", key, from, offset, prev)
+		}
+		prev = offset
+		if sent, ok := w.sends[key][offset]; ok && sent != msg {
+			w.add("poll of %s returned %d at offset %d, but %d was sent there", key, msg, offset, sent)
+		}
+	}
+	return msgs, nil
+}
+
+func (w *kafkaWorkload) commit(ctx context.Context, c *client, key string) error {
+	w.mu.Lock()
+	offset, ok := w.cursors[c.id][key]
+	w.mu.Unlock()
+	if !ok || offset == 0 {
+		return w.poll(ctx, c, key)
+	}
+
+	offset-- // commit the last offset this client consumed
+	w.mu.Lock()
+	w.committed[key] = max(w.committed[key], offset)
+	w.mu.Unlock()
+	return c.rpc(ctx, c.node(), map[string]any{"type": "commit_offsets", "offsets": map[string]int64{key: offset}}, nil)
+}
+
+func (w *kafkaWorkload) listCommitted(ctx context.Context, c *client, key string) error {
+	var resp struct {
+		Offsets map[string]int64 `json:"offsets"`
+	}
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "list_committed_offsets", "keys": []string{key}}, &resp); err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	ceiling, committed := w.committed[key]
+	w.mu.Unlock()
+	if got, ok := resp.Offsets[key]; ok && got > 0 && (!committed || got > ceiling) {
+		w.add("committed offset of %s is %d, but no client committed past %d", key, got, ceiling)
+	}
+	return nil
+}
+
+// check reads every log from the start and looks for lost sends.
+func (w *kafkaWorkload) check(ctx context.Context, c *client) error {
+	var errs []error
+	node := c.cluster.Alive()[0] // n0 may have been killed
+
+	w.mu.Lock()
+	keys := sortedKeys(w.sends)
+	w.mu.Unlock()
+	for _, key := range keys {
+		seen := make(map[int64]bool)
+		for from := int64(0); ; {
+			msgs, err := w.pollFrom(ctx, c, node, key, from)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("final poll of %s: %w", key, err))
+				break
+			}
+			if len(msgs) == 0 {
+				break
+			}
+			for _, m := range msgs {
+				seen[m[0]] = true
+			}
+			from = msgs[len(msgs)-1][0] + 1
+		}
+
+		w.mu.Lock()
+		lost := 0
+		for offset := range w.sends[key] {
+			if !seen[offset] {
+				lost++
+			}
+		}
+		total := len(w.sends[key])
+		w.mu.Unlock()
+		if lost > 0 {
+			errs = append(errs, fmt.Errorf("%s lost %d of %d acknowledged sends", key, lost, total))
+		}
+	}
+	return errors.Join(append([]error{w.err()}, errs...)...)
+}
@@ -0,0 +1,318 @@
+// Command gloomer-test runs a workload against compiled node binaries without
+// Maelstrom. It starts the nodes as child processes, routes their messages,
+// drives client operations at a fixed rate and checks the results.
+//
+// Usage:
+//
+//	gloomer-test -w broadcast -bin ~/go/bin/maelstrom-broadcast -node-count 5 -time-limit 20s -rate 100
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/harness"
+)
+
+func main() {
+	var (
+		workloadName = flag.String("w", "", "workload: echo, unique-ids, broadcast, g-counter, kafka or txn")
+		bin          = flag.String("bin", "", "path to the node binary")
+		nodeCount    = flag.Int("node-count", 1, "number of nodes")
+		timeLimit    = flag.Duration("time-limit", 10*time.Second, "how long to generate operations for")
+		rate         = flag.Float64("rate", 10, "client operations per second, across all clients")
+		concurrency  = flag.Int("concurrency", 2, "number of concurrent clients")
+		latency      = flag.Duration("latency", 0, "maximum random delay on inter-node messages")
+		settle       = flag.Duration("settle", 3*time.Second, "time to wait for convergence before the final checks")
+		timeout      = flag.Duration("timeout", 5*time.Second, "client request timeout")
+		nodeLogs     = flag.Bool("log-stderr", false, "copy node logs to stderr")
+		restart      = flag.String("restart", "", "node to kill and restart with empty state halfway through the run")
+		kill         = flag.Int("kill", 0, "number of random nodes to kill for good halfway through the run")
+		partition    = flag.Bool("partition", false, "split the nodes into two random halves for the middle third of the run")
+		namespace    = flag.String("namespace", "", "prefix request types with this workload namespace, for nodes hosting several workloads")
+		retries      = flag.Int("retries", 0, "resend failed requests, each time to a random live node, up to this many times, with an idempotency key")
+		strategy     = flag.String("strategy", "", "unique-ids: ID strategy to request, \"mixed\" for a random one per request or \"sequence\" for gap-free numbers")
+	)
+	flag.Parse()
+
+	w, ok := workloads[*workloadName]
+	if !ok || *bin == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	every, err := tickInterval(*rate)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	idStrategy = *strategy
+
+	var stderr io.Writer
+	if *nodeLogs {
+		stderr = os.Stderr
+	}
+
+	ctx := context.Background()
+	cluster, err := harness.Start(ctx, *bin, harness.Options{
+		NodeCount: *nodeCount,
+		Latency:   *latency,
+		Stderr:    stderr,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	r := &runner{
+		cluster:   cluster,
+		w:         w(),
+		timeout:   *timeout,
+		namespace: *namespace,
+		retries:   *retries,
+	}
+	admin := r.client("c0", nil)
+	if err := r.w.setup(ctx, admin); err != nil {
+		cluster.Stop()
+		log.Fatalf("setup: %s", err)
+	}
+
+	if *restart != "" {
+		time.AfterFunc(*timeLimit/2, func() {
+			log.Printf("restarting %s", *restart)
+			if err := cluster.Restart(ctx, *restart); err != nil {
+				log.Printf("restart: %s", err)
+				return
+			}
+			// The new process has missed setup messages such as the topology.
+			if err := r.w.setup(ctx, admin); err != nil {
+				log.Printf("setup after restart: %s", err)
+			}
+		})
+	}
+
+	if *kill > 0 {
+		time.AfterFunc(*timeLimit/2, func() {
+			ids := slices.DeleteFunc(slices.Clone(cluster.NodeIDs()), func(id string) bool { return id == *restart })
+			rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+			for _, id := range ids[:min(*kill, len(ids)-1)] {
+				log.Printf("killing %s", id)
+				if err := cluster.Kill(id); err != nil {
+					log.Printf("kill: %s", err)
+				}
+			}
+		})
+	}
+
+	if *partition {
+		time.AfterFunc(*timeLimit/3, func() {
+			ids := slices.Clone(cluster.NodeIDs())
+			rand.Shuffle(len(ids), func(i, j int) { ids[i], ids[j] = ids[j], ids[i] })
+			log.Printf("partitioning %v from %v", ids[:len(ids)/2], ids[len(ids)/2:])
+			cluster.Partition(ids[:len(ids)/2], ids[len(ids)/2:])
+		})
+		time.AfterFunc(*timeLimit*2/3, func() {
+			log.Printf("healing partition")
+			cluster.Heal()
+		})
+	}
+
+	start := time.Now()
+	r.run(ctx, *timeLimit, every, *concurrency)
+	elapsed := time.Since(start)
+
+	time.Sleep(*settle)
+	checkErr := r.w.check(ctx, admin)
+	stopErr := cluster.Stop()
+
+	r.report(elapsed)
+	if err := errors.Join(checkErr, stopErr); err != nil {
+		fmt.Printf("INVALID: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Println("Everything looks good!")
+}
+
+// client issues requests on behalf of one simulated Maelstrom client.
+type client struct {
+	id        string
+	cluster   *harness.Cluster
+	timeout   time.Duration
+	namespace string
+	retries   int
+	rand      *rand.Rand
+	requests  int
+}
+
+// rpc sends body to node and decodes the reply into out, if non-nil. With
+// retries enabled, failed requests are resent with the same idempotency key
+// to a random live node, which need not be the one that saw the original.
+func (c *client) rpc(ctx context.Context, node string, body map[string]any, out any) error {
+	if c.namespace != "" {
+		body["type"] = c.namespace + "." + body["type"].(string)
+	}
+	if c.retries > 0 {
+		c.requests++
+		body["idempotency_key"] = fmt.Sprintf("%s-%d", c.id, c.requests)
+	}
+
+	var msg maelstrom.Message
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			node = c.node()
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		msg, err = c.cluster.RPC(attemptCtx, c.id, node, body)
+		cancel()
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return decode(msg.Body, out)
+}
+
+// node picks a random live node to send the next request to.
+func (c *client) node() string {
+	ids := c.cluster.Alive()
+	return ids[c.rand.Intn(len(ids))]
+}
+
+type runner struct {
+	cluster   *harness.Cluster
+	w         workload
+	timeout   time.Duration
+	namespace string
+	retries   int
+
+	ok, failed atomic.Int64
+
+	mu        sync.Mutex
+	latencies []time.Duration
+}
+
+// tickInterval returns the time between operations at rate per second. Rates
+// too high for the clock get an operation every nanosecond, which is as
+// fast as the clients can go anyway.
+func tickInterval(rate float64) (time.Duration, error) {
+	if !(rate > 0) || math.IsInf(rate, 1) {
+		return 0, fmt.Errorf("-rate must be a positive number, not %v", rate)
+	}
+	every := float64(time.Second) / rate
+	if every > float64(math.MaxInt64/2) {
+		return 0, fmt.Errorf("-rate %v is too low to ever send an operation", rate)
+	}
+	return max(time.Duration(every), time.Nanosecond), nil
+}
+
+// run generates an operation every interval until the time limit expires.
+func (r *runner) run(ctx context.Context, limit, every time.Duration, concurrency int) {
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+
+	tokens := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				close(tokens)
+				return
+			case <-ticker.C:
+				select {
+				case tokens <- struct{}{}:
+				default: // every client is busy; drop the tick
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		c := r.client(fmt.Sprintf("c%d", i+1), rand.New(rand.NewSource(int64(i))))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range tokens {
+				start := time.Now()
+				if err := r.w.op(context.Background(), c); err != nil {
+					log.Printf("%s: %s", c.id, err)
+					r.failed.Add(1)
+					continue
+				}
+				r.ok.Add(1)
+				r.mu.Lock()
+				r.latencies = append(r.latencies, time.Since(start))
+				r.mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (r *runner) client(id string, rnd *rand.Rand) *client {
+	return &client{
+		id:        id,
+		cluster:   r.cluster,
+		timeout:   r.timeout,
+		namespace: r.namespace,
+		retries:   r.retries,
+		rand:      rnd,
+	}
+}
+
+func (r *runner) report(elapsed time.Duration) {
+	stats := r.cluster.Stats()
+	ok, failed := r.ok.Load(), r.failed.Load()
+
+	fmt.Printf("ops:           %d ok, %d failed in %s\n", ok, failed, elapsed.Round(time.Millisecond))
+	if ok+failed > 0 {
+		fmt.Printf("msgs-per-op:   %.2f (server), %.2f (client)\n",
+			float64(stats.ServerMessages)/float64(ok+failed),
+			float64(stats.ClientMessages)/float64(ok+failed))
+		fmt.Printf("bytes-per-op:  %.0f (server)\n", float64(stats.ServerBytes)/float64(ok+failed))
+	}
+	if stats.Dropped > 0 {
+		fmt.Printf("dropped:       %d messages across partitions or to killed nodes\n", stats.Dropped)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.latencies) == 0 {
+		return
+	}
+	slices.Sort(r.latencies)
+	quantile := func(q float64) time.Duration {
+		return r.latencies[int(q*float64(len(r.latencies)-1))]
+	}
+	fmt.Printf("latency:       p50=%s p95=%s p99=%s max=%s\n",
+		quantile(0.5), quantile(0.95), quantile(0.99), r.latencies[len(r.latencies)-1])
+}
+
+// sortedKeys returns the keys of m in order, for stable error messages.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/harness"
+)
+
+func TestTickInterval(t *testing.T) {
+	tests := []struct {
+		rate float64
+		want time.Duration // zero for an invalid rate
+	}{
+		{10, 100 * time.Millisecond},
+		{0.5, 2 * time.Second},
+		{1e9, time.Nanosecond},
+		// Faster than the clock ticks; it used to panic the ticker.
+		{1e12, time.Nanosecond},
+		{math.Inf(1), 0},
+		{0, 0},
+		{-1, 0},
+		{math.NaN(), 0},
+		{1e-300, 0},
+	}
+	for _, tt := range tests {
+		got, err := tickInterval(tt.rate)
+		if tt.want == 0 {
+			if err == nil {
+				t.Errorf("tickInterval(%v) = %s, want an error", tt.rate, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("tickInterval(%v) = %s, %v, want %s", tt.rate, got, err, tt.want)
+		}
+	}
+}
+
+// node is an in-process stand-in for a workload binary. Those marked
+// broken get something wrong that the workload's check must catch.
+type node func(n *maelstrom.Node)
+
+// echoNode echoes requests; broken, it shouts them back.
+func echoNode(broken bool) node {
+	return func(n *maelstrom.Node) {
+		n.Handle("echo", func(msg maelstrom.Message) error {
+			var body map[string]any
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			body["type"] = "echo_ok"
+			if broken {
+				body["echo"] = strings.ToUpper(body["echo"].(string))
+			}
+			return n.Reply(msg, body)
+		})
+	}
+}
+
+// uniqueIDsNode numbers its IDs; broken, it never moves on from the first.
+func uniqueIDsNode(broken bool) node {
+	return func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		next := 0
+		n.Handle("generate", func(msg maelstrom.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !broken {
+				next++
+			}
+			return n.Reply(msg, map[string]any{"type": "generate_ok", "id": fmt.Sprintf("%s-%d", n.ID(), next)})
+		})
+		n.Handle("generate_batch", func(msg maelstrom.Message) error {
+			var body struct {
+				Count int `json:"count"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			var ids []string
+			for range body.Count {
+				if !broken {
+					next++
+				}
+				ids = append(ids, fmt.Sprintf("%s-%d", n.ID(), next))
+			}
+			return n.Reply(msg, map[string]any{"type": "generate_batch_ok", "ids": ids})
+		})
+	}
+}
+
+// broadcastNode keeps what it is sent; broken, it forgets every other value.
+func broadcastNode(broken bool) node {
+	return func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		var seen []int64
+		n.Handle("topology", func(msg maelstrom.Message) error {
+			return n.Reply(msg, map[string]any{"type": "topology_ok"})
+		})
+		n.Handle("broadcast", func(msg maelstrom.Message) error {
+			var body struct {
+				Message int64 `json:"message"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mu.Lock()
+			if !broken || body.Message%2 == 0 {
+				seen = append(seen, body.Message)
+			}
+			mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "broadcast_ok"})
+		})
+		n.Handle("read", func(msg maelstrom.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "read_ok", "messages": seen})
+		})
+	}
+}
+
+// counterNode sums adds; broken, it counts each add as one.
+func counterNode(broken bool) node {
+	return func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		var total int64
+		n.Handle("add", func(msg maelstrom.Message) error {
+			var body struct {
+				Delta int64 `json:"delta"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			if broken {
+				body.Delta = 1
+			}
+			mu.Lock()
+			total += body.Delta
+			mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "add_ok"})
+		})
+		n.Handle("read", func(msg maelstrom.Message) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "read_ok", "value": total})
+		})
+	}
+}
+
+// TestChecks runs workloads against single in-process nodes and checks
+// that each check passes correct nodes and catches broken ones.
+func TestChecks(t *testing.T) {
+	tests := []struct {
+		workload string
+		node     func(broken bool) node
+		want     string // in the check error of the broken node
+	}{
+		{"echo", echoNode, "anomalies"},
+		{"unique-ids", uniqueIDsNode, "duplicated IDs"},
+		{"broadcast", broadcastNode, "missing"},
+		{"g-counter", counterNode, "expected between"},
+	}
+	for _, tt := range tests {
+		for _, broken := range []bool{false, true} {
+			err := runAgainst(t, tt.workload, tt.node(broken))
+			switch {
+			case !broken && err != nil:
+				t.Errorf("%s check of a correct node: %v", tt.workload, err)
+			case broken && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("%s check of a broken node: %v, want an error about %q", tt.workload, err, tt.want)
+			}
+		}
+	}
+}
+
+// runAgainst drives the workload against one node running setup for a
+// moment and returns the result of its check.
+func runAgainst(t *testing.T, workload string, setup node) error {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cluster, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Stop()
+
+	r := &runner{cluster: cluster, w: workloads[workload](), timeout: time.Second}
+	admin := r.client("c0", rand.New(rand.NewSource(0)))
+	if err := r.w.setup(ctx, admin); err != nil {
+		t.Fatal(err)
+	}
+	r.run(ctx, 300*time.Millisecond, 5*time.Millisecond, 2)
+	if r.ok.Load() == 0 {
+		t.Fatalf("%s: no operation succeeded", workload)
+	}
+	return r.w.check(ctx, admin)
+}
+
+func TestRetriesKeepTheIdempotencyKey(t *testing.T) {
+	type attempt struct {
+		Type string `json:"type"`
+		Key  string `json:"idempotency_key"`
+	}
+	var mu sync.Mutex
+	var attempts []attempt
+	setup := func(n *maelstrom.Node) {
+		n.Handle("g.add", func(msg maelstrom.Message) error {
+			var a attempt
+			if err := json.Unmarshal(msg.Body, &a); err != nil {
+				return err
+			}
+			mu.Lock()
+			attempts = append(attempts, a)
+			first := len(attempts) == 1
+			mu.Unlock()
+			if first {
+				return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "try again")
+			}
+			return n.Reply(msg, map[string]any{"type": "g.add_ok"})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cluster, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Stop()
+
+	r := &runner{cluster: cluster, timeout: time.Second, namespace: "g", retries: 2}
+	c := r.client("c1", rand.New(rand.NewSource(0)))
+	if err := c.rpc(ctx, "n0", map[string]any{"type": "add", "delta": 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.rpc(ctx, "n0", map[string]any{"type": "add", "delta": 1}, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := []attempt{{"g.add", "c1-1"}, {"g.add", "c1-1"}, {"g.add", "c1-2"}}
+	mu.Lock()
+	defer mu.Unlock()
+	if !slices.Equal(attempts, want) {
+		t.Fatalf("node saw %v, want %v", attempts, want)
+	}
+}
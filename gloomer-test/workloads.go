@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// workload generates client operations and checks the results.
+type workload interface {
+	// setup runs once after the nodes are initialized.
+	setup(ctx context.Context, c *client) error
+
+	// op performs a single client operation. Errors count as failed
+	// operations; invalid results are recorded and reported by check.
+	op(ctx context.Context, c *client) error
+
+	// check verifies the history once the run is over.
+	check(ctx context.Context, c *client) error
+}
+
+var workloads = map[string]func() workload{
+	"echo":       func() workload { return &echoWorkload{} },
+	"unique-ids": func() workload { return &uniqueIDsWorkload{ids: make(map[string]int)} },
+	"broadcast":  func() workload { return &broadcastWorkload{acked: make(map[int64]bool)} },
+	"g-counter":  func() workload { return &counterWorkload{} },
+	"kafka":      newKafkaWorkload,
+	"txn":        func() workload { return &txnWorkload{written: make(map[int64]map[int64]bool)} },
+}
+
+func decode(raw json.RawMessage, out any) error {
+	return json.Unmarshal(raw, out)
+}
+
+// anomalies collects invalid results seen during a run.
+type anomalies struct {
+	mu   sync.Mutex
+	list []string
+}
+
+func (a *anomalies) add(format string, args ...any) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.list = append(a.list, fmt.Sprintf(format, args...))
+}
+
+func (a *anomalies) err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.list) == 0 {
+		return nil
+	}
+	const max = 10
+	shown := a.list[:min(len(a.list), max)]
+	return fmt.Errorf("%d anomalies, e.g.:\n  %s", len(a.list), strings.Join(shown, "\n  "))
+}
+
+type noSetup struct{}
+
+func (noSetup) setup(context.Context, *client) error { return nil }
+
+// echoWorkload checks that every echo request is echoed back verbatim.
+type echoWorkload struct {
+	noSetup
+	anomalies
+}
+
+func (w *echoWorkload) op(ctx context.Context, c *client) error {
+	payload := fmt.Sprintf("Please echo %d", c.rand.Intn(128))
+	var resp struct {
+		Echo string `json:"echo"`
+	}
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "echo", "echo": payload}, &resp); err != nil {
+		return err
+	}
+	if resp.Echo != payload {
+		w.add("sent %q, got %q", payload, resp.Echo)
+	}
+	return nil
+}
+
+func (w *echoWorkload) check(context.Context, *client) error { return w.err() }
+
+// idStrategies are the ID strategies unique-ids nodes support.
+var idStrategies = []string{"counter", "block", "snowflake", "ulid", "uuidv7", "ksuid"}
+
+// idStrategy is the strategy requested from unique-ids nodes; empty uses
+// the node's default, "mixed" picks one at random per request and
+// "sequence" asks for gap-free numbers in one namespace.
+var idStrategy string
+
+// idEncodings are the compact encodings unique-ids nodes support.
+var idEncodings = []string{"base62", "base32", "hex"}
+
+// uniqueIDsWorkload checks that no ID is generated twice. A quarter of the
+// requests ask for a batch of IDs, which must be in increasing order unless
+// they are counter IDs, and an eighth inspect the ID they got back to check
+// that it decodes to the strategy that generated it. In mixed mode half the
+// requests also ask for a random encoding, prefix and checksum. In sequence
+// mode it also checks that there are no more gaps in the numbering than
+// failed requests.
+type uniqueIDsWorkload struct {
+	noSetup
+	anomalies
+	failed atomic.Int64
+
+	mu  sync.Mutex
+	ids map[string]int
+}
+
+func (w *uniqueIDsWorkload) op(ctx context.Context, c *client) error {
+	var resp struct {
+		ID  json.RawMessage   `json:"id"`
+		IDs []json.RawMessage `json:"ids"`
+	}
+	body := map[string]any{"type": "generate"}
+	if c.rand.Intn(4) == 0 && idStrategy != "sequence" {
+		body = map[string]any{"type": "generate_batch", "count": 1 + c.rand.Intn(20)}
+	}
+	strategy := idStrategy
+	switch idStrategy {
+	case "":
+	case "sequence":
+		body["namespace"] = "gloomer"
+	case "mixed":
+		strategy = idStrategies[c.rand.Intn(len(idStrategies))]
+		body["strategy"] = strategy
+	default:
+		body["strategy"] = idStrategy
+	}
+	encoding := map[string]any{}
+	if idStrategy == "mixed" && strategy != "counter" && c.rand.Intn(2) == 0 {
+		encoding["encoding"] = idEncodings[c.rand.Intn(len(idEncodings))]
+		encoding["prefix"] = []string{"", "ord_"}[c.rand.Intn(2)]
+		encoding["checksum"] = c.rand.Intn(2) == 0
+		maps.Copy(body, encoding)
+	}
+	if err := c.rpc(ctx, c.node(), body, &resp); err != nil {
+		w.failed.Add(1)
+		return err
+	}
+	if resp.ID != nil {
+		resp.IDs = append(resp.IDs, resp.ID)
+	}
+	w.mu.Lock()
+	for _, id := range resp.IDs {
+		w.ids[string(id)]++
+	}
+	w.mu.Unlock()
+	if strategy != "" && strategy != "counter" {
+		for i := 1; i < len(resp.IDs); i++ {
+			if !idLess(resp.IDs[i-1], resp.IDs[i]) {
+				w.add("batch of %s IDs out of order: %s before %s", strategy, resp.IDs[i-1], resp.IDs[i])
+			}
+		}
+	}
+
+	if c.rand.Intn(8) == 0 && len(resp.IDs) > 0 && strategy != "" {
+		var desc struct {
+			Strategy string `json:"strategy"`
+		}
+		id := resp.IDs[0]
+		inspect := map[string]any{"type": "inspect_id", "id": id}
+		maps.Copy(inspect, encoding)
+		if err := c.rpc(ctx, c.node(), inspect, &desc); err != nil {
+			return err
+		}
+		if desc.Strategy != strategy {
+			w.add("%s was generated by %s, but inspects as %s", id, strategy, desc.Strategy)
+		}
+	}
+	return nil
+}
+
+// idLess reports whether ID a sorts before b: numerically for integers,
+// lexicographically for strings.
+func idLess(a, b json.RawMessage) bool {
+	var x, y any
+	if json.Unmarshal(a, &x) != nil || json.Unmarshal(b, &y) != nil {
+		return false
+	}
+	if xs, ok := x.(string); ok {
+		ys, ok := y.(string)
+		return ok && xs < ys
+	}
+	m, errA := strconv.ParseInt(string(a), 10, 64)
+	n, errB := strconv.ParseInt(string(b), 10, 64)
+	return errA == nil && errB == nil && m < n
+}
+
+func (w *uniqueIDsWorkload) check(context.Context, *client) error {
+	if err := w.err(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var dups []string
+	for id, n := range w.ids {
+		if n > 1 {
+			dups = append(dups, fmt.Sprintf("%s (x%d)", id, n))
+		}
+	}
+	if len(dups) > 0 {
+		return fmt.Errorf("%d duplicated IDs, e.g. %s", len(dups), strings.Join(dups[:min(len(dups), 10)], ", "))
+	}
+
+	if idStrategy == "sequence" {
+		var highest int64
+		for id := range w.ids {
+			n, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return fmt.Errorf("sequence returned non-integer ID %s", id)
+			}
+			highest = max(highest, n)
+		}
+		gaps := highest - int64(len(w.ids))
+		if failed := w.failed.Load(); gaps > failed {
+			return fmt.Errorf("%d gaps in sequence up to %d, but only %d requests failed", gaps, highest, failed)
+		}
+	}
+	return nil
+}
+
+// broadcastWorkload checks that every acknowledged broadcast value is
+// eventually read from every node.
+type broadcastWorkload struct {
+	next atomic.Int64
+
+	mu    sync.Mutex
+	acked map[int64]bool
+}
+
+// setup sends every node its neighbors in a grid topology, like Maelstrom's
+// default.
+func (w *broadcastWorkload) setup(ctx context.Context, c *client) error {
+	ids := c.cluster.NodeIDs()
+	width := int(math.Ceil(math.Sqrt(float64(len(ids)))))
+	topology := make(map[string][]string)
+	for i, id := range ids {
+		neighbors := []string{}
+		if i%width > 0 {
+			neighbors = append(neighbors, ids[i-1])
+		}
+		if i%width < width-1 && i+1 < len(ids) {
+			neighbors = append(neighbors, ids[i+1])
+		}
+		if i >= width {
+			neighbors = append(neighbors, ids[i-width])
+		}
+		if i+width < len(ids) {
+			neighbors = append(neighbors, ids[i+width])
+		}
+		topology[id] = neighbors
+	}
+
+	for _, id := range ids {
+		if err := c.rpc(ctx, id, map[string]any{"type": "topology", "topology": topology}, nil); err != nil {
+			return fmt.Errorf("topology %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (w *broadcastWorkload) op(ctx context.Context, c *client) error {
+	if c.rand.Intn(2) == 0 {
+		return c.rpc(ctx, c.node(), map[string]any{"type": "read"}, nil)
+	}
+
+	v := w.next.Add(1)
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "broadcast", "message": v}, nil); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.acked[v] = true
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *broadcastWorkload) check(ctx context.Context, c *client) error {
+	var errs []error
+	for _, id := range c.cluster.Alive() {
+		var resp struct {
+			Messages []int64 `json:"messages"`
+		}
+		if err := c.rpc(ctx, id, map[string]any{"type": "read"}, &resp); err != nil {
+			errs = append(errs, fmt.Errorf("final read %s: %w", id, err))
+			continue
+		}
+		seen := make(map[int64]bool, len(resp.Messages))
+		for _, m := range resp.Messages {
+			seen[m] = true
+		}
+
+		w.mu.Lock()
+		lost := 0
+		for v := range w.acked {
+			if !seen[v] {
+				lost++
+			}
+		}
+		total := len(w.acked)
+		w.mu.Unlock()
+		if lost > 0 {
+			errs = append(errs, fmt.Errorf("%s is missing %d of %d acknowledged messages", id, lost, total))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// counterWorkload checks that every node eventually reads the sum of the
+// acknowledged adds. Adds that failed may or may not have been applied.
+type counterWorkload struct {
+	noSetup
+	acked, unknown atomic.Int64
+}
+
+func (w *counterWorkload) op(ctx context.Context, c *client) error {
+	if c.rand.Intn(2) == 0 {
+		return c.rpc(ctx, c.node(), map[string]any{"type": "read"}, nil)
+	}
+
+	delta := int64(c.rand.Intn(5))
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "add", "delta": delta}, nil); err != nil {
+		w.unknown.Add(delta)
+		return err
+	}
+	w.acked.Add(delta)
+	return nil
+}
+
+func (w *counterWorkload) check(ctx context.Context, c *client) error {
+	lo, hi := w.acked.Load(), w.acked.Load()+w.unknown.Load()
+	var errs []error
+	for _, id := range c.cluster.Alive() {
+		var resp struct {
+			Value int64 `json:"value"`
+		}
+		if err := c.rpc(ctx, id, map[string]any{"type": "read"}, &resp); err != nil {
+			errs = append(errs, fmt.Errorf("final read %s: %w", id, err))
+			continue
+		}
+		if resp.Value < lo || resp.Value > hi {
+			errs = append(errs, fmt.Errorf("%s read %d, expected between %d and %d", id, resp.Value, lo, hi))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// txnWorkload checks that reads only observe values that were written to
+// the same key, that transactions observe their own writes, and that once
+// writes have settled every node holds the same value for each key.
+type txnWorkload struct {
+	noSetup
+	anomalies
+	next atomic.Int64
+
+	mu      sync.Mutex
+	written map[int64]map[int64]bool
+}
+
+func (w *txnWorkload) op(ctx context.Context, c *client) error {
+	txn := make([][]any, 1+c.rand.Intn(4))
+	for i := range txn {
+		key := int64(c.rand.Intn(10))
+		if c.rand.Intn(2) == 0 {
+			txn[i] = []any{"r", key, nil}
+			continue
+		}
+		v := w.next.Add(1)
+		w.mu.Lock()
+		if w.written[key] == nil {
+			w.written[key] = make(map[int64]bool)
+		}
+		w.written[key][v] = true
+		w.mu.Unlock()
+		txn[i] = []any{"w", key, v}
+	}
+
+	var resp struct {
+		Txn [][]any `json:"txn"`
+	}
+	if err := c.rpc(ctx, c.node(), map[string]any{"type": "txn", "txn": txn}, &resp); err != nil {
+		return err
+	}
+	if len(resp.Txn) != len(txn) {
+		w.add("sent %d micro-ops, got %d back", len(txn), len(resp.Txn))
+		return nil
+	}
+
+	own := make(map[int64]int64)
+	for i, mop := range resp.Txn {
+		if len(mop) != 3 {
+			w.add("malformed micro-op %v", mop)
+			continue
+		}
+		key := int64(asFloat(mop[1]))
+		if txn[i][0] == "w" {
+			own[key] = txn[i][2].(int64)
+			continue
+		}
+		mine, wrote := own[key]
+		if mop[2] == nil {
+			if wrote {
+				w.add("read of key %d returned nil after the transaction wrote %d", key, mine)
+			}
+			continue
+		}
+		v := int64(asFloat(mop[2]))
+		w.mu.Lock()
+		ok := w.written[key][v]
+		w.mu.Unlock()
+		if !ok {
+			w.add("read of key %d returned %d, which was never written to it", key, v)
+		}
+		if wrote && v != mine {
+			w.add("read of key %d returned %d after the transaction wrote %d", key, v, mine)
+		}
+	}
+	return nil
+}
+
+func (w *txnWorkload) check(ctx context.Context, c *client) error {
+	errs := []error{w.err()}
+	reads := make([][]any, 10)
+	for key := range reads {
+		reads[key] = []any{"r", key, nil}
+	}
+	var first string
+	var want [][]any
+	for _, id := range c.cluster.Alive() {
+		var resp struct {
+			Txn [][]any `json:"txn"`
+		}
+		if err := c.rpc(ctx, id, map[string]any{"type": "txn", "txn": reads}, &resp); err != nil {
+			errs = append(errs, fmt.Errorf("final read %s: %w", id, err))
+			continue
+		}
+		if want == nil {
+			first, want = id, resp.Txn
+			continue
+		}
+		for i, mop := range resp.Txn {
+			if i < len(want) && len(mop) == 3 && len(want[i]) == 3 && mop[2] != want[i][2] {
+				errs = append(errs, fmt.Errorf("%s has %v for key %d, %s has %v", id, mop[2], i, first, want[i][2]))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func asFloat(v any) float64 {
+	f, _ := v.(float64)
+	return f
+}
@@ -0,0 +1,106 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/hlc"
+)
+
+// errDeposed is returned when a newer leader has written the log.
+var errDeposed = errors.New("a newer leader has taken over the log")
+
+// logRecord is the lin-kv record holding every topic's messages. Token is
+// the fencing token of the leader that wrote it last, and Stamp the hybrid
+// logical time of that write.
+type logRecord struct {
+	Token  int64                `json:"token"`
+	Stamp  hlc.Timestamp        `json:"hlc"`
+	Topics map[string][]float64 `json:"topics"`
+}
+
+// fencedLog appends to the log on behalf of the elected leader. It caches
+// the record between appends, so that each append is a single
+// compare-and-swap, and stamps every write with the leader's fencing token.
+// A deposed leader's swap fails because the record changed, and on reading
+// it back the leader finds a newer token and gives up.
+type fencedLog struct {
+	kv    *maelstrom.KV
+	clock *hlc.Clock
+
+	mu     sync.Mutex
+	token  int64      // our fencing token; 0 when not leading
+	cached *logRecord // last record we read or wrote; nil if unknown
+	exists bool       // whether cached is stored, rather than a new log
+}
+
+// lead starts or stops appending with token, as reported by the elector.
+func (l *fencedLog) lead(leader bool, token int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.token, l.cached = 0, nil
+	if leader {
+		l.token = token
+	}
+}
+
+// readLog returns the current record.
+func readLog(ctx context.Context, kv *maelstrom.KV) (*logRecord, bool, error) {
+	var rec logRecord
+	err := kv.ReadInto(ctx, Topic, &rec)
+	if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		return &logRecord{}, false, nil
+	}
+	return &rec, err == nil, err
+}
+
+// append appends message to topic and returns its offset. It gives up once
+// ctx is done, but lets KV calls already under way finish: Node.SyncRPC
+// leaves the handler of a reply that arrives after its deadline blocked for
+// good.
+func (l *fencedLog) append(ctx context.Context, topic string, message float64) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kvCtx := context.Background()
+	for ctx.Err() == nil {
+		if l.token == 0 {
+			return 0, errDeposed
+		}
+		if l.cached == nil {
+			rec, ok, err := readLog(kvCtx, l.kv)
+			if err != nil {
+				continue
+			}
+			if rec.Token > l.token {
+				l.token = 0
+				return 0, errDeposed
+			}
+			l.cached, l.exists = rec, ok
+		}
+
+		next := &logRecord{Token: l.token, Stamp: l.clock.Now(), Topics: maps.Clone(l.cached.Topics)}
+		if next.Topics == nil {
+			next.Topics = make(map[string][]float64)
+		}
+		next.Topics[topic] = append(slices.Clone(next.Topics[topic]), message)
+
+		var from any
+		if l.exists {
+			from = l.cached
+		}
+		if err := l.kv.CompareAndSwap(kvCtx, Topic, from, next, !l.exists); err != nil {
+			// Someone else wrote the log, or our write failed: read it
+			// back before trying again.
+			l.cached = nil
+			continue
+		}
+		l.cached, l.exists = next, true
+		return len(next.Topics[topic]) - 1, nil
+	}
+	return 0, ctx.Err()
+}
@@ -0,0 +1,227 @@
+// Package kafka serves the kafka workload. One elected node appends every
+// send to a log kept in lin-kv; the others forward sends to it.
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"maps"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/dedup"
+	"maelstrom-lib/election"
+	"maelstrom-lib/hlc"
+	"maelstrom-lib/rpc"
+	"maelstrom-lib/schema"
+)
+
+const (
+	Topic  = "messages"
+	Offset = "offsets"
+)
+
+// LeaseTTL is how long the elected leader's lease lasts without renewal.
+const LeaseTTL = time.Second
+
+// sendTimeout bounds a send, including waiting for a leader and forwarding
+// to it.
+const sendTimeout = 2 * time.Second
+
+// Register registers the kafka handlers on n.
+func Register(n *maelstrom.Node) {
+	validator := schema.NewValidator(n, schema.Kafka)
+
+	kv := maelstrom.NewLinKV(n)
+
+	// Remember send replies so that a retried send is not appended twice,
+	// whichever node the retry reaches.
+	sends := dedup.New(validator, kv, dedup.DefaultOptions)
+
+	// One elected node appends every send, so sends no longer race each
+	// other's compare-and-swaps; the others forward to it.
+	elector := election.New(n, rpc.NewLinKV(n), "kafka/leader", LeaseTTL)
+
+	// Forwarded appends carry a hybrid logical timestamp, so the leader
+	// stamps each write of the log after every send it was handed.
+	clock := hlc.NewClock(nil, hlc.DefaultMaxOffset)
+	clock.Attach(n)
+	topics := &fencedLog{kv: kv, clock: clock}
+	elector.OnChange = topics.lead
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		go elector.Run(context.Background())
+		return nil
+	})
+
+	// Utility to deserialize stored messages
+	readMessages := func() map[string][]float64 {
+		rec, _, err := readLog(context.Background(), kv)
+		if err != nil {
+			return make(map[string][]float64)
+		}
+		return rec.Topics
+	}
+
+	// forward asks the leader to append.
+	forward := func(ctx context.Context, leader, topic string, message float64) (int, error) {
+		msg, err := rpc.Call(ctx, n, leader, map[string]any{"type": "append", "key": topic, "msg": message})
+		if err != nil {
+			return 0, err
+		}
+		var reply struct {
+			Offset int `json:"offset"`
+		}
+		err = json.Unmarshal(msg.Body, &reply)
+		return reply.Offset, err
+	}
+
+	// APPEND, forwarded by the other nodes to the leader
+	n.Handle("append", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		offset, err := topics.append(ctx, body["key"].(string), body["msg"].(float64))
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+		}
+		return n.Reply(msg, map[string]any{"type": "append_ok", "offset": offset})
+	})
+
+	// Utility to read committed offsets
+	readOffsets := func() map[string]float64 {
+		result := make(map[string]float64)
+		data, err := kv.Read(context.Background(), Offset)
+		if err != nil {
+			return result
+		}
+		if m, ok := data.(map[string]any); ok {
+			for topic, val := range m {
+				result[topic] = val.(float64)
+			}
+		}
+		return result
+	}
+
+	// SEND
+	validator.Handle("send", sends.Wrap(func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		topic := body["key"].(string)
+		message := body["msg"].(float64)
+
+		// Append as the leader or forward to it, waiting out elections.
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		defer cancel()
+		offset := -1
+		for offset < 0 {
+			var err error
+			switch leader := elector.Leader(); {
+			case elector.IsLeader():
+				offset, err = topics.append(ctx, topic, message)
+			case leader != "" && leader != n.ID():
+				offset, err = forward(ctx, leader, topic, message)
+			default:
+				err = errDeposed // no leader yet
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					// A forwarded append may still land.
+					return maelstrom.NewRPCError(maelstrom.Crash, "send timed out; the message may have been appended")
+				}
+				offset = -1
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+
+		body["offset"] = float64(offset)
+		body["type"] = "send_ok"
+		delete(body, "key")
+		delete(body, "msg")
+		return sends.Reply(msg, body)
+	}))
+
+	// POLL
+	validator.Handle("poll", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		offsets := body["offsets"].(map[string]any)
+
+		messages := readMessages()
+		replyMsgs := make(map[string][][]float64)
+
+		for topic, startOffset := range offsets {
+			msgs := messages[topic]
+			start := int(startOffset.(float64))
+			if start < len(msgs) {
+				replyMsgs[topic] = append(replyMsgs[topic], []float64{float64(start), msgs[start]})
+			}
+		}
+
+		body["type"] = "poll_ok"
+		body["msgs"] = replyMsgs
+		delete(body, "offsets")
+		return validator.Reply(msg, body)
+	})
+
+	// COMMIT OFFSETS
+	validator.Handle("commit_offsets", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		offsets := body["offsets"].(map[string]any)
+
+		for {
+			oldOffsets := readOffsets()
+
+			newOffsets := make(map[string]float64)
+			maps.Copy(newOffsets, oldOffsets)
+			for k, v := range offsets {
+				newOffsets[k] = v.(float64)
+			}
+
+			err := kv.CompareAndSwap(context.Background(), Offset, oldOffsets, newOffsets, true)
+			if err == nil {
+				break
+			}
+		}
+
+		body["type"] = "commit_offsets_ok"
+		delete(body, "offsets")
+		return validator.Reply(msg, body)
+	})
+
+	// LIST COMMITTED OFFSETS
+	validator.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		keys := body["keys"].([]any)
+
+		allOffsets := readOffsets()
+		replyOffsets := make(map[string]float64)
+		for _, key := range keys {
+			if val, ok := allOffsets[key.(string)]; ok {
+				replyOffsets[key.(string)] = val
+			}
+		}
+
+		body["type"] = "list_committed_offsets_ok"
+		body["offsets"] = replyOffsets
+		delete(body, "keys")
+		return validator.Reply(msg, body)
+	})
+}
@@ -3,3 +3,7 @@ module maelstrom-kafka
 go 1.24.1
 
 require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20250204203845-8263d1dd2b7a
+
+require maelstrom-lib v0.0.0-00010101000000-000000000000
+
+replace maelstrom-lib => ../../lib
@@ -6,6 +6,8 @@ import (
 	"os"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/dedup"
+	"maelstrom-lib/schema"
 )
 
 type TopicLog struct {
@@ -19,7 +21,7 @@ func (t *TopicLog) Send(topic string, msg float64) float64 {
 }
 
 func (t *TopicLog) Poll(topic string, offset int) [][]float64 {
-	var result [][]float64
+	result := [][]float64{}
 	if msgs, ok := t.Messages[topic]; ok && offset < len(msgs) {
 		for i := offset; i < offset+1; i++ {
 			result = append(result, []float64{float64(i), msgs[i]})
@@ -39,6 +41,11 @@ func (t *TopicLog) Commit(topic string, offset float64) {
 func main() {
 	n := maelstrom.NewNode()
 
+	validator := schema.NewValidator(n, schema.Kafka)
+
+	// Remember send replies so that a retried send is not appended twice.
+	sends := dedup.New(validator, nil, dedup.DefaultOptions)
+
 	// Create a new TopicLog instance to store messages and offsets.
 	kafkaLog := TopicLog{
 		Messages: make(map[string][]float64),
@@ -46,7 +53,7 @@ func main() {
 	}
 
 	// Register handler for "send" message
-	n.Handle("send", func(msg maelstrom.Message) error {
+	validator.Handle("send", sends.Wrap(func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -63,11 +70,11 @@ func main() {
 		delete(body, "key")
 		delete(body, "msg")
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
-	})
+		return sends.Reply(msg, body)
+	}))
 
 	// Register handler for "poll" message
-	n.Handle("poll", func(msg maelstrom.Message) error {
+	validator.Handle("poll", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -90,11 +97,11 @@ func main() {
 		delete(body, "key")
 		delete(body, "offsets")
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
 	// Register handler for "commit_offsets" message
-	n.Handle("commit_offsets", func(msg maelstrom.Message) error {
+	validator.Handle("commit_offsets", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -111,11 +118,11 @@ func main() {
 		body["type"] = "commit_offsets_ok"
 		delete(body, "key")
 		delete(body, "offsets")
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
 	// Register handler for "list_committed_offsets" message
-	n.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
+	validator.Handle("list_committed_offsets", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -132,9 +139,9 @@ func main() {
 		}
 
 		body["type"] = "list_committed_offsets_ok"
-		body["committed_offsets"] = committedOffsets
+		body["offsets"] = committedOffsets
 		delete(body, "keys")
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
 	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
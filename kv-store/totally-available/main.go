@@ -1,19 +1,36 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
+	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/hlc"
+	"maelstrom-lib/rpc"
+	"maelstrom-lib/schema"
 )
 
+// replicateTimeout bounds one attempt to replicate writes to a peer.
+const replicateTimeout = time.Second
+
 func main() {
 	n := maelstrom.NewNode()
 
-	kvstore := make(map[int]int)
+	validator := schema.NewValidator(n, schema.Txn)
+
+	// Every node accepts transactions on its own and replicates their
+	// writes to the others in the background. Dotted version vectors tell
+	// which writes of a key have seen which; of concurrent ones, reads
+	// return the one with the latest hybrid logical timestamp. The clock
+	// rides along on the replication messages.
+	clock := hlc.NewClock(nil, hlc.DefaultMaxOffset)
+	clock.Attach(n)
+	kvstore := newStore()
 
-	n.Handle("txn", func(msg maelstrom.Message) error {
+	validator.Handle("txn", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
@@ -21,32 +38,37 @@ func main() {
 		}
 
 		// Extract the transaction details from the message body. txn is a list of lists
-		// where each inner list contains a key and a value.
-		txn, _ := body["txn"].([]any)
-		for _, op := range txn {
-			// Each operation is a list of [operation, key, value].
+		// where each inner list contains [operation, key, value].
+		raw, _ := body["txn"].([]any)
+		ops := make([][]any, 0, len(raw))
+		for _, op := range raw {
 			operation, _ := op.([]any)
-			if operation[0] == "w" {
-				// Write operation
-				key, _ := operation[1].(float64)
-				value, _ := operation[2].(float64)
-				kvstore[int(key)] = int(value)
-			}
-			if operation[0] == "r" {
-				// Read operation
-				key, _ := operation[1].(float64)
-				// Check if the key exists in the kvstore
-				if value, ok := kvstore[int(key)]; ok {
-					// If it exists, set the value in the body
-					operation[2] = float64(value)
+			ops = append(ops, operation)
+		}
+		writes := kvstore.txn(ops, clock.Now(), n.ID())
+		if len(writes) > 0 {
+			for _, peer := range n.NodeIDs() {
+				if peer != n.ID() {
+					go replicate(n, peer, writes)
 				}
 			}
 		}
 
 		body["type"] = "txn_ok"
-		body["txn"] = txn
+		body["txn"] = raw
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
+	})
+
+	n.Handle("replicate", func(msg maelstrom.Message) error {
+		var body struct {
+			Writes []write `json:"writes"`
+		}
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+		kvstore.merge(body.Writes)
+		return n.Reply(msg, map[string]any{"type": "replicate_ok"})
 	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
@@ -55,3 +77,20 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// replicate sends writes to peer until it acknowledges them.
+func replicate(n *maelstrom.Node, peer string, writes []write) {
+	body := map[string]any{"type": "replicate", "writes": writes}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), replicateTimeout)
+		_, err := rpc.Call(ctx, n, peer, body)
+		cancel()
+		switch {
+		case err == nil:
+			return
+		case ctx.Err() == nil:
+			// Rejected or not sent: wait as long as a lost reply would.
+			time.Sleep(replicateTimeout)
+		}
+	}
+}
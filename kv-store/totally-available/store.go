@@ -0,0 +1,100 @@
+package main
+
+import (
+	"sync"
+
+	"maelstrom-lib/hlc"
+	"maelstrom-lib/vclock"
+)
+
+// entry is the value of one write and its hybrid logical timestamp.
+type entry struct {
+	Value int           `json:"value"`
+	TS    hlc.Timestamp `json:"ts"`
+}
+
+// sibling is a write tagged with the dotted version vector that tracks
+// which other writes of its key it has seen.
+type sibling = vclock.Versioned[entry]
+
+// after reports whether s wins over o when both are concurrent: the later
+// timestamp wins, ties broken by the node that wrote it, so every node
+// picks the same value whatever order the writes reach it in.
+func after(s, o sibling) bool {
+	if c := hlc.Compare(s.Value.TS, o.Value.TS); c != 0 {
+		return c > 0
+	}
+	return s.Version.Dot.Node > o.Version.Dot.Node
+}
+
+// write is a version of a key, as replicated between nodes.
+type write struct {
+	Key int `json:"key"`
+	sibling
+}
+
+// store is a node's copy of the registers. Each key keeps the writes that
+// no other write it holds has seen; a write replaces every sibling that was
+// stored when it was made, so concurrent siblings only come from writes to
+// the same key on different nodes.
+type store struct {
+	mu       sync.Mutex
+	siblings map[int][]sibling
+}
+
+func newStore() *store {
+	return &store{siblings: make(map[int][]sibling)}
+}
+
+// txn executes the operations of a transaction atomically, filling in the
+// values read, and returns its writes stamped with ts.
+func (s *store) txn(ops [][]any, ts hlc.Timestamp, node string) []write {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var writes []write
+	for _, op := range ops {
+		key, _ := op[1].(float64)
+		switch op[0] {
+		case "r":
+			if v, ok := s.read(int(key)); ok {
+				op[2] = float64(v)
+			}
+		case "w":
+			value, _ := op[2].(float64)
+			seen := vclock.Join(s.siblings[int(key)])
+			w := write{Key: int(key), sibling: sibling{
+				Version: vclock.Event(node, seen, seen),
+				Value:   entry{Value: int(value), TS: ts},
+			}}
+			s.siblings[w.Key] = []sibling{w.sibling}
+			writes = append(writes, w)
+		}
+	}
+	return writes
+}
+
+// read returns the value of key, resolving concurrent siblings by their
+// timestamps. The caller holds s.mu.
+func (s *store) read(key int) (int, bool) {
+	siblings := s.siblings[key]
+	if len(siblings) == 0 {
+		return 0, false
+	}
+	latest := siblings[0]
+	for _, v := range siblings[1:] {
+		if after(v, latest) {
+			latest = v
+		}
+	}
+	return latest.Value.Value, true
+}
+
+// merge applies writes replicated from another node.
+func (s *store) merge(writes []write) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, w := range writes {
+		s.siblings[w.Key] = vclock.Sync(s.siblings[w.Key], []sibling{w.sibling})
+	}
+}
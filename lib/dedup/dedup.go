@@ -0,0 +1,293 @@
+// Package dedup makes non-idempotent request handlers safe to retry. It
+// remembers the reply to each request, keyed by the client and the
+// request's idempotency_key, and answers repeats with the remembered reply
+// instead of running the handler again.
+//
+// Replies are remembered by the node that sent them and, given a shared
+// linearizable KV, in the KV as well, so that a retry sent to another node
+// is answered too.
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// KeyField is the request field clients set to mark retries of the same
+// logical request. Requests without it are never deduplicated: msg_ids are
+// chosen per message, so a retry gets a new one.
+const KeyField = "idempotency_key"
+
+// pendingWait is how long a repeat waits for the original request to finish
+// on another node before failing.
+const pendingWait = time.Second
+
+// Replier sends replies. *maelstrom.Node and *schema.Validator both
+// satisfy it.
+type Replier interface {
+	Reply(req maelstrom.Message, body any) error
+}
+
+// Options bounds how much the cache remembers.
+type Options struct {
+	// Retention is how long a reply is remembered after the request first
+	// arrived, both locally and in the KV.
+	Retention time.Duration
+
+	// MaxEntries caps the number of requests remembered locally; the
+	// oldest are forgotten first.
+	MaxEntries int
+}
+
+// DefaultOptions comfortably covers Maelstrom client retries.
+var DefaultOptions = Options{Retention: time.Minute, MaxEntries: 10000}
+
+// Key identifies a logical request.
+type Key struct {
+	Client string
+	ID     string
+}
+
+// KeyOf returns the key of msg, and false if it has no idempotency key.
+func KeyOf(msg maelstrom.Message) (Key, bool) {
+	var body struct {
+		IdempotencyKey string `json:"idempotency_key"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil || body.IdempotencyKey == "" {
+		return Key{}, false
+	}
+	return Key{Client: msg.Src, ID: body.IdempotencyKey}, true
+}
+
+// Cache remembers replies sent through it.
+type Cache struct {
+	replier Replier
+	kv      *maelstrom.KV // shared between nodes; nil if replies stay local
+	opts    Options
+
+	mu      sync.Mutex
+	entries map[Key]*entry
+	order   []*entry // by arrival, for expiry
+}
+
+type entry struct {
+	key     Key
+	arrived time.Time
+	done    chan struct{} // closed once the handler has finished
+	reply   json.RawMessage
+	settled bool
+}
+
+// record is what the KV holds for a request.
+type record struct {
+	State   string          `json:"state"` // "pending", "done" or "failed"
+	Reply   json.RawMessage `json:"reply,omitempty"`
+	Arrived int64           `json:"arrived"` // unix milliseconds
+}
+
+// New returns a cache that sends replies through r. With a linearizable kv,
+// such as lin-kv, repeats are caught whichever node they reach; with nil,
+// only repeats sent to the same node are.
+func New(r Replier, kv *maelstrom.KV, opts Options) *Cache {
+	return &Cache{replier: r, kv: kv, opts: opts, entries: make(map[Key]*entry)}
+}
+
+// Wrap returns a handler that runs fn once per logical request. A repeat
+// that arrives while the original is still being handled waits for it. fn
+// must reply through the cache's Reply method for its reply to be
+// remembered. Error replies are not remembered, so a retry of a failed
+// request runs fn again.
+//
+// With a shared KV, a request is first claimed in the KV. A repeat that
+// finds the original still in progress on another node waits for it up to a
+// second, then fails with an indefinite error rather than running fn a
+// second time.
+func (c *Cache) Wrap(fn maelstrom.HandlerFunc) maelstrom.HandlerFunc {
+	return func(msg maelstrom.Message) error {
+		key, ok := KeyOf(msg)
+		if !ok {
+			return fn(msg)
+		}
+
+		var ours *entry
+		for ours == nil {
+			c.mu.Lock()
+			c.expire(time.Now())
+			e, seen := c.entries[key]
+			if !seen {
+				ours = &entry{key: key, arrived: time.Now(), done: make(chan struct{})}
+				c.entries[key] = ours
+				c.order = append(c.order, ours)
+				c.mu.Unlock()
+				break
+			}
+			c.mu.Unlock()
+
+			<-e.done
+			if e.reply != nil {
+				return c.replier.Reply(msg, e.reply)
+			}
+			// The original failed and was forgotten; try again ourselves.
+		}
+
+		var kvKey string
+		if c.kv != nil {
+			kvKey = sharedKey(msg, key)
+			reply, err := c.claim(kvKey)
+			if err != nil || reply != nil {
+				c.mu.Lock()
+				if reply != nil {
+					ours.reply = reply
+					ours.settled = true
+					close(ours.done)
+				}
+				c.forget(ours)
+				c.mu.Unlock()
+				if err != nil {
+					return err
+				}
+				return c.replier.Reply(msg, reply)
+			}
+		}
+
+		err := fn(msg)
+
+		c.mu.Lock()
+		reply := ours.reply // set if fn replied through the cache
+		c.forget(ours)
+		c.mu.Unlock()
+
+		if c.kv != nil {
+			rec := record{State: "failed", Arrived: ours.arrived.UnixMilli()}
+			if reply != nil {
+				rec = record{State: "done", Reply: reply, Arrived: rec.Arrived}
+			}
+			if err := c.kv.Write(context.Background(), kvKey, rec); err != nil {
+				// Repeats find the request pending until it expires,
+				// which is safe if unhelpful.
+				log.Printf("dedup: record %s: %s", kvKey, err)
+			}
+		}
+		return err
+	}
+}
+
+// sharedKey is the KV key of a request. It includes the request type, so
+// that workloads sharing a KV do not mix up their requests.
+func sharedKey(msg maelstrom.Message, key Key) string {
+	return "dedup/" + msg.Type() + "/" + key.Client + "/" + key.ID
+}
+
+// claim marks a request as in progress in the KV, or returns the reply if
+// another node has already handled it. Records older than the retention
+// period count as absent.
+func (c *Cache) claim(kvKey string) (json.RawMessage, error) {
+	// KV calls get no deadline of their own: Node.SyncRPC leaves the
+	// handler of a reply that arrives after its deadline blocked for good,
+	// which keeps the node from exiting. The KV always replies.
+	ctx := context.Background()
+	deadline := time.Now().Add(pendingWait)
+
+	for time.Now().Before(deadline) {
+		var current record
+		exists := true
+		if err := c.kv.ReadInto(ctx, kvKey, &current); maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			exists = false
+		} else if err != nil {
+			return nil, err
+		}
+
+		fresh := exists && time.Since(time.UnixMilli(current.Arrived)) < c.opts.Retention
+		switch {
+		case fresh && current.State == "done":
+			return current.Reply, nil
+		case fresh && current.State == "pending":
+			time.Sleep(20 * time.Millisecond)
+			continue
+		}
+
+		var from any
+		if exists {
+			from = current
+		}
+		mine := record{State: "pending", Arrived: time.Now().UnixMilli()}
+		err := c.kv.CompareAndSwap(ctx, kvKey, from, mine, !exists)
+		if err == nil {
+			return nil, nil
+		}
+		if code := maelstrom.ErrorCode(err); code != maelstrom.PreconditionFailed && code != maelstrom.KeyDoesNotExist {
+			return nil, err
+		}
+		// Another node claimed it first; see what it made of it.
+	}
+	return nil, maelstrom.NewRPCError(maelstrom.Crash, "the same request is still in progress on another node; retry later")
+}
+
+// Reply sends body in reply to req and remembers it for repeats of req.
+func (c *Cache) Reply(req maelstrom.Message, body any) error {
+	if key, ok := KeyOf(req); ok {
+		if err := c.remember(key, body); err != nil {
+			return err
+		}
+	}
+	return c.replier.Reply(req, body)
+}
+
+func (c *Cache) remember(key Key, body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var typed maelstrom.MessageBody
+	if err := json.Unmarshal(buf, &typed); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.entries[key]
+	if e == nil || e.settled {
+		return nil
+	}
+	if typed.Type == "error" {
+		c.forget(e)
+		return nil
+	}
+	e.reply = buf
+	e.settled = true
+	close(e.done)
+	return nil
+}
+
+// forget drops an unsettled entry and releases any repeats waiting on it.
+func (c *Cache) forget(e *entry) {
+	if e.settled {
+		return
+	}
+	if c.entries[e.key] == e {
+		delete(c.entries, e.key)
+	}
+	e.settled = true
+	close(e.done)
+}
+
+// expire forgets entries past their retention or beyond the size limit.
+func (c *Cache) expire(now time.Time) {
+	drop := 0
+	for drop < len(c.order) {
+		e := c.order[drop]
+		if now.Sub(e.arrived) < c.opts.Retention && len(c.order)-drop <= c.opts.MaxEntries {
+			break
+		}
+		if c.entries[e.key] == e {
+			delete(c.entries, e.key)
+		}
+		drop++
+	}
+	c.order = c.order[drop:]
+}
@@ -0,0 +1,246 @@
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// recorder is a Replier that keeps what it is asked to send.
+type recorder struct {
+	mu      sync.Mutex
+	replies []string
+}
+
+func (r *recorder) Reply(req maelstrom.Message, body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replies = append(r.replies, string(buf))
+	return nil
+}
+
+func (r *recorder) sent() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.replies...)
+}
+
+// add returns a request from c1, with key as its idempotency key if set.
+func add(key string) maelstrom.Message {
+	body := `{"type": "add", "msg_id": 1}`
+	if key != "" {
+		body = fmt.Sprintf(`{"type": "add", "msg_id": 1, %q: %q}`, KeyField, key)
+	}
+	return maelstrom.Message{Src: "c1", Dest: "n0", Body: json.RawMessage(body)}
+}
+
+// counting returns a handler that replies with how many times it has run.
+func counting(c *Cache, calls *atomic.Int64) maelstrom.HandlerFunc {
+	return c.Wrap(func(msg maelstrom.Message) error {
+		return c.Reply(msg, map[string]any{"type": "add_ok", "calls": calls.Add(1)})
+	})
+}
+
+func TestRepeatsGetTheFirstReply(t *testing.T) {
+	r := &recorder{}
+	c := New(r, nil, DefaultOptions)
+	var calls atomic.Int64
+	handle := counting(c, &calls)
+
+	for _, msg := range []maelstrom.Message{add("k1"), add("k1"), add("k2"), add("k1")} {
+		if err := handle(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []string{
+		`{"calls":1,"type":"add_ok"}`,
+		`{"calls":1,"type":"add_ok"}`,
+		`{"calls":2,"type":"add_ok"}`,
+		`{"calls":1,"type":"add_ok"}`,
+	}
+	if got := r.sent(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("replies %v, want %v", got, want)
+	}
+}
+
+func TestRequestsWithoutKeyAlwaysRun(t *testing.T) {
+	c := New(&recorder{}, nil, DefaultOptions)
+	var calls atomic.Int64
+	handle := counting(c, &calls)
+	for range 3 {
+		if err := handle(add("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := calls.Load(); n != 3 {
+		t.Fatalf("handler ran %d times, want 3", n)
+	}
+}
+
+func TestErrorRepliesAreNotRemembered(t *testing.T) {
+	r := &recorder{}
+	c := New(r, nil, DefaultOptions)
+	var calls atomic.Int64
+	handle := c.Wrap(func(msg maelstrom.Message) error {
+		if calls.Add(1) == 1 {
+			return c.Reply(msg, map[string]any{"type": "error", "code": maelstrom.TemporarilyUnavailable})
+		}
+		return c.Reply(msg, map[string]any{"type": "add_ok"})
+	})
+
+	for range 3 {
+		if err := handle(add("k1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("handler ran %d times, want 2: once failing, once succeeding", n)
+	}
+}
+
+func TestRepeatWaitsForOriginal(t *testing.T) {
+	r := &recorder{}
+	c := New(r, nil, DefaultOptions)
+	var calls atomic.Int64
+	started, release := make(chan struct{}), make(chan struct{})
+	handle := c.Wrap(func(msg maelstrom.Message) error {
+		calls.Add(1)
+		close(started)
+		<-release
+		return c.Reply(msg, map[string]any{"type": "add_ok"})
+	})
+
+	done := make(chan error, 2)
+	go func() { done <- handle(add("k1")) }()
+	<-started
+	go func() { done <- handle(add("k1")) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("a request finished before the original was released: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for range 2 {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+	if got := r.sent(); len(got) != 2 || got[0] != got[1] {
+		t.Fatalf("replies %v, want the same reply twice", got)
+	}
+}
+
+func TestEntriesExpire(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		pause time.Duration
+		keys  []string
+		want  int64
+	}{
+		{"within limits", Options{Retention: time.Minute, MaxEntries: 2}, 0, []string{"k1", "k2", "k1"}, 2},
+		// k1 is the oldest of three entries, one more than the cache holds.
+		{"beyond the size limit", Options{Retention: time.Minute, MaxEntries: 1}, 0, []string{"k1", "k2", "k1"}, 3},
+		{"past retention", Options{Retention: 10 * time.Millisecond, MaxEntries: 100}, 20 * time.Millisecond, []string{"k1", "k1"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(&recorder{}, nil, tt.opts)
+			var calls atomic.Int64
+			handle := counting(c, &calls)
+			for _, key := range tt.keys {
+				if err := handle(add(key)); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(tt.pause)
+			}
+			if n := calls.Load(); n != tt.want {
+				t.Fatalf("handler ran %d times, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestRepeatsAcrossNodes(t *testing.T) {
+	var calls atomic.Int64
+	started, release := make(chan struct{}), make(chan struct{})
+	setup := func(n *maelstrom.Node) {
+		c := New(n, maelstrom.NewLinKV(n), DefaultOptions)
+		n.Handle("add", c.Wrap(func(msg maelstrom.Message) error {
+			if calls.Add(1) == 1 {
+				close(started)
+				<-release
+			}
+			return c.Reply(msg, map[string]any{"type": "add_ok", "calls": calls.Load()})
+		}))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cluster, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Stop()
+	req := map[string]any{"type": "add", KeyField: "k1"}
+
+	type result struct {
+		msg maelstrom.Message
+		err error
+	}
+	first := make(chan result, 1)
+	go func() {
+		msg, err := cluster.RPC(ctx, "c1", "n0", req)
+		first <- result{msg, err}
+	}()
+	<-started
+
+	// The retry reaches n1 while n0 is still handling the original, and
+	// fails rather than adding again.
+	if _, err := cluster.RPC(ctx, "c1", "n1", req); maelstrom.ErrorCode(err) != maelstrom.Crash {
+		t.Fatalf("retry during the original returned %v, want an indefinite error", err)
+	}
+
+	close(release)
+	r := <-first
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	retry, err := cluster.RPC(ctx, "c1", "n1", req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calledAt(t, retry) != calledAt(t, r.msg) {
+		t.Fatalf("retry on n1 got %s, want the original reply %s", retry.Body, r.msg.Body)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("handler ran %d times, want 1", n)
+	}
+}
+
+// calledAt returns the calls field of an add_ok reply.
+func calledAt(t *testing.T, msg maelstrom.Message) int {
+	t.Helper()
+	var body struct {
+		Calls int `json:"calls"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Calls
+}
@@ -0,0 +1,229 @@
+// Package election elects a leader among the nodes of a cluster using leases
+// stored in a linearizable KV service. Every change of leadership increments
+// a fencing token, which the leader attaches to the writes it makes so that
+// a deposed leader's late writes can be rejected.
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+)
+
+// ErrNotLeader is returned by Resign when the node does not hold the lease.
+var ErrNotLeader = errors.New("election: not the leader")
+
+// Lease is the record stored under the election key.
+type Lease struct {
+	Holder  string `json:"holder"`
+	Token   int64  `json:"token"`
+	Expires int64  `json:"expires"` // unix milliseconds
+}
+
+// Elector campaigns for one lease on behalf of the local node. Use one
+// elector per key to elect a leader per key, or a single well-known key for a
+// cluster-wide leader.
+type Elector struct {
+	node *maelstrom.Node
+	kv   *rpc.KV
+	key  string
+	ttl  time.Duration
+
+	// Now returns the current time. It can be replaced to simulate clock skew.
+	Now func() time.Time
+
+	// Skew is the maximum clock difference assumed between nodes. Followers
+	// wait this long past expiry before taking over, and the leader stops
+	// acting this long before its lease runs out.
+	Skew time.Duration
+
+	// OnChange, if set, is called whenever the node gains or loses the lease,
+	// including when the lease lapses because it could not be renewed in
+	// time. Set it before the first campaign.
+	OnChange func(leader bool, token int64)
+
+	mu       sync.Mutex
+	lease    Lease       // last lease observed in the KV
+	leader   bool        // whether lease is ours
+	until    time.Time   // local deadline for acting as leader
+	notified bool        // the state last reported to OnChange
+	expiry   *time.Timer // fires at until while leader
+}
+
+// New returns an elector for key in kv on behalf of node n. A lease is valid
+// for ttl after it is acquired or renewed.
+func New(n *maelstrom.Node, kv *rpc.KV, key string, ttl time.Duration) *Elector {
+	return &Elector{
+		node: n,
+		kv:   kv,
+		key:  key,
+		ttl:  ttl,
+		Now:  time.Now,
+		Skew: ttl / 10,
+	}
+}
+
+// IsLeader reports whether the node holds an unexpired lease.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader && e.Now().Before(e.until)
+}
+
+// Token returns the fencing token of the current lease. It is only
+// meaningful while IsLeader is true.
+func (e *Elector) Token() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lease.Token
+}
+
+// Leader returns the holder of the most recently observed lease, which may
+// be stale or expired. Followers can use it to forward requests.
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.lease.Holder
+}
+
+// Campaign makes a single attempt to acquire or renew the lease and reports
+// whether the node holds it afterwards.
+func (e *Elector) Campaign(ctx context.Context) (bool, error) {
+	start := e.Now()
+
+	var current Lease
+	exists := true
+	if err := e.kv.ReadInto(ctx, e.key, &current); maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+		exists = false
+	} else if err != nil {
+		return e.IsLeader(), err
+	}
+
+	e.mu.Lock()
+	renewal := exists && e.leader && e.lease == current
+	e.mu.Unlock()
+
+	next := Lease{Holder: e.node.ID(), Token: current.Token, Expires: start.Add(e.ttl).UnixMilli()}
+	switch {
+	case renewal:
+		// Renewal keeps the token: leadership has not changed hands.
+	case !exists || current.Holder == e.node.ID() || start.After(time.UnixMilli(current.Expires).Add(e.Skew)):
+		// Taking over, possibly from an earlier incarnation of this node
+		// that has lost its state, starts a new term.
+		next.Token++
+	default:
+		return e.observe(current, false, start), nil
+	}
+
+	var from any
+	if exists {
+		from = current
+	}
+	if err := e.kv.CompareAndSwap(ctx, e.key, from, next, !exists); err != nil {
+		if code := maelstrom.ErrorCode(err); code == maelstrom.PreconditionFailed || code == maelstrom.KeyDoesNotExist {
+			return e.observe(current, false, start), nil
+		}
+		return e.IsLeader(), err
+	}
+	return e.observe(next, true, start), nil
+}
+
+// observe records the latest lease and fires OnChange on transitions. start
+// is when the attempt that produced the lease began, which bounds how long
+// the lease can be relied upon locally.
+func (e *Elector) observe(lease Lease, ours bool, start time.Time) bool {
+	e.mu.Lock()
+	e.lease = lease
+	if ours {
+		e.leader = true
+		e.until = start.Add(e.ttl - e.Skew)
+		e.armExpiry()
+	} else if lease.Holder != e.node.ID() || !e.Now().Before(e.until) {
+		e.leader = false
+	}
+	is := e.leader && e.Now().Before(e.until)
+	e.notify(is)
+	return is
+}
+
+// armExpiry (re)starts the timer that steps down when the lease lapses
+// without being renewed. Expects e.mu.
+func (e *Elector) armExpiry() {
+	if e.expiry != nil {
+		e.expiry.Stop()
+	}
+	e.expiry = time.AfterFunc(e.until.Sub(e.Now()), func() {
+		e.mu.Lock()
+		if e.leader && e.Now().Before(e.until) {
+			e.armExpiry() // renewed meanwhile, or Now runs behind
+			e.mu.Unlock()
+			return
+		}
+		e.leader = false
+		e.notify(false)
+	})
+}
+
+// notify records whether the node leads and calls OnChange if that changed.
+// Expects e.mu, and releases it.
+func (e *Elector) notify(is bool) {
+	changed := is != e.notified
+	e.notified = is
+	token := e.lease.Token
+	onChange := e.OnChange
+	e.mu.Unlock()
+
+	if onChange != nil && changed {
+		onChange(is, token)
+	}
+}
+
+// Run campaigns every third of the lease TTL until ctx is done, then resigns
+// if the node is still leader. Each attempt gives up after a third of the
+// TTL; its late replies are dropped, so a lost one cannot keep the node from
+// shutting down.
+func (e *Elector) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.ttl / 3)
+	defer ticker.Stop()
+	for {
+		attempt, cancel := context.WithTimeout(ctx, e.ttl/3)
+		e.Campaign(attempt)
+		cancel()
+
+		select {
+		case <-ctx.Done():
+			resign, cancel := context.WithTimeout(context.Background(), e.ttl/3)
+			e.Resign(resign)
+			cancel()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Resign gives up the lease so that another node can take over without
+// waiting for it to expire.
+func (e *Elector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	lease, ours := e.lease, e.leader
+	e.mu.Unlock()
+	if !ours || lease.Holder != e.node.ID() {
+		return ErrNotLeader
+	}
+
+	// Step down locally first: once the CAS lands another node may lead.
+	e.mu.Lock()
+	e.leader = false
+	if e.expiry != nil {
+		e.expiry.Stop()
+	}
+	e.notify(false)
+
+	released := lease
+	released.Expires = 0
+	return e.kv.CompareAndSwap(ctx, e.key, lease, released, false)
+}
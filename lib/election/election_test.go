@@ -0,0 +1,227 @@
+package election
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+	"maelstrom-lib/rpc"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const testTTL = 300 * time.Millisecond
+
+// change is one OnChange call.
+type change struct {
+	node   string
+	leader bool
+	token  int64
+	at     time.Time
+}
+
+// cluster runs an elector on every node of an in-process cluster and
+// records the changes they report.
+type cluster struct {
+	t *testing.T
+	*harness.Cluster
+
+	mu       sync.Mutex
+	electors map[string]*Elector
+	changes  []change
+}
+
+func startCluster(t *testing.T, nodes int) *cluster {
+	t.Helper()
+	c := &cluster{t: t, electors: make(map[string]*Elector)}
+	setup := func(n *maelstrom.Node) {
+		e := New(n, rpc.NewLinKV(n), "leader", testTTL)
+		e.OnChange = func(leader bool, token int64) {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.changes = append(c.changes, change{n.ID(), leader, token, time.Now()})
+		}
+		n.Handle("init", func(maelstrom.Message) error {
+			c.mu.Lock()
+			c.electors[n.ID()] = e
+			c.mu.Unlock()
+			go e.Run(context.Background())
+			return nil
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	hc, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { hc.Stop() })
+	c.Cluster = hc
+	return c
+}
+
+// leader waits until exactly one of ids holds the lease and returns it.
+func (c *cluster) leader(ids []string) string {
+	c.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var leaders []string
+		c.mu.Lock()
+		for _, id := range ids {
+			if c.electors[id].IsLeader() {
+				leaders = append(leaders, id)
+			}
+		}
+		c.mu.Unlock()
+		if len(leaders) == 1 {
+			return leaders[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.t.Fatalf("no single leader among %v", ids)
+	return ""
+}
+
+// history returns the changes reported so far.
+func (c *cluster) history() []change {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]change(nil), c.changes...)
+}
+
+// checkHistory verifies that the reported leaderships never overlapped and
+// that every new leader got a larger fencing token.
+func checkHistory(t *testing.T, changes []change) {
+	t.Helper()
+	holder, token := "", int64(0)
+	for _, ch := range changes {
+		switch {
+		case ch.leader && holder != "":
+			t.Fatalf("%s became leader at %s while %s still was", ch.node, ch.at.Format(time.StampMilli), holder)
+		case ch.leader && ch.token <= token:
+			t.Fatalf("%s became leader with token %d, not above %d", ch.node, ch.token, token)
+		case ch.leader:
+			holder, token = ch.node, ch.token
+		case ch.node == holder:
+			holder = ""
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	c := startCluster(t, 3)
+	first := c.leader(c.NodeIDs())
+
+	if err := c.Kill(first); err != nil {
+		t.Fatal(err)
+	}
+	second := c.leader(c.Alive())
+	if second == first {
+		t.Fatalf("%s still leads after being killed", first)
+	}
+
+	// The killed node is cut off from lin-kv as much as from the others,
+	// and its lease lapses before anyone else's can start.
+	checkHistory(t, c.history())
+}
+
+func TestPartitionFromKV(t *testing.T) {
+	c := startCluster(t, 3)
+	ids := c.NodeIDs()
+	old := c.leader(ids)
+
+	// Cut the leader off from lin-kv. It cannot renew its lease, so it
+	// must step down by itself before anyone else can take over.
+	var rest []string
+	for _, id := range ids {
+		if id != old {
+			rest = append(rest, id)
+		}
+	}
+	c.Partition([]string{old}, append(rest, "lin-kv"))
+	next := c.leader(rest)
+
+	c.mu.Lock()
+	oldElector := c.electors[old]
+	c.mu.Unlock()
+	if oldElector.IsLeader() {
+		t.Fatalf("%s still believes it leads while partitioned", old)
+	}
+	var stepped bool
+	for _, ch := range c.history() {
+		if ch.node == old && !ch.leader {
+			stepped = true
+		}
+	}
+	if !stepped {
+		t.Fatalf("%s never reported losing its lease", old)
+	}
+
+	// After healing, the old leader follows the new one.
+	c.Heal()
+	time.Sleep(3 * testTTL)
+	if got := c.leader(ids); got != next {
+		t.Fatalf("leader is %s after healing, want %s", got, next)
+	}
+	if got := oldElector.Leader(); got != next {
+		t.Fatalf("%s sees %s as leader, want %s", old, got, next)
+	}
+	checkHistory(t, c.history())
+}
+
+func TestLateKVReplyDoesNotBlockShutdown(t *testing.T) {
+	// n0 campaigns against a lin-kv played by the test, which answers the
+	// read only after the attempt has given up.
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	n := maelstrom.NewNode()
+	n.Stdin, n.Stdout = inR, outW
+	n.Init("n0", []string{"n0"})
+	e := New(n, rpc.NewLinKV(n), "leader", testTTL)
+	campaigned := make(chan error, 1)
+	n.Handle("start", func(maelstrom.Message) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := e.Campaign(ctx)
+		campaigned <- err
+		return nil
+	})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		n.Run()
+	}()
+
+	io.WriteString(inW, `{"src":"c1","dest":"n0","body":{"type":"start","msg_id":1}}`+"\n")
+	line, err := bufio.NewReader(outR).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read struct {
+		Body struct {
+			MsgID int `json:"msg_id"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(line, &read); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-campaigned; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("campaign without a reply from lin-kv returned %v", err)
+	}
+
+	fmt.Fprintf(inW, `{"src":"lin-kv","dest":"n0","body":{"type":"error","code":20,"in_reply_to":%d}}`+"\n", read.Body.MsgID)
+	inW.Close()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the node did not stop after a late lin-kv reply")
+	}
+}
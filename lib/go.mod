@@ -0,0 +1,5 @@
+module maelstrom-lib
+
+go 1.24.1
+
+require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20250204203845-8263d1dd2b7a
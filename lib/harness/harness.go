@@ -0,0 +1,571 @@
+// Package harness runs compiled Maelstrom node binaries as child processes and
+// routes messages between them, their clients and the built-in KV services.
+// It is a small stand-in for the Maelstrom network that needs no JVM. Tests
+// can also run nodes in-process, as goroutines on the same simulated
+// network.
+package harness
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Options configures a cluster.
+type Options struct {
+	// NodeCount is the number of node processes to start.
+	NodeCount int
+
+	// Latency is the maximum random delay added to each inter-node message.
+	Latency time.Duration
+
+	// Stderr receives the nodes' log output. Nil discards it.
+	Stderr io.Writer
+}
+
+// Stats counts the messages routed through a cluster.
+type Stats struct {
+	// ClientMessages are requests from clients and the nodes' replies to them.
+	ClientMessages int64
+
+	// ServerMessages are messages between nodes and to the KV services.
+	ServerMessages int64
+
+	// ServerBytes is the total size of the server messages' bodies.
+	ServerBytes int64
+
+	// Dropped counts inter-node messages lost to partitions or sent to
+	// killed nodes.
+	Dropped int64
+}
+
+// Cluster is a set of running node processes.
+type Cluster struct {
+	opts    Options
+	bin     string
+	setup   func(*maelstrom.Node) // for in-process nodes
+	nodeIDs []string
+
+	nodesMu sync.RWMutex
+	nodes   map[string]*process
+	killed  map[string]bool
+
+	services map[string]*kvService
+
+	mu      sync.Mutex
+	pending map[string]chan maelstrom.Message
+	msgID   atomic.Int64
+
+	clientMsgs  atomic.Int64
+	serverMsgs  atomic.Int64
+	serverBytes atomic.Int64
+	dropped     atomic.Int64
+
+	partitionMu sync.RWMutex
+	partition   map[string]int // node ID -> component; nil when healed
+}
+
+type process struct {
+	cmd   *exec.Cmd // nil for in-process nodes
+	mu    sync.Mutex
+	stdin io.WriteCloser
+	done  chan struct{}
+
+	// In-process nodes are fed from a queue, so that routing never waits
+	// on a busy node, and are marked dead when killed: their goroutines
+	// cannot be stopped, so anything they still send is dropped instead.
+	queue *queue
+	dead  atomic.Bool
+}
+
+// Start launches opts.NodeCount copies of bin and performs the init
+// handshake with each of them.
+func Start(ctx context.Context, bin string, opts Options) (*Cluster, error) {
+	return start(ctx, bin, nil, opts)
+}
+
+// StartInProcess is like Start, but runs the nodes as goroutines of this
+// process. setup is called with every new node, including the replacement
+// of a restarted one, and registers its handlers before it is initialized.
+// In-process nodes share the standard logger, whose output is sent to
+// opts.Stderr.
+func StartInProcess(ctx context.Context, setup func(*maelstrom.Node), opts Options) (*Cluster, error) {
+	if opts.Stderr == nil {
+		log.SetOutput(io.Discard)
+	} else {
+		log.SetOutput(opts.Stderr)
+	}
+	return start(ctx, "", setup, opts)
+}
+
+func start(ctx context.Context, bin string, setup func(*maelstrom.Node), opts Options) (*Cluster, error) {
+	if opts.NodeCount < 1 {
+		return nil, errors.New("harness: node count must be at least 1")
+	}
+
+	c := &Cluster{
+		opts:    opts,
+		bin:     bin,
+		setup:   setup,
+		nodes:   make(map[string]*process),
+		killed:  make(map[string]bool),
+		pending: make(map[string]chan maelstrom.Message),
+		services: map[string]*kvService{
+			"lin-kv": newKVService("lin-kv"),
+			"seq-kv": newKVService("seq-kv"),
+			"lww-kv": newKVService("lww-kv"),
+		},
+	}
+	for i := 0; i < opts.NodeCount; i++ {
+		c.nodeIDs = append(c.nodeIDs, fmt.Sprintf("n%d", i))
+	}
+
+	for _, id := range c.nodeIDs {
+		p, err := c.spawn(id)
+		if err != nil {
+			c.Stop()
+			return nil, fmt.Errorf("start %s: %w", id, err)
+		}
+		c.nodesMu.Lock()
+		c.nodes[id] = p
+		c.nodesMu.Unlock()
+	}
+
+	for _, id := range c.nodeIDs {
+		if err := c.init(ctx, id); err != nil {
+			c.Stop()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// init performs the init handshake with node id.
+func (c *Cluster) init(ctx context.Context, id string) error {
+	resp, err := c.RPC(ctx, "c0", id, map[string]any{
+		"type":     "init",
+		"node_id":  id,
+		"node_ids": c.nodeIDs,
+	})
+	if err == nil && resp.Type() != "init_ok" {
+		err = fmt.Errorf("unexpected reply %s", resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("init %s: %w", id, err)
+	}
+	return nil
+}
+
+// Restart kills node id and starts a fresh process in its place, losing all
+// of its in-memory state. Messages sent to the node meanwhile are dropped.
+func (c *Cluster) Restart(ctx context.Context, id string) error {
+	c.nodesMu.Lock()
+	old, ok := c.nodes[id]
+	delete(c.nodes, id)
+	c.nodesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("harness: unknown node %q", id)
+	}
+	old.kill()
+
+	p, err := c.spawn(id)
+	if err != nil {
+		return fmt.Errorf("restart %s: %w", id, err)
+	}
+	c.nodesMu.Lock()
+	c.nodes[id] = p
+	c.nodesMu.Unlock()
+	return c.init(ctx, id)
+}
+
+// Kill stops node id for good. Messages sent to it from then on are
+// dropped, and it is left out of Alive.
+func (c *Cluster) Kill(id string) error {
+	c.nodesMu.Lock()
+	p, ok := c.nodes[id]
+	delete(c.nodes, id)
+	c.killed[id] = true
+	c.nodesMu.Unlock()
+	if !ok {
+		return fmt.Errorf("harness: unknown node %q", id)
+	}
+	p.kill()
+	return nil
+}
+
+func (p *process) kill() {
+	if p.cmd == nil {
+		p.dead.Store(true)
+		p.queue.close()
+		return
+	}
+	p.cmd.Process.Kill()
+	<-p.done
+	p.cmd.Wait()
+}
+
+func (c *Cluster) spawn(id string) (*process, error) {
+	if c.setup != nil {
+		return c.spawnInProcess(id), nil
+	}
+
+	cmd := exec.Command(c.bin)
+	cmd.Stderr = c.opts.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &process{cmd: cmd, stdin: stdin, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		c.routeOutput(id, p, stdout)
+	}()
+	return p, nil
+}
+
+// spawnInProcess runs a node set up by c.setup on pipes in place of its
+// STDIN and STDOUT.
+func (c *Cluster) spawnInProcess(id string) *process {
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	n := maelstrom.NewNode()
+	n.Stdin, n.Stdout = stdinR, stdoutW
+	c.setup(n)
+
+	p := &process{stdin: stdinW, done: make(chan struct{}), queue: newQueue()}
+	go func() {
+		for {
+			buf, ok := p.queue.pop()
+			if !ok {
+				stdinW.Close()
+				return
+			}
+			if _, err := stdinW.Write(buf); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(p.done)
+		if err := n.Run(); err != nil {
+			log.Printf("%s: %s", id, err)
+		}
+	}()
+	go c.routeOutput(id, p, stdoutR)
+	return p
+}
+
+// routeOutput routes the messages a node writes to out.
+func (c *Cluster) routeOutput(id string, p *process, out io.Reader) {
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if p.dead.Load() {
+			continue
+		}
+		var msg maelstrom.Message
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			log.Printf("%s: malformed output %q: %s", id, scanner.Text(), err)
+			continue
+		}
+		if msg.Src == "" {
+			msg.Src = id
+		}
+		c.route(msg)
+	}
+}
+
+// NodeIDs returns the IDs of the cluster's nodes.
+func (c *Cluster) NodeIDs() []string {
+	return c.nodeIDs
+}
+
+// Alive returns the IDs of the nodes that have not been killed.
+func (c *Cluster) Alive() []string {
+	c.nodesMu.RLock()
+	defer c.nodesMu.RUnlock()
+	alive := make([]string, 0, len(c.nodeIDs))
+	for _, id := range c.nodeIDs {
+		if !c.killed[id] {
+			alive = append(alive, id)
+		}
+	}
+	return alive
+}
+
+// Stats returns the message counts so far.
+func (c *Cluster) Stats() Stats {
+	return Stats{
+		ClientMessages: c.clientMsgs.Load(),
+		ServerMessages: c.serverMsgs.Load(),
+		ServerBytes:    c.serverBytes.Load(),
+		Dropped:        c.dropped.Load(),
+	}
+}
+
+// Partition splits the network into the given components: messages between
+// nodes in different components are dropped until Heal. Nodes left out of
+// every component are isolated. Clients can still reach every node, and so
+// can the KV services unless a component names them, as in
+// Partition([]string{"n0"}, []string{"n1", "n2", "lin-kv"}).
+func (c *Cluster) Partition(components ...[]string) {
+	partition := make(map[string]int)
+	for i, id := range c.nodeIDs {
+		partition[id] = -1 - i
+	}
+	for i, component := range components {
+		for _, id := range component {
+			partition[id] = i
+		}
+	}
+	c.partitionMu.Lock()
+	c.partition = partition
+	c.partitionMu.Unlock()
+}
+
+// Heal removes any partition.
+func (c *Cluster) Heal() {
+	c.partitionMu.Lock()
+	c.partition = nil
+	c.partitionMu.Unlock()
+}
+
+// partitioned reports whether a partition separates two nodes.
+func (c *Cluster) partitioned(a, b string) bool {
+	c.partitionMu.RLock()
+	defer c.partitionMu.RUnlock()
+	if c.partition == nil {
+		return false
+	}
+	pa, okA := c.partition[a]
+	pb, okB := c.partition[b]
+	return okA && okB && pa != pb
+}
+
+// RPC sends body from client to the node dest and waits for the reply. RPC
+// errors in the reply are returned as *maelstrom.RPCError.
+func (c *Cluster) RPC(ctx context.Context, client, dest string, body map[string]any) (maelstrom.Message, error) {
+	msgID := int(c.msgID.Add(1))
+	key := pendingKey(client, msgID)
+	ch := make(chan maelstrom.Message, 1)
+
+	c.mu.Lock()
+	c.pending[key] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	b := make(map[string]any, len(body)+1)
+	for k, v := range body {
+		b[k] = v
+	}
+	b["msg_id"] = msgID
+	buf, err := json.Marshal(b)
+	if err != nil {
+		return maelstrom.Message{}, err
+	}
+	c.route(maelstrom.Message{Src: client, Dest: dest, Body: buf})
+
+	select {
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	case msg := <-ch:
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	}
+}
+
+// route delivers a message to a node, a client or a service.
+func (c *Cluster) route(msg maelstrom.Message) {
+	switch {
+	case isClient(msg.Src) || isClient(msg.Dest):
+		c.clientMsgs.Add(1)
+	default:
+		c.serverMsgs.Add(1)
+		c.serverBytes.Add(int64(len(msg.Body)))
+	}
+
+	if !isClient(msg.Src) && !isClient(msg.Dest) && c.partitioned(msg.Src, msg.Dest) {
+		c.dropped.Add(1)
+		return
+	}
+
+	if svc, ok := c.services[msg.Dest]; ok {
+		if reply, ok := svc.handle(msg); ok {
+			c.route(reply)
+		}
+		return
+	}
+
+	if isClient(msg.Dest) {
+		var body maelstrom.MessageBody
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return
+		}
+		c.mu.Lock()
+		ch := c.pending[pendingKey(msg.Dest, body.InReplyTo)]
+		c.mu.Unlock()
+		if ch != nil {
+			select {
+			case ch <- msg:
+			default: // a duplicate reply; the first is the answer
+			}
+		}
+		return
+	}
+
+	c.nodesMu.RLock()
+	p, ok := c.nodes[msg.Dest]
+	killed := c.killed[msg.Dest]
+	c.nodesMu.RUnlock()
+	if killed {
+		c.dropped.Add(1)
+		return
+	}
+	if !ok {
+		log.Printf("dropping message to unknown destination %q", msg.Dest)
+		return
+	}
+	if c.opts.Latency > 0 && !isClient(msg.Src) {
+		delay := time.Duration(rand.Int63n(int64(c.opts.Latency)))
+		time.AfterFunc(delay, func() { p.deliver(msg) })
+		return
+	}
+	p.deliver(msg)
+}
+
+func (p *process) deliver(msg maelstrom.Message) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	if p.queue != nil {
+		p.queue.push(append(buf, '\n'))
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.stdin.Write(append(buf, '\n')); err != nil {
+		log.Printf("write to %s: %s", msg.Dest, err)
+	}
+}
+
+// Stop closes the nodes' STDIN and waits for them to exit.
+func (c *Cluster) Stop() error {
+	c.nodesMu.RLock()
+	defer c.nodesMu.RUnlock()
+	var errs []error
+	for id, p := range c.nodes {
+		if p.cmd == nil {
+			// Handlers that never return keep an in-process node from
+			// stopping; there is no process to kill, so leave it be.
+			p.queue.close()
+			select {
+			case <-p.done:
+			case <-time.After(5 * time.Second):
+			}
+			continue
+		}
+		p.mu.Lock()
+		p.stdin.Close()
+		p.mu.Unlock()
+		select {
+		case <-p.done:
+		case <-time.After(5 * time.Second):
+			p.cmd.Process.Kill()
+		}
+		if err := p.cmd.Wait(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func isClient(id string) bool {
+	return strings.HasPrefix(id, "c")
+}
+
+func pendingKey(client string, msgID int) string {
+	return fmt.Sprintf("%s/%d", client, msgID)
+}
+
+// queue is an unbounded FIFO of messages waiting to be written to an
+// in-process node.
+type queue struct {
+	mu     sync.Mutex
+	items  [][]byte
+	closed bool
+	ready  chan struct{} // signalled when items are added or the queue closes
+}
+
+func newQueue() *queue {
+	return &queue{ready: make(chan struct{}, 1)}
+}
+
+func (q *queue) push(item []byte) {
+	q.mu.Lock()
+	if !q.closed {
+		q.items = append(q.items, item)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *queue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// pop waits for the next item. It returns false once the queue is closed and
+// drained.
+func (q *queue) pop() ([]byte, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.items) > 0 {
+			item := q.items[0]
+			q.items = q.items[1:]
+			q.mu.Unlock()
+			return item, true
+		}
+		closed := q.closed
+		q.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		<-q.ready
+	}
+}
@@ -0,0 +1,78 @@
+package harness
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// kvService emulates Maelstrom's lin-kv, seq-kv and lww-kv services. It is
+// linearizable, which is a valid (if generous) implementation of all three.
+type kvService struct {
+	name string
+	mu   sync.Mutex
+	data map[string]any
+}
+
+func newKVService(name string) *kvService {
+	return &kvService{name: name, data: make(map[string]any)}
+}
+
+// handle applies a request and returns the reply to route back, if any.
+func (s *kvService) handle(msg maelstrom.Message) (maelstrom.Message, bool) {
+	var body struct {
+		maelstrom.MessageBody
+		Key               any  `json:"key"`
+		Value             any  `json:"value"`
+		From              any  `json:"from"`
+		To                any  `json:"to"`
+		CreateIfNotExists bool `json:"create_if_not_exists"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil || body.MsgID == 0 {
+		return maelstrom.Message{}, false
+	}
+	key, err := json.Marshal(body.Key)
+	if err != nil {
+		return maelstrom.Message{}, false
+	}
+
+	s.mu.Lock()
+	var reply any
+	switch body.Type {
+	case "read":
+		if v, ok := s.data[string(key)]; ok {
+			reply = map[string]any{"type": "read_ok", "value": v}
+		} else {
+			reply = maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		}
+	case "write":
+		s.data[string(key)] = body.Value
+		reply = map[string]any{"type": "write_ok"}
+	case "cas":
+		v, ok := s.data[string(key)]
+		switch {
+		case !ok && !body.CreateIfNotExists:
+			reply = maelstrom.NewRPCError(maelstrom.KeyDoesNotExist, "key does not exist")
+		case ok && !reflect.DeepEqual(v, body.From):
+			reply = maelstrom.NewRPCError(maelstrom.PreconditionFailed, "current value does not match from")
+		default:
+			s.data[string(key)] = body.To
+			reply = map[string]any{"type": "cas_ok"}
+		}
+	default:
+		reply = maelstrom.NewRPCError(maelstrom.NotSupported, "unsupported request type "+body.Type)
+	}
+	s.mu.Unlock()
+
+	b := make(map[string]any)
+	buf, _ := json.Marshal(reply)
+	json.Unmarshal(buf, &b)
+	b["in_reply_to"] = body.MsgID
+	out, err := json.Marshal(b)
+	if err != nil {
+		return maelstrom.Message{}, false
+	}
+	return maelstrom.Message{Src: s.name, Dest: msg.Src, Body: out}, true
+}
@@ -0,0 +1,148 @@
+// Package histogram records latencies in an HDR-style log-linear histogram:
+// every value is kept to within 0.1% of its true magnitude in constant
+// memory per power of two, however wide the range of values.
+package histogram
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+)
+
+// subBits sets the precision: each power of two is split into 2^(subBits-1)
+// linear buckets, for a relative error below 2^-(subBits-1).
+const subBits = 11
+
+const (
+	subCount = 1 << subBits
+	subHalf  = subCount / 2
+)
+
+// Histogram counts non-negative integer values, e.g. latencies in
+// microseconds. It is not safe for concurrent use.
+type Histogram struct {
+	counts []int64
+	total  int64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+// New returns an empty histogram.
+func New() *Histogram {
+	return &Histogram{min: math.MaxInt64}
+}
+
+// index returns the bucket for v. Values below subCount get a bucket each;
+// above that every power of two gets subHalf buckets.
+func index(v int64) int {
+	if v < subCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBits
+	return subCount + (shift-1)*subHalf + int(v>>shift) - subHalf
+}
+
+// upper returns the largest value that falls into bucket i.
+func upper(i int) int64 {
+	if i < subCount {
+		return int64(i)
+	}
+	shift := (i-subCount)/subHalf + 1
+	sub := int64((i-subCount)%subHalf + subHalf)
+	return (sub+1)<<shift - 1
+}
+
+// Record adds one occurrence of v. Negative values are recorded as zero.
+func (h *Histogram) Record(v int64) {
+	v = max(v, 0)
+	i := index(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]int64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += float64(v)
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+}
+
+// Merge adds the counts of o into h.
+func (h *Histogram) Merge(o *Histogram) {
+	if len(o.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]int64, len(o.counts)-len(h.counts))...)
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	h.total += o.total
+	h.sum += o.sum
+	h.min = min(h.min, o.min)
+	h.max = max(h.max, o.max)
+}
+
+// Count returns the number of recorded values.
+func (h *Histogram) Count() int64 { return h.total }
+
+// Min returns the smallest recorded value, or zero if there are none.
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value.
+func (h *Histogram) Max() int64 { return h.max }
+
+// Mean returns the average of the recorded values.
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// Quantile returns the value below which the fraction q of recorded values
+// fall, to within the histogram's precision.
+func (h *Histogram) Quantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	rank = min(max(rank, 1), h.total)
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(upper(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// Percentiles is the default set of quantiles printed by Report.
+var Percentiles = []float64{0.5, 0.75, 0.9, 0.99, 0.999, 0.9999}
+
+// Report writes a percentile table in the style of HdrHistogram's output,
+// dividing values by scale (e.g. 1000 to print microseconds as
+// milliseconds).
+func (h *Histogram) Report(w io.Writer, unit string, scale float64) {
+	fmt.Fprintf(w, "%12s %12s %10s\n", "Value("+unit+")", "Percentile", "Count")
+	for _, q := range Percentiles {
+		v := h.Quantile(q)
+		fmt.Fprintf(w, "%12.3f %12.6f %10d\n", float64(v)/scale, q, h.countAtOrBelow(v))
+	}
+	fmt.Fprintf(w, "%12.3f %12.6f %10d\n", float64(h.Max())/scale, 1.0, h.total)
+	fmt.Fprintf(w, "#[Mean = %.3f, Min = %.3f, Max = %.3f, Total count = %d]\n",
+		h.Mean()/scale, float64(h.Min())/scale, float64(h.Max())/scale, h.total)
+}
+
+func (h *Histogram) countAtOrBelow(v int64) int64 {
+	var n int64
+	for _, c := range h.counts[:min(index(v)+1, len(h.counts))] {
+		n += c
+	}
+	return n
+}
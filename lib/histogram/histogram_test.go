@@ -0,0 +1,127 @@
+package histogram
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuckets(t *testing.T) {
+	tests := []struct {
+		v     int64
+		index int
+		upper int64
+	}{
+		{0, 0, 0},
+		{1, 1, 1},
+		// The last value with a bucket of its own.
+		{subCount - 1, subCount - 1, subCount - 1},
+		// From here on buckets are two wide, then four, and so on.
+		{subCount, subCount, subCount + 1},
+		{subCount + 1, subCount, subCount + 1},
+		{subCount + 2, subCount + 1, subCount + 3},
+		{2*subCount - 1, subCount + subHalf - 1, 2*subCount - 1},
+		{2 * subCount, subCount + subHalf, 2*subCount + 3},
+		{2*subCount + 3, subCount + subHalf, 2*subCount + 3},
+		{2*subCount + 4, subCount + subHalf + 1, 2*subCount + 7},
+		{math.MaxInt64, subCount + 52*subHalf - 1, math.MaxInt64},
+	}
+	for _, tt := range tests {
+		i := index(tt.v)
+		if i != tt.index {
+			t.Errorf("index(%d) = %d, want %d", tt.v, i, tt.index)
+		}
+		if u := upper(i); u != tt.upper {
+			t.Errorf("upper(index(%d)) = %d, want %d", tt.v, u, tt.upper)
+		}
+	}
+}
+
+// TestBucketsTile checks that the buckets cover every value once, each no
+// wider than the promised precision.
+func TestBucketsTile(t *testing.T) {
+	last := index(math.MaxInt64)
+	lower := int64(0)
+	for i := 0; i <= last; i++ {
+		u := upper(i)
+		if u < lower {
+			t.Fatalf("bucket %d ends at %d, before it starts at %d", i, u, lower)
+		}
+		if index(lower) != i || index(u) != i {
+			t.Fatalf("bucket %d holds [%d, %d], but they index to %d and %d", i, lower, u, index(lower), index(u))
+		}
+		if width := u - lower; lower > 0 && float64(width)/float64(lower) >= 1.0/subHalf {
+			t.Fatalf("bucket %d holds [%d, %d], wider than 1/%d of its values", i, lower, u, subHalf)
+		}
+		if i < last {
+			lower = u + 1
+		}
+	}
+	if upper(last) != math.MaxInt64 {
+		t.Fatalf("the last bucket ends at %d", upper(last))
+	}
+}
+
+func TestQuantiles(t *testing.T) {
+	h := New()
+	for v := int64(1); v <= 10000; v++ {
+		h.Record(v)
+	}
+	tests := []struct {
+		q    float64
+		want int64
+	}{
+		{0, 1},
+		{0.001, 10},
+		{0.1, 1000},
+		{0.2047, 2047},
+		// Values from here on share buckets; each quantile is the top of
+		// its value's bucket.
+		{0.5, 5003},
+		{0.9, 9007},
+		{0.99, 9903},
+		// The top bucket reaches 10007, but nothing above 10000 was seen.
+		{1, 10000},
+	}
+	for _, tt := range tests {
+		if got := h.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v) = %d, want %d", tt.q, got, tt.want)
+		}
+	}
+	if h.Count() != 10000 || h.Min() != 1 || h.Max() != 10000 || h.Mean() != 5000.5 {
+		t.Errorf("count %d, min %d, max %d, mean %v", h.Count(), h.Min(), h.Max(), h.Mean())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	whole, low, high := New(), New(), New()
+	for v := int64(1); v <= 10000; v++ {
+		whole.Record(v)
+		if v <= 5000 {
+			low.Record(v)
+		} else {
+			high.Record(v)
+		}
+	}
+	merged := New()
+	merged.Merge(high)
+	merged.Merge(low)
+	for _, q := range append(Percentiles, 0, 1) {
+		if got, want := merged.Quantile(q), whole.Quantile(q); got != want {
+			t.Errorf("merged Quantile(%v) = %d, want %d", q, got, want)
+		}
+	}
+	if merged.Min() != 1 || merged.Max() != 10000 || merged.Count() != 10000 {
+		t.Errorf("merged count %d, min %d, max %d", merged.Count(), merged.Min(), merged.Max())
+	}
+}
+
+func TestEmptyAndNegative(t *testing.T) {
+	h := New()
+	if h.Quantile(0.5) != 0 || h.Min() != 0 || h.Max() != 0 || h.Mean() != 0 {
+		t.Fatalf("empty histogram: p50 %d, min %d, max %d, mean %v", h.Quantile(0.5), h.Min(), h.Max(), h.Mean())
+	}
+	h.Record(-5)
+	if h.Quantile(1) != 0 || h.Min() != 0 {
+		t.Fatalf("after recording -5: p100 %d, min %d, want 0", h.Quantile(1), h.Min())
+	}
+}
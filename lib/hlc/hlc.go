@@ -0,0 +1,189 @@
+// Package hlc implements a hybrid logical clock. Timestamps combine the
+// node's physical clock with a logical counter so that they stay close to
+// wall time while still respecting causality across nodes.
+package hlc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// Field is the message body key used to piggyback timestamps on
+// inter-node messages.
+const Field = "hlc"
+
+// DefaultMaxOffset is the clock offset between nodes beyond which remote
+// timestamps are rejected. Maelstrom runs every node on one machine, so a
+// larger offset means a node is misbehaving.
+const DefaultMaxOffset = 500 * time.Millisecond
+
+// ErrClockOffset is returned by Update when a remote timestamp is further
+// ahead of the local physical clock than the configured maximum offset.
+var ErrClockOffset = errors.New("hlc: remote clock too far ahead")
+
+// Timestamp is a point in hybrid logical time. Wall is in milliseconds since
+// the Unix epoch.
+type Timestamp struct {
+	Wall    int64
+	Logical uint32
+}
+
+// Compare returns -1, 0 or 1 depending on whether a is before, equal to or
+// after b.
+func Compare(a, b Timestamp) int {
+	switch {
+	case a.Wall < b.Wall:
+		return -1
+	case a.Wall > b.Wall:
+		return 1
+	case a.Logical < b.Logical:
+		return -1
+	case a.Logical > b.Logical:
+		return 1
+	}
+	return 0
+}
+
+// Before reports whether t happened before o.
+func (t Timestamp) Before(o Timestamp) bool { return Compare(t, o) < 0 }
+
+// IsZero reports whether t is the zero timestamp.
+func (t Timestamp) IsZero() bool { return t.Wall == 0 && t.Logical == 0 }
+
+func (t Timestamp) String() string {
+	return fmt.Sprintf("%d.%d", t.Wall, t.Logical)
+}
+
+// MarshalJSON encodes the timestamp compactly as [wall, logical].
+func (t Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]int64{t.Wall, int64(t.Logical)})
+}
+
+// UnmarshalJSON decodes a timestamp encoded by MarshalJSON.
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var v [2]int64
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v[1] < 0 || v[1] > math.MaxUint32 {
+		return fmt.Errorf("hlc: logical component out of range: %d", v[1])
+	}
+	t.Wall, t.Logical = v[0], uint32(v[1])
+	return nil
+}
+
+// Clock is a hybrid logical clock. It is safe for concurrent use.
+type Clock struct {
+	mu        sync.Mutex
+	physical  func() int64
+	maxOffset int64
+	last      Timestamp
+}
+
+// NewClock returns a clock reading physical time from the given function,
+// which must return milliseconds. A nil function uses the system clock.
+// Remote timestamps more than maxOffset ahead of local physical time are
+// rejected by Update; zero disables the check.
+func NewClock(physical func() int64, maxOffset time.Duration) *Clock {
+	if physical == nil {
+		physical = func() int64 { return time.Now().UnixMilli() }
+	}
+	return &Clock{physical: physical, maxOffset: maxOffset.Milliseconds()}
+}
+
+// Now returns a timestamp for a local or send event. Successive calls always
+// return strictly increasing timestamps, even if the physical clock goes
+// backwards.
+func (c *Clock) Now() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := c.physical()
+	if pt > c.last.Wall {
+		c.last = Timestamp{Wall: pt}
+	} else {
+		c.tick()
+	}
+	return c.last
+}
+
+// Update merges a timestamp received from another node and returns a local
+// timestamp that is after both it and every timestamp previously issued.
+func (c *Clock) Update(remote Timestamp) (Timestamp, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pt := c.physical()
+	if c.maxOffset > 0 && remote.Wall-pt > c.maxOffset {
+		return c.last, fmt.Errorf("%w: remote=%s local=%d", ErrClockOffset, remote, pt)
+	}
+
+	switch {
+	case pt > c.last.Wall && pt > remote.Wall:
+		c.last = Timestamp{Wall: pt}
+	case remote.Wall > c.last.Wall:
+		c.last = remote
+		c.tick()
+	case remote.Wall == c.last.Wall:
+		c.last.Logical = max(c.last.Logical, remote.Logical)
+		c.tick()
+	default:
+		c.tick()
+	}
+	return c.last, nil
+}
+
+// Advance moves the clock to at least t, as a node that restarts does with
+// a bound it saved on every timestamp it issued before. Unlike Update, it
+// does not check t against the maximum offset.
+func (c *Clock) Advance(t Timestamp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last.Before(t) {
+		c.last = t
+	}
+}
+
+// Last returns the most recently issued timestamp without advancing the clock.
+func (c *Clock) Last() Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.last
+}
+
+// tick advances the logical component, spilling into the wall component if
+// the counter would overflow.
+func (c *Clock) tick() {
+	if c.last.Logical == math.MaxUint32 {
+		c.last.Wall++
+		c.last.Logical = 0
+		return
+	}
+	c.last.Logical++
+}
+
+// Stamp sets the hlc field of an outgoing message body to a fresh timestamp
+// and returns the body for convenience.
+func (c *Clock) Stamp(body map[string]any) map[string]any {
+	body[Field] = c.Now()
+	return body
+}
+
+// Observe updates the clock from the hlc field of an incoming message body.
+// Bodies without the field are ignored.
+func (c *Clock) Observe(body json.RawMessage) (Timestamp, error) {
+	var v struct {
+		HLC *Timestamp `json:"hlc"`
+	}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return Timestamp{}, err
+	}
+	if v.HLC == nil {
+		return c.Now(), nil
+	}
+	return c.Update(*v.HLC)
+}
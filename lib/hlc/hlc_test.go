@@ -0,0 +1,216 @@
+package hlc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// fakeClock is a physical clock the test moves by hand.
+type fakeClock struct {
+	mu  sync.Mutex
+	now int64
+}
+
+func (f *fakeClock) read() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) set(ms int64) {
+	f.mu.Lock()
+	f.now = ms
+	f.mu.Unlock()
+}
+
+func TestNowIsMonotonic(t *testing.T) {
+	phys := &fakeClock{now: 1000}
+	c := NewClock(phys.read, 0)
+
+	// The physical clock stalls, steps back and jumps ahead.
+	steps := []int64{1000, 1000, 999, 500, 1001, 1001, 5000, 4000}
+	var last Timestamp
+	for _, ms := range steps {
+		phys.set(ms)
+		ts := c.Now()
+		if !last.Before(ts) {
+			t.Fatalf("physical %d: %s does not follow %s", ms, ts, last)
+		}
+		if ts.Wall < ms {
+			t.Fatalf("physical %d: %s is behind the physical clock", ms, ts)
+		}
+		last = ts
+	}
+}
+
+func TestUpdateUnderSkew(t *testing.T) {
+	// Three nodes whose physical clocks are skewed by up to a second and
+	// drift independently exchange messages at random. Every receive must
+	// follow its send, and every node's timestamps must keep increasing.
+	rng := rand.New(rand.NewSource(1))
+	phys := []*fakeClock{{now: 10_000}, {now: 10_900}, {now: 9_100}}
+	clocks := make([]*Clock, len(phys))
+	last := make([]Timestamp, len(phys))
+	for i, p := range phys {
+		clocks[i] = NewClock(p.read, 0)
+	}
+	advance := func(i int, ts Timestamp) {
+		t.Helper()
+		if !last[i].Before(ts) {
+			t.Fatalf("node %d went from %s to %s", i, last[i], ts)
+		}
+		last[i] = ts
+	}
+
+	fastest := int64(0) // the latest physical time any node has read
+	for range 10_000 {
+		for _, p := range phys {
+			p.set(p.read() + rng.Int63n(3) - 1) // -1, 0 or +1 ms
+			fastest = max(fastest, p.read())
+		}
+		from, to := rng.Intn(len(phys)), rng.Intn(len(phys))
+		sent := clocks[from].Now()
+		advance(from, sent)
+		if from == to {
+			continue
+		}
+		received, err := clocks[to].Update(sent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sent.Before(received) {
+			t.Fatalf("received %s at node %d for %s sent by node %d", received, to, sent, from)
+		}
+		advance(to, received)
+	}
+
+	// Timestamps only run ahead of a node's physical clock to follow
+	// another node's, so none is ahead of every physical clock.
+	for i, c := range clocks {
+		if ts := c.Last(); ts.Wall > fastest {
+			t.Errorf("node %d is at %s, far ahead of every physical clock", i, ts)
+		}
+	}
+}
+
+func TestUpdateRejectsExcessiveOffset(t *testing.T) {
+	phys := &fakeClock{now: 1000}
+	c := NewClock(phys.read, 500*time.Millisecond)
+	before := c.Now()
+
+	if _, err := c.Update(Timestamp{Wall: 1600}); !errors.Is(err, ErrClockOffset) {
+		t.Fatalf("update 600ms ahead: err = %v, want ErrClockOffset", err)
+	}
+	if got := c.Last(); got != before {
+		t.Fatalf("rejected update moved the clock from %s to %s", before, got)
+	}
+	if ts, err := c.Update(Timestamp{Wall: 1400, Logical: 7}); err != nil || ts != (Timestamp{Wall: 1400, Logical: 8}) {
+		t.Fatalf("update 400ms ahead = %s, %v; want 1400.8", ts, err)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	phys := &fakeClock{now: 1000}
+	c := NewClock(phys.read, 500*time.Millisecond)
+	// A bound saved before a restart may be further ahead than any remote
+	// clock is allowed to be.
+	c.Advance(Timestamp{Wall: 3000})
+	if ts := c.Now(); ts != (Timestamp{Wall: 3000, Logical: 1}) {
+		t.Fatalf("Now after advancing to 3000 = %s, want 3000.1", ts)
+	}
+	c.Advance(Timestamp{Wall: 2000})
+	if ts := c.Now(); ts != (Timestamp{Wall: 3000, Logical: 2}) {
+		t.Fatalf("Now after advancing to an earlier time = %s, want 3000.2", ts)
+	}
+}
+
+func TestLogicalOverflowSpillsIntoWall(t *testing.T) {
+	phys := &fakeClock{now: 1000}
+	c := NewClock(phys.read, 0)
+	if _, err := c.Update(Timestamp{Wall: 1000, Logical: math.MaxUint32 - 1}); err != nil {
+		t.Fatal(err)
+	}
+	if ts := c.Now(); ts != (Timestamp{Wall: 1001}) {
+		t.Fatalf("Now after the last logical value = %s, want 1001.0", ts)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	ts := Timestamp{Wall: 1700000000123, Logical: 42}
+	buf, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "[1700000000123,42]" {
+		t.Fatalf("encoded as %s", buf)
+	}
+	var got Timestamp
+	if err := json.Unmarshal(buf, &got); err != nil || got != ts {
+		t.Fatalf("decoded %s, %v; want %s", got, err, ts)
+	}
+	if err := json.Unmarshal([]byte("[1,-1]"), &got); err == nil {
+		t.Fatal("decoded a negative logical component")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	// n1's physical clock runs a minute ahead of n0's. Once n0 hears from
+	// n1, its timestamps follow n1's.
+	base := time.Now().UnixMilli()
+	var mu sync.Mutex
+	clocks := make(map[string]*Clock)
+	setup := func(n *maelstrom.Node) {
+		// Nodes are set up in order, n0 first.
+		mu.Lock()
+		skew := int64(len(clocks)) * 60_000
+		c := NewClock(func() int64 { return base + skew }, 0)
+		clocks["n"+strconv.Itoa(len(clocks))] = c
+		mu.Unlock()
+		c.Attach(n)
+
+		n.Handle("ping", func(msg maelstrom.Message) error {
+			if err := n.RPC("n1", map[string]any{"type": "hello"}, func(maelstrom.Message) error { return nil }); err != nil {
+				return err
+			}
+			return n.Reply(msg, map[string]any{"type": "ping_ok"})
+		})
+		n.Handle("hello", func(msg maelstrom.Message) error {
+			return n.Reply(msg, map[string]any{"type": "hello_ok"})
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	cluster, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.Stop()
+
+	// n0 says hello to n1, stamping its message; n1 replies, stamping its
+	// reply.
+	if _, err := cluster.RPC(ctx, "c1", "n0", map[string]any{"type": "ping"}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	n0, n1 := clocks["n0"], clocks["n1"]
+	mu.Unlock()
+	deadline := time.Now().Add(5 * time.Second)
+	for n0.Last().Wall < base+60_000 {
+		if time.Now().After(deadline) {
+			t.Fatalf("n0 is at %s after hearing from n1 at %s", n0.Last(), n1.Last())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
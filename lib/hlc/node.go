@@ -0,0 +1,95 @@
+package hlc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Attach piggybacks the clock on every message n exchanges with other
+// nodes: each one it sends is stamped with a fresh timestamp in the hlc
+// field, and each one it receives is observed before it is handled. Messages
+// to and from clients and services are left alone. Call Attach before n.Run.
+func (c *Clock) Attach(n *maelstrom.Node) {
+	n.Stdout = &stampWriter{clock: c, out: n.Stdout}
+
+	in := n.Stdin
+	r, w := io.Pipe()
+	n.Stdin = r
+	go func() {
+		scanner := bufio.NewScanner(in)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			var msg maelstrom.Message
+			if err := json.Unmarshal(line, &msg); err == nil && isNode(msg.Src) {
+				if _, err := c.Observe(msg.Body); err != nil {
+					log.Printf("hlc: message from %s: %s", msg.Src, err)
+				}
+			}
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return
+			}
+		}
+		w.CloseWithError(scanner.Err())
+	}()
+}
+
+// stampWriter stamps the messages a node writes to other nodes. The node
+// writes each message and its newline separately, so lines are buffered
+// until complete.
+type stampWriter struct {
+	clock *Clock
+	out   io.Writer
+	buf   []byte
+}
+
+func (s *stampWriter) Write(p []byte) (int, error) {
+	s.buf = append(s.buf, p...)
+	for {
+		i := bytes.IndexByte(s.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := s.stamp(s.buf[:i])
+		s.buf = s.buf[i+1:]
+		if _, err := s.out.Write(append(line, '\n')); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// stamp returns line with a fresh timestamp in the body, if it is a message
+// to another node.
+func (s *stampWriter) stamp(line []byte) []byte {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil || !isNode(msg.Dest) {
+		return line
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return line
+	}
+	ts, _ := json.Marshal(s.clock.Now())
+	body[Field] = ts
+	msg.Body, _ = json.Marshal(body)
+	stamped, err := json.Marshal(msg)
+	if err != nil {
+		return line
+	}
+	return stamped
+}
+
+// isNode reports whether id names a node, as opposed to a client ("c1") or
+// a service ("lin-kv").
+func isNode(id string) bool {
+	return len(id) > 1 && strings.HasPrefix(id, "n") && strings.Trim(id[1:], "0123456789") == ""
+}
@@ -0,0 +1,197 @@
+// Package iblt implements Invertible Bloom Lookup Tables for computing the
+// difference between two sets of 64-bit keys, and a strata estimator for
+// sizing them. Two peers each insert their keys into a table of the same
+// size; subtracting one from the other cancels the shared keys, and decoding
+// the result lists the keys only one side has. A table needs about 1.5 cells
+// per differing key to decode, however large the sets are.
+package iblt
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// hashCount is how many cells each key is added to. The cells come from
+// disjoint thirds of the table, so a key never hits the same cell twice.
+const hashCount = 3
+
+// cellSize is the encoded size of a cell in bytes.
+const cellSize = 4 + 8 + 8
+
+type cell struct {
+	count   int32
+	keySum  uint64
+	hashSum uint64
+}
+
+// pure reports whether the cell holds exactly one key, inserted or deleted.
+func (c cell) pure() bool {
+	return (c.count == 1 || c.count == -1) && checksum(c.keySum) == c.hashSum
+}
+
+// Table is an Invertible Bloom Lookup Table. It is not safe for concurrent
+// use.
+type Table struct {
+	cells []cell
+}
+
+// New returns a table with at least size cells.
+func New(size int) *Table {
+	size = max(size, hashCount)
+	size = (size + hashCount - 1) / hashCount * hashCount
+	return &Table{cells: make([]cell, size)}
+}
+
+// SizeFor returns a table size that decodes a difference of d keys with
+// high probability, leaving room for the estimate to be somewhat low.
+func SizeFor(d int) int {
+	return d*5/2 + 16
+}
+
+// Len returns the number of cells.
+func (t *Table) Len() int { return len(t.cells) }
+
+// Insert adds key to the table.
+func (t *Table) Insert(key uint64) { t.update(key, 1) }
+
+// Delete removes key from the table. Deleting a key that was never
+// inserted is allowed; it then decodes as a key of the other side.
+func (t *Table) Delete(key uint64) { t.update(key, -1) }
+
+func (t *Table) update(key uint64, delta int32) {
+	sum := checksum(key)
+	for i := range hashCount {
+		c := &t.cells[t.index(key, i)]
+		c.count += delta
+		c.keySum ^= key
+		c.hashSum ^= sum
+	}
+}
+
+// index returns the cell for the i-th hash of key, within the i-th third.
+func (t *Table) index(key uint64, i int) int {
+	part := len(t.cells) / hashCount
+	h := mix(key + uint64(i+1)*0x9e3779b97f4a7c15)
+	hi, _ := bits.Mul64(h, uint64(part))
+	return i*part + int(hi)
+}
+
+// Subtract returns t minus o. Keys in both cancel out, leaving keys only in
+// t with a count of 1 and keys only in o with a count of -1.
+func (t *Table) Subtract(o *Table) (*Table, error) {
+	if len(t.cells) != len(o.cells) {
+		return nil, fmt.Errorf("iblt: cannot subtract a table of %d cells from one of %d", len(o.cells), len(t.cells))
+	}
+	diff := &Table{cells: make([]cell, len(t.cells))}
+	for i, c := range t.cells {
+		diff.cells[i] = cell{
+			count:   c.count - o.cells[i].count,
+			keySum:  c.keySum ^ o.cells[i].keySum,
+			hashSum: c.hashSum ^ o.cells[i].hashSum,
+		}
+	}
+	return diff, nil
+}
+
+// ErrUndecodable is returned when a table holds too many keys to list.
+var ErrUndecodable = errors.New("iblt: too many keys to decode")
+
+// Decode lists the keys in a difference table: added were inserted (only
+// in the minuend) and removed were deleted (only in the subtrahend). It
+// returns ErrUndecodable, and whatever it recovered, if the table was too
+// small for the difference. t is left empty or partially decoded.
+func (t *Table) Decode() (added, removed []uint64, err error) {
+	queue := make([]int, 0, len(t.cells))
+	for i, c := range t.cells {
+		if c.pure() {
+			queue = append(queue, i)
+		}
+	}
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		c := t.cells[i]
+		if !c.pure() {
+			continue // emptied by an earlier key
+		}
+		key := c.keySum
+		if c.count == 1 {
+			added = append(added, key)
+		} else {
+			removed = append(removed, key)
+		}
+		t.update(key, -c.count)
+		for h := range hashCount {
+			if j := t.index(key, h); t.cells[j].pure() {
+				queue = append(queue, j)
+			}
+		}
+	}
+	for _, c := range t.cells {
+		if c != (cell{}) {
+			return added, removed, ErrUndecodable
+		}
+	}
+	return added, removed, nil
+}
+
+// MarshalBinary encodes the table's cells.
+func (t *Table) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 0, len(t.cells)*cellSize)
+	for _, c := range t.cells {
+		b = binary.BigEndian.AppendUint32(b, uint32(c.count))
+		b = binary.BigEndian.AppendUint64(b, c.keySum)
+		b = binary.BigEndian.AppendUint64(b, c.hashSum)
+	}
+	return b, nil
+}
+
+// UnmarshalBinary decodes cells encoded by MarshalBinary.
+func (t *Table) UnmarshalBinary(b []byte) error {
+	if len(b)%cellSize != 0 || len(b)/cellSize%hashCount != 0 {
+		return fmt.Errorf("iblt: %d bytes is not a whole table", len(b))
+	}
+	t.cells = make([]cell, len(b)/cellSize)
+	for i := range t.cells {
+		c := b[i*cellSize:]
+		t.cells[i] = cell{
+			count:   int32(binary.BigEndian.Uint32(c)),
+			keySum:  binary.BigEndian.Uint64(c[4:]),
+			hashSum: binary.BigEndian.Uint64(c[12:]),
+		}
+	}
+	return nil
+}
+
+// MarshalJSON encodes the table as a base64 string of its binary form, which
+// keeps 64-bit sums exact where JSON numbers would round them.
+func (t *Table) MarshalJSON() ([]byte, error) {
+	b, _ := t.MarshalBinary()
+	return json.Marshal(b)
+}
+
+func (t *Table) UnmarshalJSON(data []byte) error {
+	var b []byte
+	if err := json.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	return t.UnmarshalBinary(b)
+}
+
+// checksum is the hash stored alongside each key to recognize pure cells.
+func checksum(key uint64) uint64 {
+	return mix(key ^ 0x5851f42d4c957f2d)
+}
+
+// mix is the splitmix64 finalizer.
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,231 @@
+package iblt
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// sets returns two sets sharing shared keys, with onlyA keys only in the
+// first and onlyB only in the second, and the keys unique to each.
+func sets(r *rand.Rand, shared, onlyA, onlyB int) (a, b, wantA, wantB []uint64) {
+	seen := make(map[uint64]bool)
+	fresh := func(n int) []uint64 {
+		keys := make([]uint64, 0, n)
+		for len(keys) < n {
+			k := r.Uint64()
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+		return keys
+	}
+	common := fresh(shared)
+	wantA, wantB = fresh(onlyA), fresh(onlyB)
+	a = append(slices.Clone(common), wantA...)
+	b = append(slices.Clone(common), wantB...)
+	return a, b, wantA, wantB
+}
+
+func table(size int, keys []uint64) *Table {
+	t := New(size)
+	for _, k := range keys {
+		t.Insert(k)
+	}
+	return t
+}
+
+func sorted(keys []uint64) []uint64 {
+	keys = slices.Clone(keys)
+	slices.Sort(keys)
+	return keys
+}
+
+func TestDecode(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	for _, d := range []int{0, 1, 2, 10, 100, 1000} {
+		t.Run(fmt.Sprint(d), func(t *testing.T) {
+			a, b, wantA, wantB := sets(r, 5000, d/2, d-d/2)
+			diff, err := table(SizeFor(d), a).Subtract(table(SizeFor(d), b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			added, removed, err := diff.Decode()
+			if err != nil {
+				t.Fatalf("decoding a difference of %d in %d cells: %s", d, SizeFor(d), err)
+			}
+			if !slices.Equal(sorted(added), sorted(wantA)) || !slices.Equal(sorted(removed), sorted(wantB)) {
+				t.Fatalf("decoded +%d -%d keys, want +%d -%d", len(added), len(removed), len(wantA), len(wantB))
+			}
+		})
+	}
+}
+
+func TestDecodeDeleted(t *testing.T) {
+	// Deleting keys that were never inserted leaves them to decode as the
+	// other side's.
+	tab := New(SizeFor(3))
+	tab.Insert(1)
+	tab.Delete(2)
+	tab.Delete(3)
+	added, removed, err := tab.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(added, []uint64{1}) || !slices.Equal(sorted(removed), []uint64{2, 3}) {
+		t.Fatalf("decoded +%v -%v, want +[1] -[2 3]", added, removed)
+	}
+}
+
+func TestDecodeTooSmall(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+	a, b, wantA, wantB := sets(r, 100, 200, 200)
+	diff, err := table(30, a).Subtract(table(30, b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, removed, err := diff.Decode()
+	if !errors.Is(err, ErrUndecodable) {
+		t.Fatalf("decoding 400 keys from 30 cells: got %v, want ErrUndecodable", err)
+	}
+	// Whatever was recovered is genuine.
+	for _, k := range added {
+		if !slices.Contains(wantA, k) {
+			t.Errorf("decoded %d as only in a", k)
+		}
+	}
+	for _, k := range removed {
+		if !slices.Contains(wantB, k) {
+			t.Errorf("decoded %d as only in b", k)
+		}
+	}
+}
+
+func TestSubtractMismatch(t *testing.T) {
+	if _, err := New(30).Subtract(New(60)); err == nil {
+		t.Fatal("subtracted tables of different sizes")
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(5, 6))
+	// A roomy table, so that only the encoding is under test: small ones
+	// fail to decode now and then.
+	a, b, wantA, wantB := sets(r, 50, 5, 5)
+	buf, err := json.Marshal(table(SizeFor(100), a))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Table
+	if err := json.Unmarshal(buf, &got); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := got.Subtract(table(SizeFor(100), b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, removed, err := diff.Decode()
+	if err != nil || !slices.Equal(sorted(added), sorted(wantA)) || !slices.Equal(sorted(removed), sorted(wantB)) {
+		t.Fatalf("decoded +%d -%d (%v) after a round trip, want +%d -%d", len(added), len(removed), err, len(wantA), len(wantB))
+	}
+
+	if err := got.UnmarshalBinary(make([]byte, cellSize*4)); err == nil {
+		t.Error("decoded 4 cells, which is not a whole table")
+	}
+}
+
+func strata(keys []uint64) *Strata {
+	s := NewStrata(DefaultStrata, DefaultStrataSize)
+	for _, k := range keys {
+		s.Insert(k)
+	}
+	return s
+}
+
+func TestStrataEstimate(t *testing.T) {
+	r := rand.New(rand.NewPCG(7, 8))
+	for _, d := range []int{10, 100, 1000, 10000} {
+		// The estimate is noisy, so check its spread over many pairs of
+		// sets: it must not fall far short, or the tables it sizes fail
+		// to decode.
+		var estimates []int
+		for range 50 {
+			a, b, _, _ := sets(r, 2000, d/2, d-d/2)
+			got, err := strata(a).Estimate(strata(b))
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimates = append(estimates, got)
+		}
+		slices.Sort(estimates)
+		if low, median := estimates[0], estimates[len(estimates)/2]; low < d/3 || median < d*2/3 || median > d*3/2 {
+			t.Errorf("estimated a difference of %d as %d at the lowest and %d at the median", d, low, median)
+		}
+	}
+
+	a, _, _, _ := sets(r, 2000, 0, 0)
+	if got, err := strata(a).Estimate(strata(a)); err != nil || got != 0 {
+		t.Errorf("estimated the difference of a set with itself as %d (%v)", got, err)
+	}
+}
+
+func TestStrataEstimateRejectsMalformed(t *testing.T) {
+	s := strata([]uint64{1, 2, 3})
+	holed := s.Clone()
+	holed.Levels[4] = nil
+	for name, o := range map[string]*Strata{
+		"nil":            nil,
+		"no levels":      {},
+		"fewer strata":   NewStrata(DefaultStrata-1, DefaultStrataSize),
+		"smaller strata": NewStrata(DefaultStrata, DefaultStrataSize/2),
+		"missing level":  holed,
+	} {
+		if _, err := s.Estimate(o); err == nil {
+			t.Errorf("%s: estimated a difference", name)
+		}
+	}
+
+	// A peer that sent in_sync:false without an estimator.
+	var reply struct {
+		Strata *Strata `json:"strata"`
+	}
+	if err := json.Unmarshal([]byte(`{"in_sync":false}`), &reply); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Estimate(reply.Strata); err == nil {
+		t.Error("estimated a difference from a reply without strata")
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, d := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("diff=%d", d), func(b *testing.B) {
+			r := rand.New(rand.NewPCG(9, 10))
+			x, y, _, _ := sets(r, 10000, d/2, d-d/2)
+			tx, ty := table(SizeFor(d), x), table(SizeFor(d), y)
+			b.ResetTimer()
+			for range b.N {
+				diff, _ := tx.Subtract(ty)
+				if _, _, err := diff.Decode(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkStrataEstimate(b *testing.B) {
+	r := rand.New(rand.NewPCG(11, 12))
+	x, y, _, _ := sets(r, 10000, 50, 50)
+	sx, sy := strata(x), strata(y)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := sx.Estimate(sy); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package iblt
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+)
+
+// Strata estimates the size of the difference between two sets without
+// knowing it in advance (Eppstein et al., "What's the Difference?"). Keys
+// are split into strata by the number of trailing zeros of their hash, so
+// stratum i holds about a 2^-(i+1) sample of the set, each in a small table.
+// Comparing two estimators decodes strata from the sparsest down; the first
+// stratum that fails to decode bounds how much the sampling has to be scaled
+// up.
+type Strata struct {
+	Levels []*Table `json:"levels"`
+}
+
+// Default estimator dimensions: 16 strata cover differences up to about
+// 2^16 times the cells per stratum, far beyond what a gossip round carries.
+const (
+	DefaultStrata     = 16
+	DefaultStrataSize = 24
+)
+
+// NewStrata returns an empty estimator with levels strata of size cells.
+func NewStrata(levels, size int) *Strata {
+	s := &Strata{Levels: make([]*Table, levels)}
+	for i := range s.Levels {
+		s.Levels[i] = New(size)
+	}
+	return s
+}
+
+// Insert adds key to its stratum.
+func (s *Strata) Insert(key uint64) {
+	level := min(bits.TrailingZeros64(mix(key)), len(s.Levels)-1)
+	s.Levels[level].Insert(key)
+}
+
+// Estimate returns the approximate number of keys in exactly one of the
+// two sets. o usually comes from a peer, so an estimator that is missing or
+// shaped differently is an error rather than a panic.
+func (s *Strata) Estimate(o *Strata) (int, error) {
+	if o == nil {
+		return 0, errors.New("iblt: no estimator to compare with")
+	}
+	if len(s.Levels) != len(o.Levels) {
+		return 0, fmt.Errorf("iblt: cannot compare estimators of %d and %d strata", len(s.Levels), len(o.Levels))
+	}
+	if slices.Contains(o.Levels, nil) {
+		return 0, errors.New("iblt: estimator is missing a stratum")
+	}
+	count := 0
+	for i := len(s.Levels) - 1; i >= 0; i-- {
+		diff, err := s.Levels[i].Subtract(o.Levels[i])
+		if err != nil {
+			return 0, err
+		}
+		added, removed, err := diff.Decode()
+		if err != nil {
+			// Strata i+1 and up decoded and hold about 2^-(i+1) of the
+			// difference. So does stratum i, which holds at least the
+			// keys it gave up before getting stuck, and most likely a
+			// third as many keys as cells. Counting it keeps the estimate
+			// from collapsing when the strata above are nearly empty by
+			// chance.
+			floor := max(len(added)+len(removed), diff.Len()/hashCount)
+			return max(count, floor) << (i + 1), nil
+		}
+		count += len(added) + len(removed)
+	}
+	return count, nil
+}
+
+// Clone returns a copy of the estimator.
+func (s *Strata) Clone() *Strata {
+	c := &Strata{Levels: make([]*Table, len(s.Levels))}
+	for i, t := range s.Levels {
+		c.Levels[i] = &Table{cells: append([]cell(nil), t.cells...)}
+	}
+	return c
+}
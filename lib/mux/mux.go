@@ -0,0 +1,297 @@
+// Package mux hosts several workloads in one node process. Each workload
+// runs on a maelstrom.Node of its own, registered with the same handlers as
+// when it runs alone, and the host routes messages between the process's
+// STDIN and STDOUT and the workloads' nodes:
+//
+//   - Requests from clients and other nodes carry namespaced types such as
+//     "kafka.send" or "counter.read"; the host strips the namespace and
+//     hands them to that workload.
+//   - Messages a workload sends to clients and other nodes get their type
+//     namespaced, except errors, which clients must recognize as such.
+//   - Requests to the KV services keep their type, but their key is
+//     namespaced, so workloads sharing lin-kv or seq-kv do not see each
+//     other's data.
+//   - Message IDs are renumbered on the way out so that replies reach the
+//     workload that sent the request.
+//
+// The init message is passed to every workload, and the host acknowledges
+// it once they all have.
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Separator joins a workload namespace and a message type.
+const Separator = "."
+
+// Host multiplexes workloads onto a node process.
+type Host struct {
+	// Stdin and Stdout carry the process's messages. They default to
+	// os.Stdin and os.Stdout, and can be replaced before Run.
+	Stdin  io.Reader
+	Stdout io.Writer
+
+	outMu sync.Mutex // serializes lines written to Stdout
+
+	workloads map[string]*workload
+	order     []string
+
+	mu      sync.Mutex
+	nextID  int
+	pending map[int]pendingReply // by renumbered msg_id
+	inits   int                  // workloads that acknowledged init
+}
+
+type workload struct {
+	name   string
+	node   *maelstrom.Node
+	in     *io.PipeReader // the node's STDIN
+	stdin  *io.PipeWriter // where the host writes to it
+	out    *io.PipeReader // where the host reads what it writes
+	stdout *io.PipeWriter // the node's STDOUT
+}
+
+// pendingReply is where the reply to a request a workload sent goes.
+type pendingReply struct {
+	workload string
+	msgID    int
+}
+
+// New returns a host reading messages from os.Stdin and writing them to
+// os.Stdout.
+func New() *Host {
+	return &Host{
+		Stdin:     os.Stdin,
+		Stdout:    os.Stdout,
+		workloads: make(map[string]*workload),
+		pending:   make(map[int]pendingReply),
+	}
+}
+
+// Workload returns the node for the workload called name, on which to
+// register its handlers. Its STDIN and STDOUT are already connected to the
+// host, so handlers may wrap them, as hlc.Clock.Attach does. It panics if
+// name is already taken or contains the separator.
+func (h *Host) Workload(name string) *maelstrom.Node {
+	if name == "" || strings.Contains(name, Separator) {
+		panic(fmt.Sprintf("invalid workload name %q", name))
+	}
+	if _, ok := h.workloads[name]; ok {
+		panic(fmt.Sprintf("duplicate workload %q", name))
+	}
+	n := maelstrom.NewNode()
+	in, stdin := io.Pipe()
+	out, stdout := io.Pipe()
+	n.Stdin, n.Stdout = in, stdout
+	w := &workload{name: name, node: n, in: in, stdin: stdin, out: out, stdout: stdout}
+	h.workloads[name] = w
+	h.order = append(h.order, name)
+	return n
+}
+
+// Run runs every workload's node and routes messages until STDIN is
+// closed and the workloads have finished handling what they received.
+func (h *Host) Run() error {
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(h.order))
+	)
+	for i, name := range h.order {
+		w := h.workloads[name]
+		routed := make(chan struct{})
+		go func() {
+			defer close(routed)
+			h.routeOut(w)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.node.Run(); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", w.name, err)
+			}
+			// Messages for a workload that stopped early are dropped.
+			w.in.Close()
+			w.stdout.Close()
+			<-routed
+		}()
+	}
+
+	err := h.routeIn()
+	for _, w := range h.workloads {
+		w.stdin.Close()
+	}
+	wg.Wait()
+	return errors.Join(append(errs, err)...)
+}
+
+// routeIn hands each message from STDIN to its workload.
+func (h *Host) routeIn() error {
+	scanner := bufio.NewScanner(h.Stdin)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		msg, body, err := decode(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		typ, _ := body["type"].(string)
+
+		switch inReplyTo := msgID(body["in_reply_to"]); {
+		case typ == "init":
+			for _, name := range h.order {
+				h.deliver(h.workloads[name], msg, body)
+			}
+
+		case inReplyTo != 0:
+			h.mu.Lock()
+			to, ok := h.pending[inReplyTo]
+			delete(h.pending, inReplyTo)
+			h.mu.Unlock()
+			if !ok {
+				log.Printf("mux: ignoring reply to %d with no request", inReplyTo)
+				continue
+			}
+			body["in_reply_to"] = to.msgID
+			if name, t, ok := strings.Cut(typ, Separator); ok && name == to.workload {
+				body["type"] = t
+			}
+			h.deliver(h.workloads[to.workload], msg, body)
+
+		default:
+			name, t, _ := strings.Cut(typ, Separator)
+			w, ok := h.workloads[name]
+			if !ok {
+				h.write(msg.Dest, msg.Src, map[string]any{
+					"type":        "error",
+					"in_reply_to": body["msg_id"],
+					"code":        maelstrom.NotSupported,
+					"text":        fmt.Sprintf("no workload handles %q", typ),
+				})
+				continue
+			}
+			body["type"] = t
+			h.deliver(w, msg, body)
+		}
+	}
+	return scanner.Err()
+}
+
+// deliver writes msg with body to w's STDIN.
+func (h *Host) deliver(w *workload, msg maelstrom.Message, body map[string]any) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("mux: marshal message for %s: %s", w.name, err)
+		return
+	}
+	msg.Body = buf
+	line, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("mux: marshal message for %s: %s", w.name, err)
+		return
+	}
+	w.stdin.Write(append(line, '\n'))
+}
+
+// routeOut namespaces each message w writes and passes it on to STDOUT.
+func (h *Host) routeOut(w *workload) {
+	scanner := bufio.NewScanner(w.out)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		msg, body, err := decode(scanner.Bytes())
+		if err != nil {
+			log.Printf("mux: %s wrote %s", w.name, err)
+			continue
+		}
+		typ, _ := body["type"].(string)
+
+		if typ == "init_ok" {
+			h.mu.Lock()
+			h.inits++
+			done := h.inits == len(h.workloads)
+			h.mu.Unlock()
+			if done {
+				h.write(msg.Src, msg.Dest, body)
+			}
+			continue
+		}
+
+		if id := msgID(body["msg_id"]); id != 0 {
+			h.mu.Lock()
+			h.nextID++
+			body["msg_id"] = h.nextID
+			h.pending[h.nextID] = pendingReply{workload: w.name, msgID: id}
+			h.mu.Unlock()
+		}
+		switch {
+		case isService(msg.Dest):
+			if key, ok := body["key"].(string); ok {
+				body["key"] = w.name + "/" + key
+			}
+		case typ != "error":
+			body["type"] = w.name + Separator + typ
+		}
+		h.write(msg.Src, msg.Dest, body)
+	}
+}
+
+// write sends body from src to dest on STDOUT.
+func (h *Host) write(src, dest string, body map[string]any) {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		log.Printf("mux: marshal message to %s: %s", dest, err)
+		return
+	}
+	line, err := json.Marshal(maelstrom.Message{Src: src, Dest: dest, Body: buf})
+	if err != nil {
+		log.Printf("mux: marshal message to %s: %s", dest, err)
+		return
+	}
+	h.outMu.Lock()
+	defer h.outMu.Unlock()
+	h.Stdout.Write(append(line, '\n'))
+}
+
+// decode parses a message and its body. Numbers in the body are kept as
+// json.Number, so that 64-bit integers pass through exactly.
+func decode(line []byte) (maelstrom.Message, map[string]any, error) {
+	var msg maelstrom.Message
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, nil, fmt.Errorf("invalid message: %w", err)
+	}
+	var body map[string]any
+	dec := json.NewDecoder(bytes.NewReader(msg.Body))
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return msg, nil, fmt.Errorf("invalid message body: %w", err)
+	}
+	return msg, body, nil
+}
+
+// msgID returns a msg_id or in_reply_to field as an int, or 0 if unset.
+func msgID(v any) int {
+	switch v := v.(type) {
+	case float64:
+		return int(v)
+	case json.Number:
+		id, _ := v.Int64()
+		return int(id)
+	}
+	return 0
+}
+
+// isService reports whether id names one of Maelstrom's services, such as
+// "lin-kv", rather than a node ("n1") or a client ("c1").
+func isService(id string) bool {
+	return strings.Contains(id, "-")
+}
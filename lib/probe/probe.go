@@ -0,0 +1,162 @@
+// Package probe measures round-trip times between nodes. Every node pings
+// each of its peers periodically and keeps a smoothed RTT per peer. Ping
+// replies carry the responder's own measurements, so every node also learns
+// the rest of the cluster's RTT matrix without extra messages.
+package probe
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+)
+
+// Prober pings peers and tracks round-trip times.
+type Prober struct {
+	node *maelstrom.Node
+
+	// Interval is the time between rounds of pings.
+	Interval time.Duration
+
+	// Timeout bounds each ping; peers that do not answer keep their last
+	// measurement.
+	Timeout time.Duration
+
+	mu   sync.Mutex
+	rows map[string]map[string]float64 // from -> to -> smoothed RTT in ms
+}
+
+// New returns a prober for n and registers its "ping" handler. Call Start
+// once the node has been initialized.
+func New(n *maelstrom.Node) *Prober {
+	p := &Prober{
+		node:     n,
+		Interval: time.Second,
+		Timeout:  time.Second,
+		rows:     make(map[string]map[string]float64),
+	}
+	n.Handle("ping", func(msg maelstrom.Message) error {
+		return n.Reply(msg, map[string]any{"type": "ping_ok", "rtts": p.row(n.ID())})
+	})
+	return p
+}
+
+// Start begins probing every other node in the background.
+func (p *Prober) Start() {
+	go func() {
+		for {
+			var wg sync.WaitGroup
+			for _, peer := range p.node.NodeIDs() {
+				if peer == p.node.ID() {
+					continue
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					p.ping(peer)
+				}()
+			}
+			wg.Wait()
+			time.Sleep(p.Interval)
+		}
+	}()
+}
+
+// ping measures one round trip to peer and records the peer's own row.
+func (p *Prober) ping(peer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.Timeout)
+	defer cancel()
+	start := time.Now()
+	msg, err := rpc.Call(ctx, p.node, peer, map[string]any{"type": "ping"})
+	if err != nil {
+		return
+	}
+	sample := float64(time.Since(start).Microseconds()) / 1000
+
+	var body struct {
+		RTTs map[string]float64 `json:"rtts"`
+	}
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	own := p.rows[p.node.ID()]
+	if own == nil {
+		own = make(map[string]float64)
+		p.rows[p.node.ID()] = own
+	}
+	// Smooth like TCP's SRTT so a single slow ping does not reorder peers.
+	if prev, ok := own[peer]; ok {
+		own[peer] = prev + (sample-prev)/8
+	} else {
+		own[peer] = sample
+	}
+	if body.RTTs != nil {
+		p.rows[peer] = body.RTTs
+	}
+}
+
+func (p *Prober) row(from string) map[string]float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	row := make(map[string]float64, len(p.rows[from]))
+	for to, rtt := range p.rows[from] {
+		row[to] = rtt
+	}
+	return row
+}
+
+// RTT returns the smoothed round-trip time to peer, if it has been measured.
+func (p *Prober) RTT(peer string) (time.Duration, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ms, ok := p.rows[p.node.ID()][peer]
+	return time.Duration(ms * float64(time.Millisecond)), ok
+}
+
+// Matrix returns the RTTs in milliseconds between every pair of nodes known
+// so far, keyed by the pinging node and then the pinged node. Rows other
+// than our own are as fresh as the last ping to that node.
+func (p *Prober) Matrix() map[string]map[string]float64 {
+	matrix := make(map[string]map[string]float64)
+	p.mu.Lock()
+	from := make([]string, 0, len(p.rows))
+	for id := range p.rows {
+		from = append(from, id)
+	}
+	p.mu.Unlock()
+	for _, id := range from {
+		matrix[id] = p.row(id)
+	}
+	return matrix
+}
+
+// Closest orders candidates by measured RTT from this node, nearest first.
+// Unmeasured candidates sort last.
+func (p *Prober) Closest(candidates []string) []string {
+	p.mu.Lock()
+	own := p.rows[p.node.ID()]
+	rtt := func(id string) float64 {
+		if id == p.node.ID() {
+			return 0
+		}
+		if ms, ok := own[id]; ok {
+			return ms
+		}
+		return math.Inf(1)
+	}
+	sorted := slices.Clone(candidates)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return cmp.Compare(rtt(a), rtt(b))
+	})
+	p.mu.Unlock()
+	return sorted
+}
@@ -0,0 +1,824 @@
+// Package raft implements the Raft consensus algorithm over Maelstrom node
+// messages: leader election, log replication, log compaction with
+// snapshots and single-server membership changes. Commands are applied to a
+// caller-supplied StateMachine in log order on every member.
+//
+// A member's term, vote and log are saved to the configured Storage before
+// it answers any message that depends on them, and loaded again by Start,
+// so members can be restarted. Without a Storage they are kept in memory
+// only, and a restarted member could vote twice in a term or lose entries
+// it acknowledged: members must then never be restarted.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// ErrNotLeader is returned when a proposal is made on a node that is not the
+// leader. The error is a *NotLeaderError naming the leader, if known.
+var ErrNotLeader = errors.New("raft: not the leader")
+
+// ErrLost is returned when a proposal was overwritten by a new leader before
+// it could be committed. The command was not applied.
+var ErrLost = errors.New("raft: proposal lost to a leadership change")
+
+// ErrConfigChangeInProgress is returned when a membership change is requested
+// while a previous one has not committed yet.
+var ErrConfigChangeInProgress = errors.New("raft: membership change already in progress")
+
+// NotLeaderError is returned by Propose on followers and candidates.
+type NotLeaderError struct {
+	Leader string // empty if unknown
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "raft: not the leader; leader unknown"
+	}
+	return "raft: not the leader; leader is " + e.Leader
+}
+
+func (e *NotLeaderError) Is(target error) bool { return target == ErrNotLeader }
+
+// StateMachine is the replicated application state.
+type StateMachine interface {
+	// Apply applies a committed command and returns its result, which is
+	// handed back to the proposer on the leader. It is called with the Raft
+	// lock held and must not call back into Raft.
+	Apply(cmd json.RawMessage) any
+
+	// Snapshot serializes the state for log compaction.
+	Snapshot() ([]byte, error)
+
+	// Restore replaces the state with a snapshot.
+	Restore(data []byte) error
+}
+
+// Config tunes timing and compaction.
+type Config struct {
+	// ElectionTimeout is the minimum time without hearing from a leader
+	// before a follower starts an election. Each timeout is randomized
+	// between this and twice this value.
+	ElectionTimeout time.Duration
+
+	// HeartbeatInterval is how often the leader replicates to followers.
+	HeartbeatInterval time.Duration
+
+	// SnapshotThreshold is the number of applied entries after which the
+	// log is compacted into a snapshot. Zero disables compaction.
+	SnapshotThreshold int
+
+	// MaxBatch caps the entries sent in one append message.
+	MaxBatch int
+
+	// Storage persists the member's state across restarts. Nil keeps it
+	// in memory only.
+	Storage Storage
+}
+
+// DefaultConfig suits Maelstrom's default network latencies.
+var DefaultConfig = Config{
+	ElectionTimeout:   500 * time.Millisecond,
+	HeartbeatInterval: 100 * time.Millisecond,
+	SnapshotThreshold: 1000,
+	MaxBatch:          100,
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+// Entry is a log entry. Entries carry either a command, a new cluster
+// configuration or nothing (the no-op a new leader appends).
+type Entry struct {
+	Term    int             `json:"term"`
+	Command json.RawMessage `json:"command,omitempty"`
+	Members []string        `json:"members,omitempty"`
+}
+
+type result struct {
+	value any
+	err   error
+}
+
+type waiter struct {
+	term int
+	ch   chan result
+}
+
+// Raft is one member of a Raft group.
+type Raft struct {
+	node *maelstrom.Node
+	sm   StateMachine
+	cfg  Config
+
+	mu       sync.Mutex
+	role     role
+	term     int
+	votedFor string
+	leader   string
+	votes    map[string]bool
+	deadline time.Time // election deadline
+	lastBeat time.Time // last heartbeat sent, on the leader
+
+	// log[0] is a sentinel for the last entry covered by the snapshot, so the
+	// entry with absolute index i is log[i-snapIndex].
+	log         []Entry
+	snapIndex   int
+	snapshot    []byte
+	snapMembers []string
+	commitIndex int
+	lastApplied int
+
+	nextIndex  map[string]int
+	matchIndex map[string]int
+	waiters    map[int]waiter
+}
+
+// New creates a Raft member on n and registers its message handlers. Call
+// Start once the node has been initialized.
+func New(n *maelstrom.Node, sm StateMachine, cfg Config) *Raft {
+	if cfg.Storage == nil {
+		cfg.Storage = memoryStorage{}
+	}
+	r := &Raft{
+		node:       n,
+		sm:         sm,
+		cfg:        cfg,
+		log:        []Entry{{}},
+		nextIndex:  make(map[string]int),
+		matchIndex: make(map[string]int),
+		waiters:    make(map[int]waiter),
+	}
+	n.Handle("raft_request_vote", r.handleRequestVote)
+	n.Handle("raft_append_entries", r.handleAppendEntries)
+	n.Handle("raft_install_snapshot", r.handleInstallSnapshot)
+	return r
+}
+
+// Start loads any state saved before a restart and begins participating.
+// members is the initial configuration, typically n.NodeIDs(); every node
+// must start with the same members.
+func (r *Raft) Start(members []string) error {
+	st, err := r.cfg.Storage.Load()
+	if err != nil {
+		return fmt.Errorf("raft: load state: %w", err)
+	}
+
+	r.mu.Lock()
+	r.term, r.votedFor = st.Term, st.VotedFor
+	r.snapMembers = slices.Clone(members)
+	if snap := st.Snapshot; snap.Index > 0 {
+		if err := r.sm.Restore(snap.Data); err != nil {
+			r.mu.Unlock()
+			return fmt.Errorf("raft: restore snapshot: %w", err)
+		}
+		r.snapIndex, r.snapshot, r.snapMembers = snap.Index, snap.Data, snap.Members
+		r.commitIndex, r.lastApplied = snap.Index, snap.Index
+		r.log[0].Term = snap.Term
+	}
+	r.log = append(r.log, st.Entries...)
+	r.resetDeadline()
+	r.mu.Unlock()
+
+	go func() {
+		for range time.Tick(r.cfg.HeartbeatInterval / 5) {
+			r.tick()
+		}
+	}()
+	return nil
+}
+
+// Leader returns the current leader, if known.
+func (r *Raft) Leader() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader
+}
+
+// IsLeader reports whether this node currently believes it is the leader.
+func (r *Raft) IsLeader() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.role == leader
+}
+
+// Members returns the latest cluster configuration in the log.
+func (r *Raft) Members() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.membersAt(r.lastIndex()))
+}
+
+// Propose appends cmd to the log and waits until it has been committed and
+// applied, returning the state machine's result.
+func (r *Raft) Propose(ctx context.Context, cmd any) (any, error) {
+	buf, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return r.append(ctx, Entry{Command: buf})
+}
+
+// AddServer adds a node to the cluster configuration.
+func (r *Raft) AddServer(ctx context.Context, id string) error {
+	return r.changeMembers(ctx, func(members []string) []string {
+		if slices.Contains(members, id) {
+			return members
+		}
+		return append(members, id)
+	})
+}
+
+// RemoveServer removes a node from the cluster configuration. A leader that
+// removes itself steps down once the change commits.
+func (r *Raft) RemoveServer(ctx context.Context, id string) error {
+	return r.changeMembers(ctx, func(members []string) []string {
+		return slices.DeleteFunc(members, func(m string) bool { return m == id })
+	})
+}
+
+func (r *Raft) changeMembers(ctx context.Context, change func([]string) []string) error {
+	r.mu.Lock()
+	for i := r.commitIndex + 1; i <= r.lastIndex(); i++ {
+		if r.entry(i).Members != nil {
+			r.mu.Unlock()
+			return ErrConfigChangeInProgress
+		}
+	}
+	members := change(slices.Clone(r.membersAt(r.lastIndex())))
+	r.mu.Unlock()
+
+	_, err := r.append(ctx, Entry{Members: members})
+	return err
+}
+
+func (r *Raft) append(ctx context.Context, e Entry) (any, error) {
+	r.mu.Lock()
+	if r.role != leader {
+		r.mu.Unlock()
+		return nil, &NotLeaderError{Leader: r.leader}
+	}
+	e.Term = r.term
+	r.log = append(r.log, e)
+	index := r.lastIndex()
+	if err := r.cfg.Storage.SaveEntries(index, []Entry{e}); err != nil {
+		r.truncate(index)
+		r.mu.Unlock()
+		return nil, fmt.Errorf("raft: save entry: %w", err)
+	}
+	ch := make(chan result, 1)
+	r.waiters[index] = waiter{term: r.term, ch: ch}
+	r.maybeCommit() // a single-member cluster commits immediately
+	r.broadcastAppend()
+	r.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		r.mu.Lock()
+		delete(r.waiters, index)
+		r.mu.Unlock()
+		return nil, ctx.Err()
+	case res := <-ch:
+		return res.value, res.err
+	}
+}
+
+// tick drives elections and heartbeats.
+func (r *Raft) tick() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case r.role == leader:
+		if now.Sub(r.lastBeat) >= r.cfg.HeartbeatInterval {
+			r.broadcastAppend()
+		}
+	case now.After(r.deadline):
+		r.startElection()
+	}
+}
+
+func (r *Raft) resetDeadline() {
+	timeout := r.cfg.ElectionTimeout + time.Duration(rand.Int63n(int64(r.cfg.ElectionTimeout)))
+	r.deadline = time.Now().Add(timeout)
+}
+
+func (r *Raft) lastIndex() int { return r.snapIndex + len(r.log) - 1 }
+
+func (r *Raft) entry(index int) Entry { return r.log[index-r.snapIndex] }
+
+func (r *Raft) termAt(index int) int { return r.log[index-r.snapIndex].Term }
+
+// membersAt returns the configuration in effect at index: the latest
+// configuration entry at or before it, or the snapshot's.
+func (r *Raft) membersAt(index int) []string {
+	for i := min(index, r.lastIndex()); i > r.snapIndex; i-- {
+		if m := r.entry(i).Members; m != nil {
+			return m
+		}
+	}
+	return r.snapMembers
+}
+
+func (r *Raft) members() []string { return r.membersAt(r.lastIndex()) }
+
+func (r *Raft) quorum(votes func(id string) bool) bool {
+	members := r.members()
+	n := 0
+	for _, m := range members {
+		if votes(m) {
+			n++
+		}
+	}
+	return n > len(members)/2
+}
+
+// stepDown moves to a newer term as a follower.
+func (r *Raft) stepDown(term int) {
+	if term > r.term {
+		r.term = term
+		r.votedFor = ""
+		r.saveState()
+	}
+	if r.role == leader {
+		log.Printf("raft: stepping down in term %d", r.term)
+	}
+	r.role = follower
+	r.resetDeadline()
+}
+
+func (r *Raft) startElection() {
+	r.resetDeadline()
+	if !slices.Contains(r.members(), r.node.ID()) {
+		return // non-members never campaign
+	}
+
+	r.role = candidate
+	r.term++
+	r.votedFor = r.node.ID()
+	r.leader = ""
+	r.votes = map[string]bool{r.node.ID(): true}
+	term := r.term
+	if err := r.saveState(); err != nil {
+		return // campaign again at the next deadline
+	}
+
+	if r.quorum(func(id string) bool { return r.votes[id] }) {
+		r.becomeLeader()
+		return
+	}
+
+	req := requestVote{
+		Type:         "raft_request_vote",
+		Term:         term,
+		Candidate:    r.node.ID(),
+		LastLogIndex: r.lastIndex(),
+		LastLogTerm:  r.termAt(r.lastIndex()),
+	}
+	for _, peer := range r.members() {
+		if peer == r.node.ID() {
+			continue
+		}
+		r.node.RPC(peer, req, func(msg maelstrom.Message) error {
+			var resp requestVoteOK
+			if err := json.Unmarshal(msg.Body, &resp); err != nil {
+				return err
+			}
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if resp.Term > r.term {
+				r.stepDown(resp.Term)
+				return nil
+			}
+			if r.role != candidate || r.term != term || !resp.Granted {
+				return nil
+			}
+			r.votes[msg.Src] = true
+			if r.quorum(func(id string) bool { return r.votes[id] }) {
+				r.becomeLeader()
+			}
+			return nil
+		})
+	}
+}
+
+func (r *Raft) becomeLeader() {
+	log.Printf("raft: %s is leader for term %d", r.node.ID(), r.term)
+	r.role = leader
+	r.leader = r.node.ID()
+	for _, peer := range r.members() {
+		r.nextIndex[peer] = r.lastIndex() + 1
+		r.matchIndex[peer] = 0
+	}
+	// Committing an entry from the new term also commits everything before it.
+	r.log = append(r.log, Entry{Term: r.term})
+	if err := r.cfg.Storage.SaveEntries(r.lastIndex(), r.log[len(r.log)-1:]); err != nil {
+		log.Printf("raft: save entry: %s", err)
+		r.truncate(r.lastIndex())
+		r.stepDown(r.term)
+		return
+	}
+	r.maybeCommit()
+	r.broadcastAppend()
+}
+
+func (r *Raft) handleRequestVote(msg maelstrom.Message) error {
+	var req requestVote
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if req.Term > r.term {
+		r.stepDown(req.Term)
+	}
+
+	last := r.lastIndex()
+	upToDate := req.LastLogTerm > r.termAt(last) ||
+		(req.LastLogTerm == r.termAt(last) && req.LastLogIndex >= last)
+	granted := req.Term == r.term && upToDate && (r.votedFor == "" || r.votedFor == req.Candidate)
+	if granted {
+		r.votedFor = req.Candidate
+		if err := r.saveState(); err != nil {
+			return err
+		}
+		r.resetDeadline()
+	}
+	return r.node.Reply(msg, requestVoteOK{Type: "raft_request_vote_ok", Term: r.term, Granted: granted})
+}
+
+// broadcastAppend replicates to every other member.
+func (r *Raft) broadcastAppend() {
+	r.lastBeat = time.Now()
+	for _, peer := range r.members() {
+		if peer != r.node.ID() {
+			r.sendAppend(peer)
+		}
+	}
+}
+
+func (r *Raft) sendAppend(peer string) {
+	next, ok := r.nextIndex[peer]
+	if !ok {
+		next = r.lastIndex() + 1 // a member added since we became leader
+		r.nextIndex[peer] = next
+	}
+	if next <= r.snapIndex {
+		r.sendSnapshot(peer)
+		return
+	}
+
+	prev := next - 1
+	end := min(r.lastIndex(), prev+r.cfg.MaxBatch)
+	req := appendEntries{
+		Type:         "raft_append_entries",
+		Term:         r.term,
+		Leader:       r.node.ID(),
+		PrevLogIndex: prev,
+		PrevLogTerm:  r.termAt(prev),
+		Entries:      slices.Clone(r.log[next-r.snapIndex : end-r.snapIndex+1]),
+		LeaderCommit: r.commitIndex,
+	}
+	term := r.term
+	r.node.RPC(peer, req, func(msg maelstrom.Message) error {
+		var resp appendEntriesOK
+		if err := json.Unmarshal(msg.Body, &resp); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if resp.Term > r.term {
+			r.stepDown(resp.Term)
+			return nil
+		}
+		if r.role != leader || r.term != term {
+			return nil
+		}
+		if resp.Success {
+			r.matchIndex[peer] = max(r.matchIndex[peer], resp.MatchIndex)
+			r.nextIndex[peer] = r.matchIndex[peer] + 1
+			r.maybeCommit()
+			if r.nextIndex[peer] <= r.lastIndex() {
+				r.sendAppend(peer) // more to send than fit in one batch
+			}
+			return nil
+		}
+		r.nextIndex[peer] = max(1, min(resp.ConflictIndex, r.nextIndex[peer]-1))
+		r.sendAppend(peer)
+		return nil
+	})
+}
+
+func (r *Raft) handleAppendEntries(msg maelstrom.Message) error {
+	var req appendEntries
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply := func(success bool, match, conflict int) error {
+		return r.node.Reply(msg, appendEntriesOK{
+			Type:          "raft_append_entries_ok",
+			Term:          r.term,
+			Success:       success,
+			MatchIndex:    match,
+			ConflictIndex: conflict,
+		})
+	}
+
+	if req.Term < r.term {
+		return reply(false, 0, 0)
+	}
+	if req.Term > r.term || r.role != follower {
+		r.stepDown(req.Term)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+
+	// Entries already covered by our snapshot are committed; skip them.
+	if req.PrevLogIndex < r.snapIndex {
+		skip := r.snapIndex - req.PrevLogIndex
+		if skip >= len(req.Entries) {
+			return reply(true, r.snapIndex, 0)
+		}
+		req.Entries = req.Entries[skip:]
+		req.PrevLogIndex = r.snapIndex
+		req.PrevLogTerm = r.log[0].Term
+	}
+
+	if req.PrevLogIndex > r.lastIndex() {
+		return reply(false, 0, r.lastIndex()+1)
+	}
+	if t := r.termAt(req.PrevLogIndex); t != req.PrevLogTerm {
+		// Skip back over the whole conflicting term in one round trip.
+		conflict := req.PrevLogIndex
+		for conflict > r.snapIndex+1 && r.termAt(conflict-1) == t {
+			conflict--
+		}
+		return reply(false, 0, conflict)
+	}
+
+	first := 0 // first index written, if any
+	for i, e := range req.Entries {
+		index := req.PrevLogIndex + 1 + i
+		if index <= r.lastIndex() {
+			if r.termAt(index) == e.Term {
+				continue
+			}
+			r.truncate(index)
+		}
+		if first == 0 {
+			first = index
+		}
+		r.log = append(r.log, e)
+	}
+	if first > 0 {
+		if err := r.cfg.Storage.SaveEntries(first, r.log[first-r.snapIndex:]); err != nil {
+			return err
+		}
+	}
+
+	// A stale append, delayed or retried, may match less of the log than
+	// is already known to be committed; the commit index never goes back.
+	match := req.PrevLogIndex + len(req.Entries)
+	if req.LeaderCommit > r.commitIndex {
+		r.commitIndex = max(r.commitIndex, min(req.LeaderCommit, match))
+		r.apply()
+	}
+	return reply(true, match, 0)
+}
+
+// saveState persists the term and vote, logging failures for callers that
+// cannot report them.
+func (r *Raft) saveState() error {
+	err := r.cfg.Storage.SaveState(r.term, r.votedFor)
+	if err != nil {
+		log.Printf("raft: save state: %s", err)
+	}
+	return err
+}
+
+// truncate drops the entries from index onwards, failing their waiters.
+func (r *Raft) truncate(index int) {
+	for i := index; i <= r.lastIndex(); i++ {
+		if w, ok := r.waiters[i]; ok {
+			w.ch <- result{err: ErrLost}
+			delete(r.waiters, i)
+		}
+	}
+	r.log = r.log[:index-r.snapIndex]
+}
+
+// maybeCommit advances the commit index on the leader to the highest entry
+// of the current term stored on a quorum.
+func (r *Raft) maybeCommit() {
+	r.matchIndex[r.node.ID()] = r.lastIndex()
+	for n := r.lastIndex(); n > r.commitIndex; n-- {
+		if r.termAt(n) != r.term {
+			break
+		}
+		if r.quorum(func(id string) bool { return r.matchIndex[id] >= n }) {
+			r.commitIndex = n
+			r.apply()
+			break
+		}
+	}
+
+	// A leader that committed its own removal hands over leadership.
+	if r.role == leader && !slices.Contains(r.membersAt(r.commitIndex), r.node.ID()) {
+		r.stepDown(r.term)
+		r.leader = ""
+	}
+}
+
+// apply applies committed entries to the state machine and resolves the
+// proposals waiting on them.
+func (r *Raft) apply() {
+	for r.lastApplied < r.commitIndex {
+		r.lastApplied++
+		e := r.entry(r.lastApplied)
+
+		var res result
+		if e.Command != nil {
+			res.value = r.sm.Apply(e.Command)
+		}
+		if w, ok := r.waiters[r.lastApplied]; ok {
+			if w.term != e.Term {
+				res = result{err: ErrLost}
+			}
+			w.ch <- res
+			delete(r.waiters, r.lastApplied)
+		}
+	}
+
+	if r.cfg.SnapshotThreshold > 0 && r.lastApplied-r.snapIndex >= r.cfg.SnapshotThreshold {
+		if err := r.compact(); err != nil {
+			log.Printf("raft: snapshot: %s", err)
+		}
+	}
+}
+
+// compact replaces the applied prefix of the log with a snapshot.
+func (r *Raft) compact() error {
+	data, err := r.sm.Snapshot()
+	if err != nil {
+		return err
+	}
+	members := slices.Clone(r.membersAt(r.lastApplied))
+	term := r.termAt(r.lastApplied)
+	snap := Snapshot{Index: r.lastApplied, Term: term, Members: members, Data: data}
+	if err := r.cfg.Storage.SaveSnapshot(snap); err != nil {
+		return err
+	}
+	r.log = append([]Entry{{Term: term}}, r.log[r.lastApplied-r.snapIndex+1:]...)
+	r.snapIndex = r.lastApplied
+	r.snapshot = data
+	r.snapMembers = members
+	return nil
+}
+
+func (r *Raft) sendSnapshot(peer string) {
+	req := installSnapshot{
+		Type:      "raft_install_snapshot",
+		Term:      r.term,
+		Leader:    r.node.ID(),
+		LastIndex: r.snapIndex,
+		LastTerm:  r.log[0].Term,
+		Members:   r.snapMembers,
+		Data:      r.snapshot,
+	}
+	term := r.term
+	r.node.RPC(peer, req, func(msg maelstrom.Message) error {
+		var resp installSnapshotOK
+		if err := json.Unmarshal(msg.Body, &resp); err != nil {
+			return err
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if resp.Term > r.term {
+			r.stepDown(resp.Term)
+			return nil
+		}
+		if r.role != leader || r.term != term {
+			return nil
+		}
+		r.matchIndex[peer] = max(r.matchIndex[peer], resp.MatchIndex)
+		r.nextIndex[peer] = r.matchIndex[peer] + 1
+		return nil
+	})
+}
+
+func (r *Raft) handleInstallSnapshot(msg maelstrom.Message) error {
+	var req installSnapshot
+	if err := json.Unmarshal(msg.Body, &req); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reply := func(match int) error {
+		return r.node.Reply(msg, installSnapshotOK{Type: "raft_install_snapshot_ok", Term: r.term, MatchIndex: match})
+	}
+	if req.Term < r.term {
+		return reply(0)
+	}
+	if req.Term > r.term || r.role != follower {
+		r.stepDown(req.Term)
+	}
+	r.leader = req.Leader
+	r.resetDeadline()
+
+	if req.LastIndex <= r.commitIndex {
+		return reply(r.commitIndex) // we already have everything it covers
+	}
+	snap := Snapshot{Index: req.LastIndex, Term: req.LastTerm, Members: req.Members, Data: req.Data}
+	if err := r.cfg.Storage.SaveSnapshot(snap); err != nil {
+		return err
+	}
+	if err := r.sm.Restore(req.Data); err != nil {
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+
+	// Keep any log suffix that follows the snapshot, otherwise start afresh.
+	if req.LastIndex <= r.lastIndex() && r.termAt(req.LastIndex) == req.LastTerm {
+		r.log = append([]Entry{{Term: req.LastTerm}}, r.log[req.LastIndex-r.snapIndex+1:]...)
+	} else {
+		r.truncate(r.snapIndex + 1)
+		r.log = []Entry{{Term: req.LastTerm}}
+		if err := r.cfg.Storage.SaveEntries(req.LastIndex+1, nil); err != nil {
+			return err
+		}
+	}
+	r.snapIndex = req.LastIndex
+	r.snapshot = req.Data
+	r.snapMembers = req.Members
+	r.commitIndex = req.LastIndex
+	r.lastApplied = req.LastIndex
+	return reply(req.LastIndex)
+}
+
+type requestVote struct {
+	Type         string `json:"type"`
+	Term         int    `json:"term"`
+	Candidate    string `json:"candidate"`
+	LastLogIndex int    `json:"last_log_index"`
+	LastLogTerm  int    `json:"last_log_term"`
+}
+
+type requestVoteOK struct {
+	Type    string `json:"type"`
+	Term    int    `json:"term"`
+	Granted bool   `json:"granted"`
+}
+
+type appendEntries struct {
+	Type         string  `json:"type"`
+	Term         int     `json:"term"`
+	Leader       string  `json:"leader"`
+	PrevLogIndex int     `json:"prev_log_index"`
+	PrevLogTerm  int     `json:"prev_log_term"`
+	Entries      []Entry `json:"entries"`
+	LeaderCommit int     `json:"leader_commit"`
+}
+
+type appendEntriesOK struct {
+	Type          string `json:"type"`
+	Term          int    `json:"term"`
+	Success       bool   `json:"success"`
+	MatchIndex    int    `json:"match_index"`
+	ConflictIndex int    `json:"conflict_index"`
+}
+
+type installSnapshot struct {
+	Type      string   `json:"type"`
+	Term      int      `json:"term"`
+	Leader    string   `json:"leader"`
+	LastIndex int      `json:"last_index"`
+	LastTerm  int      `json:"last_term"`
+	Members   []string `json:"members"`
+	Data      []byte   `json:"data"`
+}
+
+type installSnapshotOK struct {
+	Type       string `json:"type"`
+	Term       int    `json:"term"`
+	MatchIndex int    `json:"match_index"`
+}
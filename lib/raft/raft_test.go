@@ -0,0 +1,486 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// testConfig is DefaultConfig sped up for the harness, which adds no latency
+// unless asked to.
+var testConfig = Config{
+	ElectionTimeout:   150 * time.Millisecond,
+	HeartbeatInterval: 30 * time.Millisecond,
+	SnapshotThreshold: 20,
+	MaxBatch:          8,
+}
+
+// valueLog is a state machine that records the values applied to it.
+type valueLog struct {
+	values   []int
+	restores int // snapshots restored
+}
+
+func (l *valueLog) Apply(cmd json.RawMessage) any {
+	var v int
+	json.Unmarshal(cmd, &v)
+	l.values = append(l.values, v)
+	return len(l.values)
+}
+
+func (l *valueLog) Snapshot() ([]byte, error) { return json.Marshal(l.values) }
+
+func (l *valueLog) Restore(data []byte) error {
+	l.restores++
+	return json.Unmarshal(data, &l.values)
+}
+
+// group runs a Raft member on every node of an in-process cluster.
+type group struct {
+	t       *testing.T
+	cluster *harness.Cluster
+
+	mu      sync.Mutex
+	members []*Raft // every member started, latest last
+	logs    map[*Raft]*valueLog
+}
+
+// startGroup starts nodes members on n.NodeIDs(), persisting their state in
+// files if persist is set.
+func startGroup(t *testing.T, nodes int, cfg Config, persist bool) *group {
+	return startGroupWith(t, nodes, cfg, persist, nil)
+}
+
+// startGroupWith is startGroup with the initial members chosen by members,
+// given the node's ID and the cluster's; nil uses the whole cluster.
+func startGroupWith(t *testing.T, nodes int, cfg Config, persist bool, members func(id string, ids []string) []string) *group {
+	t.Helper()
+	g := &group{t: t, logs: make(map[*Raft]*valueLog)}
+	dir := t.TempDir()
+	setup := func(n *maelstrom.Node) {
+		cfg := cfg
+		if persist {
+			cfg.Storage = NewFileStorage(n, dir)
+		}
+		l := &valueLog{}
+		r := New(n, l, cfg)
+		g.mu.Lock()
+		g.members = append(g.members, r)
+		g.logs[r] = l
+		g.mu.Unlock()
+
+		n.Handle("init", func(maelstrom.Message) error {
+			ids := n.NodeIDs()
+			if members != nil {
+				ids = members(n.ID(), ids)
+			}
+			return r.Start(ids)
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	g.cluster = c
+	return g
+}
+
+// member returns the latest member started on node id.
+func (g *group) member(id string) *Raft {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, r := range slices.Backward(g.members) {
+		if r.node.ID() == id {
+			return r
+		}
+	}
+	g.t.Fatalf("no member on %s", id)
+	return nil
+}
+
+// values returns the values node id has applied so far.
+func (g *group) values(id string) []int {
+	r := g.member(id)
+	g.mu.Lock()
+	l := g.logs[r]
+	g.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(l.values)
+}
+
+// waitFor polls cond until it holds or the deadline passes.
+func (g *group) waitFor(what string, cond func() bool) {
+	g.t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			g.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// leader waits for exactly one of ids to lead, with all of ids agreeing,
+// and returns it.
+func (g *group) leader(ids []string) string {
+	g.t.Helper()
+	var found string
+	g.waitFor("a leader", func() bool {
+		found = ""
+		for _, id := range ids {
+			if g.member(id).IsLeader() {
+				if found != "" {
+					return false
+				}
+				found = id
+			}
+		}
+		if found == "" {
+			return false
+		}
+		for _, id := range ids {
+			if g.member(id).Leader() != found {
+				return false
+			}
+		}
+		return true
+	})
+	return found
+}
+
+// propose proposes values on the leader among ids, retrying when leadership
+// moves.
+func (g *group) propose(ids []string, values ...int) {
+	g.t.Helper()
+	for _, v := range values {
+		for attempt := 0; ; attempt++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err := g.member(g.leader(ids)).Propose(ctx, v)
+			cancel()
+			if err == nil {
+				break
+			}
+			if attempt == 10 {
+				g.t.Fatalf("propose %d: %s", v, err)
+			}
+		}
+	}
+}
+
+// converged waits for every node in ids to have applied exactly want.
+func (g *group) converged(ids []string, want []int) {
+	g.t.Helper()
+	g.waitFor(fmt.Sprintf("%v applied everywhere", want), func() bool {
+		for _, id := range ids {
+			if !slices.Equal(g.values(id), want) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func TestElection(t *testing.T) {
+	g := startGroup(t, 3, testConfig, false)
+	ids := g.cluster.NodeIDs()
+	first := g.leader(ids)
+
+	// The survivors elect a new leader once the first one dies.
+	if err := g.cluster.Kill(first); err != nil {
+		t.Fatal(err)
+	}
+	alive := g.cluster.Alive()
+	second := g.leader(alive)
+	if second == first {
+		t.Fatalf("%s still leads after being killed", first)
+	}
+
+	g.propose(alive, 1, 2, 3)
+	g.converged(alive, []int{1, 2, 3})
+}
+
+func TestLogRepairAfterPartition(t *testing.T) {
+	g := startGroup(t, 5, testConfig, false)
+	ids := g.cluster.NodeIDs()
+	g.propose(ids, 1, 2)
+	g.converged(ids, []int{1, 2})
+
+	// Cut the leader off with one follower. It keeps accepting proposals,
+	// which can never commit, while the majority elects a new leader and
+	// commits entries of its own at the same indexes.
+	old := g.leader(ids)
+	majority := slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return id == old })
+	minority := []string{old, majority[0]}
+	majority = majority[1:]
+	g.cluster.Partition(minority, majority)
+
+	for _, v := range []int{-1, -2, -3} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if _, err := g.member(old).Propose(ctx, v); err == nil {
+			t.Fatalf("minority leader committed %d", v)
+		}
+		cancel()
+	}
+	g.propose(majority, 3, 4, 5, 6)
+
+	// Once healed, the old leader and its follower replace their
+	// uncommitted entries with the majority's.
+	g.cluster.Heal()
+	g.converged(ids, []int{1, 2, 3, 4, 5, 6})
+}
+
+func TestStaleAppendKeepsCommitIndex(t *testing.T) {
+	// n0 is the only node running; the appends below come from a leader
+	// n1 that the test plays itself. The long election timeout keeps n0
+	// from campaigning meanwhile.
+	cfg := testConfig
+	cfg.ElectionTimeout = time.Minute
+	g := startGroupWith(t, 1, cfg, false, func(string, []string) []string {
+		return []string{"n0", "n1", "n2"}
+	})
+
+	entries := []Entry{{Term: 1, Command: json.RawMessage("1")}, {Term: 1, Command: json.RawMessage("2")}, {Term: 1, Command: json.RawMessage("3")}}
+	appendEntries := func(entries []Entry, leaderCommit int) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		resp, err := g.cluster.RPC(ctx, "c1", "n0", map[string]any{
+			"type":           "raft_append_entries",
+			"term":           1,
+			"leader":         "n1",
+			"prev_log_index": 0,
+			"prev_log_term":  0,
+			"entries":        entries,
+			"leader_commit":  leaderCommit,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ok appendEntriesOK
+		if err := json.Unmarshal(resp.Body, &ok); err != nil || !ok.Success || ok.MatchIndex != len(entries) {
+			t.Fatalf("append of %d entries: %s", len(entries), resp.Body)
+		}
+	}
+
+	appendEntries(entries, 3)
+	g.converged([]string{"n0"}, []int{1, 2, 3})
+
+	// A delayed copy of an earlier append, carrying a later commit index,
+	// only matches the first entry: what is committed stays committed.
+	appendEntries(entries[:1], 5)
+	r := g.member("n0")
+	r.mu.Lock()
+	commit, last := r.commitIndex, r.lastIndex()
+	r.mu.Unlock()
+	if commit != 3 || last != 3 {
+		t.Fatalf("commit index %d, last index %d after stale append; want 3, 3", commit, last)
+	}
+}
+
+func TestRestartKeepsState(t *testing.T) {
+	g := startGroup(t, 3, testConfig, true)
+	ids := g.cluster.NodeIDs()
+
+	// Enough entries to snapshot, so restarts load a snapshot and a log.
+	var want []int
+	for v := 1; v <= 30; v++ {
+		want = append(want, v)
+	}
+	g.propose(ids, want...)
+	g.converged(ids, want)
+	term := func(id string) int {
+		r := g.member(id)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.term
+	}
+	before := term(ids[0])
+
+	// Restarting every node loses nothing that was committed, and no node
+	// goes back to an earlier term, in which it might vote again.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, id := range ids {
+		if err := g.cluster.Restart(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if after := term(ids[0]); after < before {
+		t.Fatalf("term went from %d to %d across a restart", before, after)
+	}
+	g.converged(ids, want)
+
+	g.propose(ids, 31)
+	g.converged(ids, append(want, 31))
+}
+
+// without returns ids less the given ones.
+func without(ids []string, drop ...string) []string {
+	return slices.DeleteFunc(slices.Clone(ids), func(id string) bool { return slices.Contains(drop, id) })
+}
+
+func TestMembershipChanges(t *testing.T) {
+	// n3 runs from the start but only joins once added.
+	g := startGroupWith(t, 4, testConfig, false, func(_ string, ids []string) []string {
+		return ids[:3]
+	})
+	ids := g.cluster.NodeIDs()
+	g.propose(ids[:3], 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := g.member(g.leader(ids[:3])).AddServer(ctx, "n3"); err != nil {
+		t.Fatal(err)
+	}
+	g.propose(ids, 2)
+	g.converged(ids, []int{1, 2})
+	for _, id := range ids {
+		if got := g.member(id).Members(); !slices.Equal(got, ids) {
+			t.Fatalf("%s has members %v after adding n3, want %v", id, got, ids)
+		}
+	}
+
+	// A leader that removes itself hands over to the remaining members.
+	old := g.leader(ids)
+	if err := g.member(old).RemoveServer(ctx, old); err != nil {
+		t.Fatal(err)
+	}
+	rest := without(ids, old)
+	if next := g.leader(rest); next == old {
+		t.Fatalf("%s still leads after removing itself", old)
+	}
+	g.propose(rest, 3)
+	g.converged(rest, []int{1, 2, 3})
+	if got := g.values(old); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("removed %s applied %v, want [1 2]", old, got)
+	}
+
+	// Only one change may be in flight: a leader cut off from the others
+	// cannot commit one, and refuses the next.
+	leader := g.leader(rest)
+	g.cluster.Partition([]string{leader}, without(ids, leader))
+	short, cancelShort := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancelShort()
+	if err := g.member(leader).AddServer(short, old); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("adding %s without a quorum returned %v", old, err)
+	}
+	if err := g.member(leader).RemoveServer(ctx, rest[0]); !errors.Is(err, ErrConfigChangeInProgress) {
+		t.Fatalf("second change returned %v, want ErrConfigChangeInProgress", err)
+	}
+}
+
+func TestLaggingFollowerInstallsSnapshot(t *testing.T) {
+	g := startGroup(t, 3, testConfig, true)
+	ids := g.cluster.NodeIDs()
+	g.propose(ids, 0)
+	lagging := without(ids, g.leader(ids))[0]
+
+	// While the follower is cut off, the others compact past everything
+	// it has, so it can only catch up from a snapshot.
+	g.cluster.Partition([]string{lagging}, without(ids, lagging))
+	want := []int{0}
+	for v := 1; v <= 3*testConfig.SnapshotThreshold; v++ {
+		want = append(want, v)
+	}
+	g.propose(without(ids, lagging), want[1:]...)
+	g.cluster.Heal()
+	g.converged(ids, want)
+
+	r := g.member(lagging)
+	g.mu.Lock()
+	l := g.logs[r]
+	g.mu.Unlock()
+	r.mu.Lock()
+	restores := l.restores
+	r.mu.Unlock()
+	if restores == 0 {
+		t.Fatalf("%s caught up without installing a snapshot", lagging)
+	}
+
+	// What it installed was saved: it comes back with it after a restart.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := g.cluster.Restart(ctx, lagging); err != nil {
+		t.Fatal(err)
+	}
+	g.propose(ids, -1)
+	g.converged(ids, append(want, -1))
+}
+
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	n := maelstrom.NewNode()
+	n.Init("n0", []string{"n0"})
+	load := func() State {
+		t.Helper()
+		st, err := NewFileStorage(n, dir).Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+	entries := func(terms ...int) []Entry {
+		es := make([]Entry, len(terms))
+		for i, term := range terms {
+			es[i] = Entry{Term: term}
+		}
+		return es
+	}
+	terms := func(es []Entry) []int {
+		ts := make([]int, len(es))
+		for i, e := range es {
+			ts[i] = e.Term
+		}
+		return ts
+	}
+
+	s := NewFileStorage(n, dir)
+	if st, err := s.Load(); err != nil || st.Term != 0 || len(st.Entries) != 0 {
+		t.Fatalf("fresh storage loaded %+v, %v", st, err)
+	}
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(s.SaveState(2, "n1"))
+	must(s.SaveEntries(1, entries(1, 1, 1)))
+	must(s.SaveEntries(3, entries(2))) // replaces the entry at 3
+	must(s.SaveEntries(4, entries(2)))
+	st := load()
+	if st.Term != 2 || st.VotedFor != "n1" || !slices.Equal(terms(st.Entries), []int{1, 1, 2, 2}) {
+		t.Fatalf("loaded term %d, vote %q, entry terms %v", st.Term, st.VotedFor, terms(st.Entries))
+	}
+
+	// A snapshot leaves only the entries after it in the log.
+	must(s.SaveSnapshot(Snapshot{Index: 2, Term: 1, Members: []string{"n0"}}))
+	st = load()
+	if st.Snapshot.Index != 2 || !slices.Equal(terms(st.Entries), []int{2, 2}) {
+		t.Fatalf("loaded snapshot at %d and entry terms %v after compacting", st.Snapshot.Index, terms(st.Entries))
+	}
+	must(s.SaveEntries(5, entries(3)))
+	if got := terms(load().Entries); !slices.Equal(got, []int{2, 2, 3}) {
+		t.Fatalf("loaded entry terms %v after appending to a compacted log", got)
+	}
+
+	// Saving no entries forgets those after.
+	must(s.SaveEntries(3, nil))
+	if st := load(); len(st.Entries) != 0 || st.Snapshot.Index != 2 {
+		t.Fatalf("loaded %d entries after truncating to the snapshot", len(st.Entries))
+	}
+}
@@ -0,0 +1,242 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Storage persists what a member must not forget when it restarts: its
+// term and vote, so that it never votes twice in a term, and its log and
+// latest snapshot, so that entries it acknowledged stay committed. Raft
+// saves to it before answering any message that depends on what changed.
+type Storage interface {
+	// Load returns everything saved so far. A member that never saved
+	// anything gets the zero State.
+	Load() (State, error)
+
+	// SaveState records the current term and vote.
+	SaveState(term int, votedFor string) error
+
+	// SaveEntries stores entries at indexes first, first+1, ..., and
+	// forgets any saved entries after the last of them.
+	SaveEntries(first int, entries []Entry) error
+
+	// SaveSnapshot stores a snapshot; saved entries it covers are no
+	// longer needed.
+	SaveSnapshot(s Snapshot) error
+}
+
+// State is what a Storage loads on restart.
+type State struct {
+	Term     int
+	VotedFor string
+	Snapshot Snapshot
+	Entries  []Entry // the log after the snapshot, from Snapshot.Index+1
+}
+
+// Snapshot is a compacted prefix of the log: the state machine as of Index,
+// the term of that entry and the configuration in effect.
+type Snapshot struct {
+	Index   int      `json:"index"`
+	Term    int      `json:"term"`
+	Members []string `json:"members"`
+	Data    []byte   `json:"data"`
+}
+
+// memoryStorage is used when no Storage is configured. It saves nothing,
+// so a member that restarts comes back with no term, vote or log.
+type memoryStorage struct{}
+
+func (memoryStorage) Load() (State, error)           { return State{}, nil }
+func (memoryStorage) SaveState(int, string) error    { return nil }
+func (memoryStorage) SaveEntries(int, []Entry) error { return nil }
+func (memoryStorage) SaveSnapshot(Snapshot) error    { return nil }
+
+// FileStorage keeps a member's state in files in a directory named after
+// its node, so that saving never waits on the network: Raft saves while it
+// holds its lock. Maelstrom restarts a node by killing its process and
+// starting another, which loses nothing already written, so files are not
+// synced.
+//
+// The term and vote, and the latest snapshot, are each rewritten whole. The
+// log is appended to, one line per save, and rewritten without the entries
+// a new snapshot covers, so it stays about as long as the log in memory.
+type FileStorage struct {
+	node *maelstrom.Node
+	dir  string
+
+	mu  sync.Mutex
+	log *os.File // open for appending once loaded
+}
+
+// logRecord is one save of entries: those at indexes First onwards.
+type logRecord struct {
+	First   int     `json:"first"`
+	Entries []Entry `json:"entries"`
+}
+
+type fileState struct {
+	Term     int    `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// NewFileStorage returns storage for the member on n in a directory under
+// dir named after the node's ID. It can be created before the node is
+// initialized.
+func NewFileStorage(n *maelstrom.Node, dir string) *FileStorage {
+	return &FileStorage{node: n, dir: dir}
+}
+
+// Load reads the state back and opens the log for appending.
+func (s *FileStorage) Load() (State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var st State
+	if err := os.MkdirAll(s.path(""), 0o755); err != nil {
+		return st, err
+	}
+	var fst fileState
+	if err := readJSON(s.path("state"), &fst); err != nil {
+		return st, err
+	}
+	st.Term, st.VotedFor = fst.Term, fst.VotedFor
+	if err := readJSON(s.path("snapshot"), &st.Snapshot); err != nil {
+		return st, err
+	}
+	entries, err := s.replay(st.Snapshot.Index + 1)
+	if err != nil {
+		return st, err
+	}
+	st.Entries = entries
+	if s.log, err = os.OpenFile(s.path("log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644); err != nil {
+		return st, err
+	}
+	return st, nil
+}
+
+func (s *FileStorage) SaveState(term int, votedFor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSON(s.path("state"), fileState{Term: term, VotedFor: votedFor})
+}
+
+// SaveEntries appends one line to the log.
+func (s *FileStorage) SaveEntries(first int, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("raft: storage not loaded")
+	}
+	line, err := json.Marshal(logRecord{First: first, Entries: entries})
+	if err != nil {
+		return err
+	}
+	_, err = s.log.Write(append(line, '\n'))
+	return err
+}
+
+// SaveSnapshot writes the snapshot, then rewrites the log with only the
+// entries after it.
+func (s *FileStorage) SaveSnapshot(snap Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.log == nil {
+		return errors.New("raft: storage not loaded")
+	}
+	if err := writeJSON(s.path("snapshot"), snap); err != nil {
+		return err
+	}
+	entries, err := s.replay(snap.Index + 1)
+	if err != nil {
+		return err
+	}
+	var buf []byte
+	if len(entries) > 0 {
+		if buf, err = json.Marshal(logRecord{First: snap.Index + 1, Entries: entries}); err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+	}
+	if err := writeFile(s.path("log"), buf); err != nil {
+		return err
+	}
+	s.log.Close()
+	s.log, err = os.OpenFile(s.path("log"), os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+// replay reads the log and returns the entries it holds from index from
+// onwards. Each record replaces everything saved at and after its first
+// index.
+func (s *FileStorage) replay(from int) ([]Entry, error) {
+	f, err := os.Open(s.path("log"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry // entries[i] is at index from+i
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var rec logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("raft: log record: %w", err)
+		}
+		at, saved := rec.First-from, rec.Entries
+		if at < 0 {
+			saved = saved[min(-at, len(saved)):]
+			at = 0
+		}
+		if at > len(entries) {
+			return nil, fmt.Errorf("raft: log record at %d leaves a gap after %d", rec.First, from+len(entries)-1)
+		}
+		entries = append(entries[:at], saved...)
+	}
+	return entries, scanner.Err()
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, s.node.ID(), name)
+}
+
+// readJSON decodes the file at path into v, leaving v alone if there is
+// no such file.
+func readJSON(path string, v any) error {
+	buf, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(buf, v)
+}
+
+func writeJSON(path string, v any) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, buf)
+}
+
+// writeFile replaces the file at path with buf, so that a crash leaves
+// either the old contents or the new.
+func writeFile(path string, buf []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,107 @@
+// Package rpc sends requests to other nodes and to Maelstrom's services
+// with a bound on how long to wait for the reply.
+//
+// Node.SyncRPC, and the KV client built on it, hand the reply to the caller
+// over an unbuffered channel. If the caller's context is done first, a reply
+// that arrives later blocks its callback for good, and Node.Run, which waits
+// for every callback, never returns. Call drops such late replies instead,
+// so callers can put a deadline on every request.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Call sends body to dest and waits for the reply until ctx is done. RPC
+// errors in the reply are returned as *maelstrom.RPCError.
+func Call(ctx context.Context, n *maelstrom.Node, dest string, body any) (maelstrom.Message, error) {
+	replies := make(chan maelstrom.Message, 1)
+	if err := n.RPC(dest, body, func(msg maelstrom.Message) error {
+		replies <- msg
+		return nil
+	}); err != nil {
+		return maelstrom.Message{}, err
+	}
+	select {
+	case msg := <-replies:
+		if err := msg.RPCError(); err != nil {
+			return msg, err
+		}
+		return msg, nil
+	case <-ctx.Done():
+		return maelstrom.Message{}, ctx.Err()
+	}
+}
+
+// KV is a client to one of Maelstrom's key/value services, like
+// maelstrom.KV, but made of Calls: each request can be given a deadline.
+type KV struct {
+	node    *maelstrom.Node
+	service string
+}
+
+// NewKV returns a client to the service, such as maelstrom.LinKV.
+func NewKV(service string, n *maelstrom.Node) *KV {
+	return &KV{node: n, service: service}
+}
+
+// NewLinKV returns a client to the linearizable key/value store.
+func NewLinKV(n *maelstrom.Node) *KV { return NewKV(maelstrom.LinKV, n) }
+
+// NewSeqKV returns a client to the sequentially consistent key/value store.
+func NewSeqKV(n *maelstrom.Node) *KV { return NewKV(maelstrom.SeqKV, n) }
+
+// Read returns the value of key, with numbers as ints. It returns an
+// *RPCError with code KeyDoesNotExist if there is none.
+func (kv *KV) Read(ctx context.Context, key string) (any, error) {
+	var v any
+	if err := kv.ReadInto(ctx, key, &v); err != nil {
+		return nil, err
+	}
+	if f, ok := v.(float64); ok {
+		return int(f), nil
+	}
+	return v, nil
+}
+
+// ReadInto reads the value of key into v.
+func (kv *KV) ReadInto(ctx context.Context, key string, v any) error {
+	msg, err := Call(ctx, kv.node, kv.service, map[string]any{"type": "read", "key": key})
+	if err != nil {
+		return err
+	}
+	body := struct {
+		Value any `json:"value"`
+	}{Value: v}
+	return json.Unmarshal(msg.Body, &body)
+}
+
+// ReadInt reads the value of key as an int.
+func (kv *KV) ReadInt(ctx context.Context, key string) (int, error) {
+	v, err := kv.Read(ctx, key)
+	i, _ := v.(int)
+	return i, err
+}
+
+// Write sets key to value.
+func (kv *KV) Write(ctx context.Context, key string, value any) error {
+	_, err := Call(ctx, kv.node, kv.service, map[string]any{"type": "write", "key": key, "value": value})
+	return err
+}
+
+// CompareAndSwap sets key to to if its value is from, creating it if it
+// does not exist and create is set. It returns an *RPCError with code
+// PreconditionFailed if the value differs, or KeyDoesNotExist.
+//
+// A swap whose reply misses the deadline may still have been applied.
+func (kv *KV) CompareAndSwap(ctx context.Context, key string, from, to any, create bool) error {
+	body := map[string]any{"type": "cas", "key": key, "from": from, "to": to}
+	if create {
+		body["create_if_not_exists"] = true
+	}
+	_, err := Call(ctx, kv.node, kv.service, body)
+	return err
+}
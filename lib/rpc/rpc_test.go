@@ -0,0 +1,181 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// peer runs n0 on pipes, with the test playing n1: it returns a channel of
+// the messages n0 sends, a function to deliver a message to n0, and a
+// channel closed once n0's Run has returned after its STDIN is closed.
+func peer(t *testing.T, setup func(*maelstrom.Node)) (sent <-chan maelstrom.Message, deliver func(string), stdin io.Closer, stopped <-chan struct{}) {
+	t.Helper()
+	log.SetOutput(io.Discard) // the node logs every message
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	n := maelstrom.NewNode()
+	n.Stdin, n.Stdout = inR, outW
+	n.Init("n0", []string{"n0", "n1"})
+	setup(n)
+
+	out := make(chan maelstrom.Message, 16)
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			var msg maelstrom.Message
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err == nil {
+				out <- msg
+			}
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		n.Run()
+	}()
+	deliver = func(line string) {
+		if _, err := io.WriteString(inW, line+"\n"); err != nil {
+			t.Error(err)
+		}
+	}
+	return out, deliver, inW, done
+}
+
+func msgID(t *testing.T, msg maelstrom.Message) int {
+	t.Helper()
+	var body maelstrom.MessageBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.MsgID
+}
+
+func TestCallDropsLateReply(t *testing.T) {
+	results := make(chan error, 1)
+	sent, deliver, stdin, stopped := peer(t, func(n *maelstrom.Node) {
+		n.Handle("start", func(maelstrom.Message) error {
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			_, err := Call(ctx, n, "n1", map[string]any{"type": "ping"})
+			results <- err
+			return nil
+		})
+	})
+	deliver(`{"src":"c1","dest":"n0","body":{"type":"start","msg_id":1}}`)
+	req := <-sent
+	if err := <-results; !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Call with no reply returned %v", err)
+	}
+
+	// The reply turns up after the deadline. With Node.SyncRPC its
+	// callback would block for good, and Run would never return.
+	deliver(fmt.Sprintf(`{"src":"n1","dest":"n0","body":{"type":"ping_ok","in_reply_to":%d}}`, msgID(t, req)))
+	stdin.Close()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the node did not stop after a late reply")
+	}
+}
+
+func TestCallReturnsReply(t *testing.T) {
+	type result struct {
+		msg maelstrom.Message
+		err error
+	}
+	results := make(chan result, 1)
+	sent, deliver, stdin, _ := peer(t, func(n *maelstrom.Node) {
+		n.Handle("start", func(maelstrom.Message) error {
+			msg, err := Call(context.Background(), n, "n1", map[string]any{"type": "ping"})
+			results <- result{msg, err}
+			return nil
+		})
+	})
+	defer stdin.Close()
+
+	deliver(`{"src":"c1","dest":"n0","body":{"type":"start","msg_id":1}}`)
+	id := msgID(t, <-sent)
+	deliver(fmt.Sprintf(`{"src":"n1","dest":"n0","body":{"type":"ping_ok","in_reply_to":%d}}`, id))
+	if r := <-results; r.err != nil || r.msg.Type() != "ping_ok" {
+		t.Fatalf("Call returned %s, %v", r.msg.Body, r.err)
+	}
+
+	deliver(`{"src":"c1","dest":"n0","body":{"type":"start","msg_id":2}}`)
+	id = msgID(t, <-sent)
+	deliver(fmt.Sprintf(`{"src":"n1","dest":"n0","body":{"type":"error","code":11,"text":"busy","in_reply_to":%d}}`, id))
+	var rpcErr *maelstrom.RPCError
+	if r := <-results; !errors.As(r.err, &rpcErr) || rpcErr.Code != maelstrom.TemporarilyUnavailable {
+		t.Fatalf("Call of an error reply returned %v", r.err)
+	}
+}
+
+func TestKV(t *testing.T) {
+	checks := make(chan error, 1)
+	setup := func(n *maelstrom.Node) {
+		kv := NewLinKV(n)
+		n.Handle("check", func(msg maelstrom.Message) error {
+			checks <- checkKV(kv)
+			return n.Reply(msg, map[string]any{"type": "check_ok"})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+	if _, err := c.RPC(ctx, "c1", "n0", map[string]any{"type": "check"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-checks; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// checkKV runs the KV client's requests against a fresh lin-kv.
+func checkKV(kv *KV) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := kv.Read(ctx, "x"); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		return fmt.Errorf("read of a missing key: %v", err)
+	}
+	if err := kv.CompareAndSwap(ctx, "x", 0, 1, false); maelstrom.ErrorCode(err) != maelstrom.KeyDoesNotExist {
+		return fmt.Errorf("swap of a missing key without create: %v", err)
+	}
+	if err := kv.CompareAndSwap(ctx, "x", nil, 1, true); err != nil {
+		return fmt.Errorf("swap creating a key: %v", err)
+	}
+	if err := kv.CompareAndSwap(ctx, "x", 5, 6, false); maelstrom.ErrorCode(err) != maelstrom.PreconditionFailed {
+		return fmt.Errorf("swap from the wrong value: %v", err)
+	}
+	if err := kv.CompareAndSwap(ctx, "x", 1, 2, false); err != nil {
+		return fmt.Errorf("swap: %v", err)
+	}
+	if v, err := kv.ReadInt(ctx, "x"); err != nil || v != 2 {
+		return fmt.Errorf("read %d, %v after swapping to 2", v, err)
+	}
+
+	type record struct {
+		Holder string `json:"holder"`
+	}
+	if err := kv.Write(ctx, "r", record{"n0"}); err != nil {
+		return fmt.Errorf("write: %v", err)
+	}
+	var got record
+	if err := kv.ReadInto(ctx, "r", &got); err != nil || got.Holder != "n0" {
+		return fmt.Errorf("read %+v, %v after writing a record", got, err)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// Package schema validates Maelstrom message bodies against a small subset of
+// JSON Schema: type, properties, required, additionalProperties, items,
+// prefixItems, enum and minimum.
+//
+// Workloads declare the schemas of their requests and replies, and nodes
+// register their client handlers through a Validator, which checks every
+// request and, in debug mode, every reply against them.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Schema is a parsed JSON schema. The zero value accepts any document.
+type Schema struct {
+	Type                 types              `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	PrefixItems          []*Schema          `json:"prefixItems,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+
+	// reject is set for the boolean schema false, which matches nothing.
+	reject bool
+}
+
+// types is the "type" keyword, which may be a single name or a list.
+type types []string
+
+func (t *types) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*t = types{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*t = many
+	return nil
+}
+
+// UnmarshalJSON accepts both schema objects and the boolean schemas true and
+// false.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	switch string(bytes.TrimSpace(data)) {
+	case "true":
+		*s = Schema{}
+		return nil
+	case "false":
+		*s = Schema{reject: true}
+		return nil
+	}
+	type plain Schema
+	return json.Unmarshal(data, (*plain)(s))
+}
+
+// Parse parses a schema from its JSON representation.
+func Parse(data string) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal([]byte(data), &s); err != nil {
+		return nil, fmt.Errorf("parse schema: %w", err)
+	}
+	return &s, nil
+}
+
+// MustParse is like Parse but panics on error. It is intended for schemas
+// declared as package-level variables.
+func MustParse(data string) *Schema {
+	s, err := Parse(data)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// ValidateJSON decodes raw JSON and validates it against the schema.
+func (s *Schema) ValidateJSON(raw []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return err
+	}
+	return s.Validate(v)
+}
+
+// Validate validates a document decoded with json.Decoder.UseNumber.
+func (s *Schema) Validate(v any) error {
+	return s.validate("", v)
+}
+
+// ValidationError describes where and why a document failed validation.
+type ValidationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s: %s", path, e.Reason)
+}
+
+func (s *Schema) validate(path string, v any) error {
+	fail := func(format string, args ...any) error {
+		return &ValidationError{Path: path, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if s.reject {
+		return fail("unexpected value")
+	}
+	if len(s.Type) > 0 && !slices.ContainsFunc(s.Type, func(t string) bool { return hasType(v, t) }) {
+		return fail("expected %s, got %s", strings.Join(s.Type, " or "), typeOf(v))
+	}
+	if len(s.Enum) > 0 && !slices.ContainsFunc(s.Enum, func(e any) bool { return equal(e, v) }) {
+		return fail("value %v not in %v", v, s.Enum)
+	}
+	if s.Minimum != nil {
+		if n, ok := v.(json.Number); ok {
+			if f, err := n.Float64(); err == nil && f < *s.Minimum {
+				return fail("%s is less than minimum %v", n, *s.Minimum)
+			}
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fail("missing required property %q", name)
+			}
+		}
+		for name, child := range v {
+			sub, ok := s.Properties[name]
+			if !ok {
+				sub = s.AdditionalProperties
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(path+"/"+name, child); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for i, child := range v {
+			sub := s.Items
+			if i < len(s.PrefixItems) {
+				sub = s.PrefixItems[i]
+			}
+			if sub == nil {
+				continue
+			}
+			if err := sub.validate(fmt.Sprintf("%s/%d", path, i), child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "integer":
+		n, ok := v.(json.Number)
+		return ok && !strings.ContainsAny(n.String(), ".eE")
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	}
+	return typeOf(v) == t
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// equal compares an enum member, decoded without UseNumber, with a value.
+func equal(e, v any) bool {
+	if n, ok := v.(json.Number); ok {
+		f, err := n.Float64()
+		return err == nil && e == f
+	}
+	return e == v
+}
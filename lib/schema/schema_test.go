@@ -0,0 +1,190 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestWorkloads(t *testing.T) {
+	tests := []struct {
+		w     *Workload
+		reply bool
+		typ   string
+		body  string
+		valid bool
+	}{
+		{Echo, false, "echo", `{"type": "echo", "echo": "hi"}`, true},
+		{Echo, false, "echo", `{"type": "echo"}`, false},
+		{Echo, true, "rtt_matrix_ok", `{"matrix": {"n0": {"n1": 1.5}}}`, true},
+		{Echo, true, "rtt_matrix_ok", `{"matrix": {"n0": {"n1": -1}}}`, false},
+		{Echo, true, "rtt_matrix_ok", `{"matrix": {"n0": 3}}`, false},
+
+		{UniqueIDs, false, "generate", `{}`, true},
+		{UniqueIDs, false, "generate", `{"strategy": "ulid", "encoding": "base32", "checksum": true}`, true},
+		{UniqueIDs, false, "generate", `{"strategy": "uuidv4"}`, false},
+		{UniqueIDs, false, "generate", `{"checksum": "yes"}`, false},
+		{UniqueIDs, false, "generate_batch", `{"count": 3}`, true},
+		{UniqueIDs, false, "generate_batch", `{"count": 0}`, false},
+		{UniqueIDs, false, "generate_batch", `{"count": 1.5}`, false},
+		{UniqueIDs, false, "generate_batch", `{}`, false},
+		{UniqueIDs, false, "inspect_id", `{"id": 18446744073709551615}`, true},
+		{UniqueIDs, false, "inspect_id", `{"id": "01ARZ3NDEKTSV4RRFFQ69G5FAV"}`, true},
+		{UniqueIDs, false, "inspect_id", `{"id": null}`, false},
+		{UniqueIDs, true, "inspect_id_ok", `{"id": 1, "strategy": "sequence", "version": 1, "sequence": 1}`, true},
+		{UniqueIDs, true, "inspect_id_ok", `{"id": 1, "strategy": "sequence"}`, false},
+
+		{Broadcast, false, "broadcast", `{"message": 7}`, true},
+		{Broadcast, false, "broadcast", `{"message": "7"}`, false},
+		{Broadcast, false, "topology", `{"topology": {"n0": ["n1", "n2"], "n1": []}}`, true},
+		{Broadcast, false, "topology", `{"topology": {"n0": [1]}}`, false},
+		{Broadcast, true, "broadcast_ok", `{"type": "broadcast_ok"}`, true},
+		// The boolean schema false forbids echoing the request's fields.
+		{Broadcast, true, "broadcast_ok", `{"type": "broadcast_ok", "message": 7}`, false},
+		{Broadcast, true, "topology_ok", `{"topology": {}}`, false},
+		{Broadcast, true, "read_ok", `{"messages": [1, 2, 3]}`, true},
+		{Broadcast, true, "read_ok", `{"messages": [1, 2.5]}`, false},
+		{Broadcast, true, "read_ok", `{}`, false},
+
+		{GCounter, false, "add", `{"delta": 0}`, true},
+		{GCounter, false, "add", `{"delta": 2, "idempotency_key": "c1-1"}`, true},
+		{GCounter, false, "add", `{"delta": -1}`, false},
+		{GCounter, false, "add", `{"delta": 1e3}`, false},
+		{GCounter, true, "read_ok", `{"value": 12}`, true},
+		{GCounter, true, "read_ok", `{"value": null}`, false},
+
+		{Kafka, false, "send", `{"key": "k1", "msg": 5}`, true},
+		{Kafka, false, "send", `{"key": 1, "msg": 5}`, false},
+		{Kafka, false, "poll", `{"offsets": {"k1": 0, "k2": 10}}`, true},
+		{Kafka, false, "poll", `{"offsets": {"k1": -1}}`, false},
+		{Kafka, false, "commit_offsets", `{"offsets": []}`, false},
+		{Kafka, false, "list_committed_offsets", `{"keys": ["k1"]}`, true},
+		{Kafka, false, "list_committed_offsets", `{"keys": "k1"}`, false},
+		{Kafka, true, "send_ok", `{"offset": 0}`, true},
+		{Kafka, true, "poll_ok", `{"msgs": {"k1": [[0, 5], [1, 6]]}}`, true},
+		{Kafka, true, "poll_ok", `{"msgs": {"k1": [[-1, 5]]}}`, false},
+		{Kafka, true, "poll_ok", `{"msgs": {"k1": [[0, "5"]]}}`, false},
+		// Pairs only: items false rejects anything past the prefix.
+		{Kafka, true, "poll_ok", `{"msgs": {"k1": [[0, 5, 6]]}}`, false},
+
+		{Txn, false, "txn", `{"txn": [["r", 1, null], ["w", 1, 6]]}`, true},
+		{Txn, false, "txn", `{"txn": [["x", 1, null]]}`, false},
+		{Txn, false, "txn", `{"txn": [["w", "1", 6]]}`, false},
+		{Txn, false, "txn", `{"txn": [["w", 1, 6, 7]]}`, false},
+		{Txn, true, "txn_ok", `{"txn": [["r", 1, 6]]}`, true},
+		{Txn, true, "txn_ok", `{"txn": [["r", 1, "6"]]}`, false},
+	}
+	for _, tt := range tests {
+		schemas := tt.w.Requests
+		if tt.reply {
+			schemas = tt.w.Replies
+		}
+		s := schemas[tt.typ]
+		if s == nil {
+			t.Fatalf("%s has no %s schema", tt.w.Name, tt.typ)
+		}
+		err := s.ValidateJSON([]byte(tt.body))
+		if (err == nil) != tt.valid {
+			t.Errorf("%s %s %s: got %v, want valid %t", tt.w.Name, tt.typ, tt.body, err, tt.valid)
+		}
+		var verr *ValidationError
+		if err != nil && !errors.As(err, &verr) {
+			t.Errorf("%s %s %s: got %T, want a *ValidationError", tt.w.Name, tt.typ, tt.body, err)
+		}
+	}
+}
+
+func TestValidationErrorPath(t *testing.T) {
+	err := Kafka.Replies["poll_ok"].ValidateJSON([]byte(`{"msgs": {"k1": [[0, 5], [1, "6"]]}}`))
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Path != "/msgs/k1/1/1" {
+		t.Fatalf("got %v, want an error at /msgs/k1/1/1", err)
+	}
+}
+
+func TestHasType(t *testing.T) {
+	tests := []struct {
+		v    any
+		typ  string
+		want bool
+	}{
+		{json.Number("1"), "integer", true},
+		{json.Number("-7"), "integer", true},
+		{json.Number("18446744073709551616"), "integer", true},
+		{json.Number("1.0"), "integer", false},
+		{json.Number("1e3"), "integer", false},
+		{json.Number("1E3"), "integer", false},
+		{json.Number("1.5"), "number", true},
+		{json.Number("1"), "number", true},
+		{"1", "integer", false},
+		{"1", "number", false},
+		{"1", "string", true},
+		{nil, "null", true},
+		{nil, "object", false},
+		{false, "boolean", true},
+		{[]any{}, "array", true},
+		{map[string]any{}, "object", true},
+		{map[string]any{}, "array", false},
+	}
+	for _, tt := range tests {
+		if got := hasType(tt.v, tt.typ); got != tt.want {
+			t.Errorf("hasType(%#v, %q) = %t, want %t", tt.v, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		e, v any
+		want bool
+	}{
+		{1.0, json.Number("1"), true},
+		{1.0, json.Number("1.0"), true},
+		{1.0, json.Number("1e0"), true},
+		{1.0, json.Number("2"), false},
+		{"1", json.Number("1"), false},
+		{1.0, "1", false},
+		{"r", "r", true},
+		{"r", "w", false},
+		{true, true, true},
+		{nil, nil, true},
+		{nil, false, false},
+	}
+	for _, tt := range tests {
+		if got := equal(tt.e, tt.v); got != tt.want {
+			t.Errorf("equal(%#v, %#v) = %t, want %t", tt.e, tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestBooleanSchemas(t *testing.T) {
+	tests := []struct {
+		schema string
+		doc    string
+		valid  bool
+	}{
+		{`true`, `null`, true},
+		{`true`, `{"a": [1]}`, true},
+		{`false`, `null`, false},
+		{`false`, `0`, false},
+		{`{"properties": {"a": false}}`, `{}`, true},
+		{`{"properties": {"a": false}}`, `{"a": 1}`, false},
+		{`{"properties": {"a": true}, "additionalProperties": false}`, `{"a": 1}`, true},
+		{`{"properties": {"a": true}, "additionalProperties": false}`, `{"a": 1, "b": 2}`, false},
+		{`{"prefixItems": [true, {"type": "string"}], "items": false}`, `[1, "x"]`, true},
+		{`{"prefixItems": [true, {"type": "string"}], "items": false}`, `[1]`, true},
+		{`{"prefixItems": [true, {"type": "string"}], "items": false}`, `[1, 2]`, false},
+		{`{"prefixItems": [true, {"type": "string"}], "items": false}`, `[1, "x", 3]`, false},
+		{`{"prefixItems": [{"type": "integer"}], "items": {"type": "string"}}`, `[1, "x", "y"]`, true},
+		{`{"prefixItems": [{"type": "integer"}], "items": {"type": "string"}}`, `[1, "x", 3]`, false},
+	}
+	for _, tt := range tests {
+		s, err := Parse(tt.schema)
+		if err != nil {
+			t.Fatalf("Parse(%s): %v", tt.schema, err)
+		}
+		if err := s.ValidateJSON([]byte(tt.doc)); (err == nil) != tt.valid {
+			t.Errorf("%s against %s: got %v, want valid %t", tt.doc, tt.schema, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// DebugEnv is the environment variable that enables validation of outbound
+// replies when set to a non-empty value.
+const DebugEnv = "GLOOMERS_DEBUG"
+
+// Validator checks the messages a node handles against a workload's schemas.
+// Inbound requests are always validated; outbound replies only in debug mode.
+type Validator struct {
+	node     *maelstrom.Node
+	workload *Workload
+	debug    bool
+}
+
+// NewValidator returns a validator for workload messages handled by n.
+func NewValidator(n *maelstrom.Node, w *Workload) *Validator {
+	return &Validator{node: n, workload: w, debug: os.Getenv(DebugEnv) != ""}
+}
+
+// Handle registers fn for typ on the node. Requests that do not match the
+// workload's schema for typ are rejected with a malformed-request error
+// before fn runs. Types without a schema are passed through.
+func (v *Validator) Handle(typ string, fn maelstrom.HandlerFunc) {
+	s := v.workload.Requests[typ]
+	v.node.Handle(typ, func(msg maelstrom.Message) error {
+		if s != nil {
+			if err := s.ValidateJSON(msg.Body); err != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest,
+					fmt.Sprintf("invalid %s %s request: %s", v.workload.Name, typ, err))
+			}
+		}
+		return fn(msg)
+	})
+}
+
+// Reply sends body in reply to req. In debug mode the body is first checked
+// against the workload's schema for its type and an error is returned
+// instead of sending an invalid reply.
+//
+// Unlike maelstrom.Node.Reply, integers beyond 2^53 (such as 64-bit IDs)
+// are sent exactly rather than rounded through float64.
+func (v *Validator) Reply(req maelstrom.Message, body any) error {
+	if v.debug {
+		if err := v.checkReply(body); err != nil {
+			log.Printf("invalid reply to %s: %s", req.Body, err)
+			return err
+		}
+	}
+
+	var reqBody maelstrom.MessageBody
+	if err := json.Unmarshal(req.Body, &reqBody); err != nil {
+		return err
+	}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	b := make(map[string]any)
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	if err := dec.Decode(&b); err != nil {
+		return err
+	}
+	b["in_reply_to"] = reqBody.MsgID
+	return v.node.Send(req.Src, b)
+}
+
+func (v *Validator) checkReply(body any) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	var typed maelstrom.MessageBody
+	if err := json.Unmarshal(buf, &typed); err != nil {
+		return err
+	}
+	if typed.Type == "error" {
+		return nil
+	}
+
+	s := v.workload.Replies[typed.Type]
+	if s == nil {
+		return fmt.Errorf("%s workload has no %q reply", v.workload.Name, typed.Type)
+	}
+	if err := s.ValidateJSON(buf); err != nil {
+		return fmt.Errorf("invalid %s %s reply: %w", v.workload.Name, typed.Type, err)
+	}
+	return nil
+}
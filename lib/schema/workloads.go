@@ -0,0 +1,257 @@
+package schema
+
+// Workload declares the request and response bodies of a Maelstrom workload,
+// keyed by message type. The schemas mirror the Maelstrom workload docs.
+type Workload struct {
+	Name     string
+	Requests map[string]*Schema
+	Replies  map[string]*Schema
+}
+
+// ok is the schema for replies that carry no fields besides their type.
+const ok = `{"type": "object"}`
+
+// Echo is the echo workload from challenge #1.
+var Echo = &Workload{
+	Name: "echo",
+	Requests: map[string]*Schema{
+		"echo":       MustParse(`{"type": "object", "required": ["echo"]}`),
+		"rtt_matrix": MustParse(`{"type": "object"}`),
+	},
+	Replies: map[string]*Schema{
+		"echo_ok": MustParse(`{"type": "object", "required": ["echo"]}`),
+		"rtt_matrix_ok": MustParse(`{
+			"type": "object",
+			"required": ["matrix"],
+			"properties": {
+				"matrix": {
+					"type": "object",
+					"additionalProperties": {
+						"type": "object",
+						"additionalProperties": {"type": "number", "minimum": 0}
+					}
+				}
+			}
+		}`),
+	},
+}
+
+// UniqueIDs is the unique-ids workload from challenge #2.
+var UniqueIDs = &Workload{
+	Name: "unique-ids",
+	Requests: map[string]*Schema{
+		"generate": MustParse(`{
+			"type": "object",
+			"properties": {
+				"strategy": {"enum": ["counter", "block", "snowflake", "ulid", "uuidv7", "ksuid"]},
+				"namespace": {"type": "string"},
+				"idempotency_key": {"type": "string"},
+				"encoding": {"enum": ["base62", "base32", "hex"]},
+				"prefix": {"type": "string"},
+				"checksum": {"type": "boolean"}
+			}
+		}`),
+		"generate_batch": MustParse(`{
+			"type": "object",
+			"required": ["count"],
+			"properties": {
+				"count": {"type": "integer", "minimum": 1},
+				"strategy": {"enum": ["counter", "block", "snowflake", "ulid", "uuidv7", "ksuid"]},
+				"encoding": {"enum": ["base62", "base32", "hex"]},
+				"prefix": {"type": "string"},
+				"checksum": {"type": "boolean"}
+			}
+		}`),
+		"inspect_id": MustParse(`{
+			"type": "object",
+			"required": ["id"],
+			"properties": {
+				"id": {"type": ["integer", "string"]},
+				"encoding": {"enum": ["base62", "base32", "hex"]},
+				"prefix": {"type": "string"},
+				"checksum": {"type": "boolean"}
+			}
+		}`),
+	},
+	Replies: map[string]*Schema{
+		"generate_ok": MustParse(`{"type": "object", "required": ["id"]}`),
+		"generate_batch_ok": MustParse(`{
+			"type": "object",
+			"required": ["ids"],
+			"properties": {"ids": {"type": "array"}}
+		}`),
+		"inspect_id_ok": MustParse(`{
+			"type": "object",
+			"required": ["id", "strategy", "version"],
+			"properties": {
+				"strategy": {"enum": ["counter", "block", "sequence", "snowflake", "ulid", "uuidv7", "ksuid"]},
+				"version": {"type": "integer"},
+				"node": {"type": "string"},
+				"node_index": {"type": "integer"},
+				"timestamp": {"type": "integer"},
+				"time": {"type": "string"},
+				"sequence": {"type": "integer"},
+				"decoded_id": {"type": ["integer", "string"]}
+			}
+		}`),
+	},
+}
+
+// Broadcast is the broadcast workload from challenge #3.
+var Broadcast = &Workload{
+	Name: "broadcast",
+	Requests: map[string]*Schema{
+		"broadcast": MustParse(`{
+			"type": "object",
+			"required": ["message"],
+			"properties": {"message": {"type": "integer"}}
+		}`),
+		"read": MustParse(`{"type": "object"}`),
+		"topology": MustParse(`{
+			"type": "object",
+			"required": ["topology"],
+			"properties": {
+				"topology": {
+					"type": "object",
+					"additionalProperties": {"type": "array", "items": {"type": "string"}}
+				}
+			}
+		}`),
+	},
+	Replies: map[string]*Schema{
+		"broadcast_ok": MustParse(`{
+			"type": "object",
+			"properties": {"message": false}
+		}`),
+		"read_ok": MustParse(`{
+			"type": "object",
+			"required": ["messages"],
+			"properties": {"messages": {"type": "array", "items": {"type": "integer"}}}
+		}`),
+		"topology_ok": MustParse(`{
+			"type": "object",
+			"properties": {"topology": false}
+		}`),
+	},
+}
+
+// GCounter is the g-counter workload from challenge #4.
+var GCounter = &Workload{
+	Name: "g-counter",
+	Requests: map[string]*Schema{
+		"add": MustParse(`{
+			"type": "object",
+			"required": ["delta"],
+			"properties": {
+				"delta": {"type": "integer", "minimum": 0},
+				"idempotency_key": {"type": "string"}
+			}
+		}`),
+		"read": MustParse(`{"type": "object"}`),
+	},
+	Replies: map[string]*Schema{
+		"add_ok": MustParse(ok),
+		"read_ok": MustParse(`{
+			"type": "object",
+			"required": ["value"],
+			"properties": {"value": {"type": "integer"}}
+		}`),
+	},
+}
+
+// offsets is a map of kafka keys to offsets.
+const offsets = `{"type": "object", "additionalProperties": {"type": "integer", "minimum": 0}}`
+
+// Kafka is the kafka workload from challenge #5.
+var Kafka = &Workload{
+	Name: "kafka",
+	Requests: map[string]*Schema{
+		"send": MustParse(`{
+			"type": "object",
+			"required": ["key", "msg"],
+			"properties": {
+				"key": {"type": "string"},
+				"msg": {"type": "integer"},
+				"idempotency_key": {"type": "string"}
+			}
+		}`),
+		"poll": MustParse(`{
+			"type": "object",
+			"required": ["offsets"],
+			"properties": {"offsets": ` + offsets + `}
+		}`),
+		"commit_offsets": MustParse(`{
+			"type": "object",
+			"required": ["offsets"],
+			"properties": {"offsets": ` + offsets + `}
+		}`),
+		"list_committed_offsets": MustParse(`{
+			"type": "object",
+			"required": ["keys"],
+			"properties": {"keys": {"type": "array", "items": {"type": "string"}}}
+		}`),
+	},
+	Replies: map[string]*Schema{
+		"send_ok": MustParse(`{
+			"type": "object",
+			"required": ["offset"],
+			"properties": {"offset": {"type": "integer", "minimum": 0}}
+		}`),
+		"poll_ok": MustParse(`{
+			"type": "object",
+			"required": ["msgs"],
+			"properties": {
+				"msgs": {
+					"type": "object",
+					"additionalProperties": {
+						"type": "array",
+						"items": {
+							"type": "array",
+							"prefixItems": [{"type": "integer", "minimum": 0}, {"type": "integer"}],
+							"items": false
+						}
+					}
+				}
+			}
+		}`),
+		"commit_offsets_ok": MustParse(ok),
+		"list_committed_offsets_ok": MustParse(`{
+			"type": "object",
+			"required": ["offsets"],
+			"properties": {"offsets": ` + offsets + `}
+		}`),
+	},
+}
+
+// txn is a list of [op, key, value] micro-operations.
+const txn = `{
+	"type": "array",
+	"items": {
+		"type": "array",
+		"prefixItems": [
+			{"enum": ["r", "w"]},
+			{"type": "integer"},
+			{"type": ["integer", "null"]}
+		],
+		"items": false
+	}
+}`
+
+// Txn is the txn-rw-register workload from challenge #6.
+var Txn = &Workload{
+	Name: "txn",
+	Requests: map[string]*Schema{
+		"txn": MustParse(`{
+			"type": "object",
+			"required": ["txn"],
+			"properties": {"txn": ` + txn + `}
+		}`),
+	},
+	Replies: map[string]*Schema{
+		"txn_ok": MustParse(`{
+			"type": "object",
+			"required": ["txn"],
+			"properties": {"txn": ` + txn + `}
+		}`),
+	},
+}
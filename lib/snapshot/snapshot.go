@@ -0,0 +1,248 @@
+// Package snapshot lets a node that starts late or restarts catch up by
+// copying a peer's state instead of waiting for gossip. The peer serializes
+// its state once and serves it in fixed-size chunks; the joiner installs it
+// only after every chunk has arrived. Nodes that start together with their
+// peers copy nothing: there is nothing to catch up on.
+package snapshot
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+)
+
+// DefaultChunkSize is the number of snapshot bytes sent per message.
+const DefaultChunkSize = 32 * 1024
+
+// DefaultLateAfter is how much longer than a joining node a peer must have
+// been running for the joiner to copy its state.
+const DefaultLateAfter = time.Second
+
+// retention is how long a served snapshot is kept for follow-up chunk requests.
+const retention = 30 * time.Second
+
+// callTimeout bounds each request for a chunk.
+const callTimeout = 5 * time.Second
+
+// statusTimeout bounds asking a peer how long it has been running. Peers
+// that have not started yet do not answer.
+const statusTimeout = time.Second
+
+// Source serializes the node's current state.
+type Source func() ([]byte, error)
+
+// Sink installs a snapshot received from a peer. It must apply the whole
+// snapshot atomically with respect to the node's other handlers; merging it
+// into the local state is usually the right thing to do.
+type Sink func(data []byte) error
+
+// Transfer serves snapshots to peers and fetches them from peers.
+type Transfer struct {
+	node      *maelstrom.Node
+	source    Source
+	sink      Sink
+	ChunkSize int
+	LateAfter time.Duration
+
+	mu      sync.Mutex
+	started time.Time // when Bootstrap was called; zero until then
+	nextID  int
+	served  map[string]*served
+}
+
+type served struct {
+	data    []byte
+	created time.Time
+}
+
+type requestBody struct {
+	Type       string `json:"type"`
+	SnapshotID string `json:"snapshot_id,omitempty"`
+	Chunk      int    `json:"chunk"`
+}
+
+type statusBody struct {
+	Type   string `json:"type"`
+	Uptime int64  `json:"uptime_ms"`
+}
+
+type chunkBody struct {
+	Type       string `json:"type"`
+	SnapshotID string `json:"snapshot_id"`
+	Chunk      int    `json:"chunk"`
+	Total      int    `json:"total"`
+	Data       []byte `json:"data"`
+}
+
+// New returns a Transfer for n and registers its "snapshot_status" and
+// "snapshot_request" handlers.
+func New(n *maelstrom.Node, source Source, sink Sink) *Transfer {
+	t := &Transfer{
+		node:      n,
+		source:    source,
+		sink:      sink,
+		ChunkSize: DefaultChunkSize,
+		LateAfter: DefaultLateAfter,
+		served:    make(map[string]*served),
+	}
+	n.Handle("snapshot_status", t.handleStatus)
+	n.Handle("snapshot_request", t.handleRequest)
+	return t
+}
+
+// handleStatus tells a joining peer how long this node has been running.
+// A node that has not started has nothing to offer, and may not even know
+// its own ID yet, so it does not answer.
+func (t *Transfer) handleStatus(msg maelstrom.Message) error {
+	t.mu.Lock()
+	started := t.started
+	t.mu.Unlock()
+	if started.IsZero() {
+		return nil
+	}
+	return t.node.Reply(msg, statusBody{Type: "snapshot_status_ok", Uptime: time.Since(started).Milliseconds()})
+}
+
+func (t *Transfer) handleRequest(msg maelstrom.Message) error {
+	var body requestBody
+	if err := json.Unmarshal(msg.Body, &body); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	for id, s := range t.served {
+		if time.Since(s.created) > retention {
+			delete(t.served, id)
+		}
+	}
+	s, ok := t.served[body.SnapshotID]
+	if !ok {
+		if body.SnapshotID != "" {
+			t.mu.Unlock()
+			return maelstrom.NewRPCError(maelstrom.PreconditionFailed, "snapshot "+body.SnapshotID+" expired")
+		}
+		data, err := t.source()
+		if err != nil {
+			t.mu.Unlock()
+			return err
+		}
+		t.nextID++
+		body.SnapshotID = fmt.Sprintf("%s-%d", t.node.ID(), t.nextID)
+		s = &served{data: data, created: time.Now()}
+		t.served[body.SnapshotID] = s
+	}
+	t.mu.Unlock()
+
+	total := max(1, (len(s.data)+t.ChunkSize-1)/t.ChunkSize)
+	if body.Chunk < 0 || body.Chunk >= total {
+		return maelstrom.NewRPCError(maelstrom.MalformedRequest, fmt.Sprintf("chunk %d out of range", body.Chunk))
+	}
+	start := body.Chunk * t.ChunkSize
+	end := min(start+t.ChunkSize, len(s.data))
+
+	return t.node.Reply(msg, chunkBody{
+		Type:       "snapshot_chunk",
+		SnapshotID: body.SnapshotID,
+		Chunk:      body.Chunk,
+		Total:      total,
+		Data:       s.data[start:end],
+	})
+}
+
+// Fetch copies a snapshot from peer chunk by chunk and installs it.
+func (t *Transfer) Fetch(ctx context.Context, peer string) error {
+	var (
+		buf []byte
+		id  string
+	)
+	for chunk, total := 0, 1; chunk < total; chunk++ {
+		resp, err := t.call(ctx, peer, requestBody{Type: "snapshot_request", SnapshotID: id, Chunk: chunk})
+		if err != nil {
+			return fmt.Errorf("fetch chunk %d from %s: %w", chunk, peer, err)
+		}
+		var body chunkBody
+		if err := json.Unmarshal(resp.Body, &body); err != nil {
+			return err
+		}
+		id, total = body.SnapshotID, body.Total
+		buf = append(buf, body.Data...)
+	}
+	return t.sink(buf)
+}
+
+// Bootstrap catches the node up if it joined late: it asks every peer how
+// long it has been running and fetches a snapshot from the longest-running
+// peer that can serve one, provided that peer has been running LateAfter
+// longer than this node. It should be started in the background once the
+// node is initialized; in a cluster started all at once it copies nothing.
+func (t *Transfer) Bootstrap(ctx context.Context) error {
+	t.mu.Lock()
+	t.started = time.Now()
+	t.mu.Unlock()
+
+	type peerUptime struct {
+		id     string
+		uptime time.Duration
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		peers []peerUptime
+	)
+	for _, peer := range t.node.NodeIDs() {
+		if peer == t.node.ID() {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			callCtx, cancel := context.WithTimeout(ctx, statusTimeout)
+			defer cancel()
+			resp, err := t.call(callCtx, peer, map[string]any{"type": "snapshot_status"})
+			if err != nil {
+				return
+			}
+			var body statusBody
+			if err := json.Unmarshal(resp.Body, &body); err != nil {
+				return
+			}
+			if uptime := time.Duration(body.Uptime) * time.Millisecond; uptime > t.LateAfter {
+				mu.Lock()
+				peers = append(peers, peerUptime{peer, uptime})
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(peers) == 0 {
+		return nil
+	}
+	slices.SortFunc(peers, func(a, b peerUptime) int { return cmp.Compare(b.uptime, a.uptime) })
+
+	var errs []error
+	for _, peer := range peers {
+		fetchCtx, cancel := context.WithTimeout(ctx, callTimeout)
+		err := t.Fetch(fetchCtx, peer.id)
+		cancel()
+		if err == nil {
+			log.Printf("installed snapshot from %s", peer.id)
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// call sends body to peer and waits for the reply until ctx is done.
+func (t *Transfer) call(ctx context.Context, peer string, body any) (maelstrom.Message, error) {
+	return rpc.Call(ctx, t.node, peer, body)
+}
@@ -0,0 +1,141 @@
+package snapshot
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+const testLateAfter = 200 * time.Millisecond
+
+// startCluster runs nodes that keep a set of integers, added by clients
+// one node at a time, and bootstrap from each other when they join late.
+// It returns how many snapshots the nodes have served.
+func startCluster(t *testing.T, nodes int) (*harness.Cluster, *atomic.Int64) {
+	t.Helper()
+	var served atomic.Int64
+	setup := func(n *maelstrom.Node) {
+		var mu sync.Mutex
+		set := make(map[int]bool)
+		transfer := New(n, func() ([]byte, error) {
+			served.Add(1)
+			mu.Lock()
+			defer mu.Unlock()
+			return json.Marshal(set)
+		}, func(data []byte) error {
+			var got map[int]bool
+			if err := json.Unmarshal(data, &got); err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for v := range got {
+				set[v] = true
+			}
+			return nil
+		})
+		transfer.ChunkSize = 8 // several chunks even for a small set
+		transfer.LateAfter = testLateAfter
+
+		n.Handle("init", func(maelstrom.Message) error {
+			go transfer.Bootstrap(context.Background())
+			return nil
+		})
+		n.Handle("add", func(msg maelstrom.Message) error {
+			var body struct {
+				Value int `json:"value"`
+			}
+			if err := json.Unmarshal(msg.Body, &body); err != nil {
+				return err
+			}
+			mu.Lock()
+			set[body.Value] = true
+			mu.Unlock()
+			return n.Reply(msg, map[string]any{"type": "add_ok"})
+		})
+		n.Handle("read", func(msg maelstrom.Message) error {
+			mu.Lock()
+			values := make([]int, 0, len(set))
+			for v := range set {
+				values = append(values, v)
+			}
+			mu.Unlock()
+			slices.Sort(values)
+			return n.Reply(msg, map[string]any{"type": "read_ok", "values": values})
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: nodes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Stop() })
+	return c, &served
+}
+
+func read(t *testing.T, c *harness.Cluster, node string) []int {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	resp, err := c.RPC(ctx, "c1", node, map[string]any{"type": "read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body struct {
+		Values []int `json:"values"`
+	}
+	if err := json.Unmarshal(resp.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body.Values
+}
+
+func TestFreshClusterCopiesNothing(t *testing.T) {
+	_, served := startCluster(t, 5)
+	time.Sleep(2 * testLateAfter)
+	if got := served.Load(); got != 0 {
+		t.Fatalf("a fresh cluster served %d snapshots", got)
+	}
+}
+
+func TestLateJoinerCatchesUp(t *testing.T) {
+	c, served := startCluster(t, 3)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	want := []int{}
+	for v := range 20 {
+		for _, id := range []string{"n0", "n1"} {
+			if _, err := c.RPC(ctx, "c1", id, map[string]any{"type": "add", "value": v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want = append(want, v)
+	}
+	time.Sleep(2 * testLateAfter)
+
+	// n2 restarts with nothing, mid-run: it copies one of the others.
+	if err := c.Restart(ctx, "n2"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for !slices.Equal(read(t, c, "n2"), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("n2 has %v after joining, want %v", read(t, c, "n2"), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := served.Load(); got != 1 {
+		t.Fatalf("served %d snapshots to the late joiner, want 1", got)
+	}
+}
@@ -0,0 +1,251 @@
+// Package vclock implements vector clocks and dotted version vectors keyed by
+// Maelstrom node IDs.
+package vclock
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Ordering is the causal relationship between two clocks.
+type Ordering int
+
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+func (o Ordering) String() string {
+	switch o {
+	case Equal:
+		return "equal"
+	case Before:
+		return "before"
+	case After:
+		return "after"
+	default:
+		return "concurrent"
+	}
+}
+
+// Clock is a vector clock mapping node IDs to event counters. Missing entries
+// are treated as zero. The zero value is not usable; use New or make.
+type Clock map[string]uint64
+
+// New returns an empty clock.
+func New() Clock {
+	return make(Clock)
+}
+
+// Tick records a new event on node and returns its counter.
+func (c Clock) Tick(node string) uint64 {
+	c[node]++
+	return c[node]
+}
+
+// Get returns the counter for node.
+func (c Clock) Get(node string) uint64 {
+	return c[node]
+}
+
+// Copy returns an independent copy of the clock.
+func (c Clock) Copy() Clock {
+	out := make(Clock, len(c))
+	maps.Copy(out, c)
+	return out
+}
+
+// Merge sets every entry of c to the maximum of itself and o.
+func (c Clock) Merge(o Clock) {
+	for node, v := range o {
+		if v > c[node] {
+			c[node] = v
+		}
+	}
+}
+
+// Compare returns the causal ordering of c relative to o.
+func (c Clock) Compare(o Clock) Ordering {
+	less, greater := false, false
+	for node, v := range c {
+		if v > o[node] {
+			greater = true
+		} else if v < o[node] {
+			less = true
+		}
+	}
+	for node, v := range o {
+		if _, ok := c[node]; !ok && v > 0 {
+			less = true
+		}
+	}
+
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+// Descends reports whether c has seen every event in o.
+func (c Clock) Descends(o Clock) bool {
+	ord := c.Compare(o)
+	return ord == Equal || ord == After
+}
+
+// Prune drops the entries of nodes that are not in members, e.g. nodes that
+// have left the cluster, and any zero entries.
+func (c Clock) Prune(members []string) {
+	for node, v := range c {
+		if v == 0 || !slices.Contains(members, node) {
+			delete(c, node)
+		}
+	}
+}
+
+// MarshalJSON encodes the clock as an object, omitting zero entries.
+func (c Clock) MarshalJSON() ([]byte, error) {
+	m := make(map[string]uint64, len(c))
+	for node, v := range c {
+		if v > 0 {
+			m[node] = v
+		}
+	}
+	return json.Marshal(m)
+}
+
+// Dot identifies a single event: the counter'th event on Node.
+type Dot struct {
+	Node    string
+	Counter uint64
+}
+
+// IsZero reports whether the dot is unset.
+func (d Dot) IsZero() bool { return d.Counter == 0 }
+
+// MarshalJSON encodes the dot as [node, counter].
+func (d Dot) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]any{d.Node, d.Counter})
+}
+
+// UnmarshalJSON decodes a dot encoded by MarshalJSON.
+func (d *Dot) UnmarshalJSON(data []byte) error {
+	var v []json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v) != 2 {
+		return fmt.Errorf("vclock: dot must have 2 elements, got %d", len(v))
+	}
+	if err := json.Unmarshal(v[0], &d.Node); err != nil {
+		return err
+	}
+	return json.Unmarshal(v[1], &d.Counter)
+}
+
+// DVV is a dotted version vector: the dot of the write that produced a value
+// plus the causal context the writer had seen.
+type DVV struct {
+	Dot     Dot
+	Context Clock
+}
+
+// Event returns the version for a new write coordinated by node. ctx is the
+// context supplied by the client and current is the join of every version
+// already stored at node for the key.
+func Event(node string, ctx, current Clock) DVV {
+	counter := max(ctx[node], current[node]) + 1
+	return DVV{Dot: Dot{Node: node, Counter: counter}, Context: ctx.Copy()}
+}
+
+// Clock returns the version's context with its dot folded in.
+func (d DVV) Clock() Clock {
+	c := d.Context.Copy()
+	if !d.Dot.IsZero() && d.Dot.Counter > c[d.Dot.Node] {
+		c[d.Dot.Node] = d.Dot.Counter
+	}
+	return c
+}
+
+// Obsoletes reports whether d has seen the write that produced o. Only d's
+// context counts: its dot is a single event, and does not imply that d saw
+// the earlier events of its node.
+func (d DVV) Obsoletes(o DVV) bool {
+	if o.Dot.IsZero() {
+		return o.Context.Compare(d.Clock()) == Before
+	}
+	return o.Dot.Counter <= d.Context[o.Dot.Node]
+}
+
+// MarshalJSON encodes the version as [dot, context].
+func (d DVV) MarshalJSON() ([]byte, error) {
+	ctx := d.Context
+	if ctx == nil {
+		ctx = New()
+	}
+	return json.Marshal([]any{d.Dot, ctx})
+}
+
+// UnmarshalJSON decodes a version encoded by MarshalJSON.
+func (d *DVV) UnmarshalJSON(data []byte) error {
+	var v []json.RawMessage
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if len(v) != 2 {
+		return fmt.Errorf("vclock: version must have 2 elements, got %d", len(v))
+	}
+	if err := json.Unmarshal(v[0], &d.Dot); err != nil {
+		return err
+	}
+	d.Context = New()
+	return json.Unmarshal(v[1], &d.Context)
+}
+
+// Versioned is a value tagged with the version that wrote it.
+type Versioned[T any] struct {
+	Version DVV `json:"version"`
+	Value   T   `json:"value"`
+}
+
+// Sync merges two sets of sibling values for the same key, dropping every
+// value that is obsoleted by another one. The result holds the concurrent
+// values of a multi-value register.
+func Sync[T any](a, b []Versioned[T]) []Versioned[T] {
+	all := append(slices.Clone(a), b...)
+	var out []Versioned[T]
+	for i, v := range all {
+		keep := true
+		for j, w := range all {
+			if i == j {
+				continue
+			}
+			if w.Version.Obsoletes(v.Version) || (j < i && w.Version.Dot == v.Version.Dot && !v.Version.Dot.IsZero()) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// Join returns the causal context covering every sibling, which clients pass
+// back on their next write to the key.
+func Join[T any](vs []Versioned[T]) Clock {
+	c := New()
+	for _, v := range vs {
+		c.Merge(v.Version.Clock())
+	}
+	return c
+}
@@ -0,0 +1,160 @@
+package vclock
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b Clock
+		want Ordering
+	}{
+		{Clock{}, Clock{}, Equal},
+		{Clock{"n0": 0}, Clock{}, Equal},
+		{Clock{"n0": 1}, Clock{"n0": 1}, Equal},
+		{Clock{"n0": 1}, Clock{"n0": 2}, Before},
+		{Clock{}, Clock{"n1": 1}, Before},
+		{Clock{"n0": 2, "n1": 1}, Clock{"n0": 1}, After},
+		{Clock{"n0": 2}, Clock{"n0": 1, "n1": 1}, Concurrent},
+		{Clock{"n0": 1, "n1": 0}, Clock{"n1": 1}, Concurrent},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Compare(tt.b); got != tt.want {
+			t.Errorf("%v.Compare(%v) = %s, want %s", tt.a, tt.b, got, tt.want)
+		}
+		if got, want := tt.b.Compare(tt.a), mirror(tt.want); got != want {
+			t.Errorf("%v.Compare(%v) = %s, want %s", tt.b, tt.a, got, want)
+		}
+	}
+}
+
+func mirror(o Ordering) Ordering {
+	switch o {
+	case Before:
+		return After
+	case After:
+		return Before
+	}
+	return o
+}
+
+func TestMerge(t *testing.T) {
+	a := Clock{"n0": 3, "n1": 1}
+	b := Clock{"n1": 4, "n2": 2}
+	a.Merge(b)
+	want := Clock{"n0": 3, "n1": 4, "n2": 2}
+	if a.Compare(want) != Equal {
+		t.Fatalf("merged %v, want %v", a, want)
+	}
+	if !a.Descends(b) {
+		t.Fatalf("%v does not descend from %v after merging it", a, b)
+	}
+	if b.Compare(Clock{"n1": 4, "n2": 2}) != Equal {
+		t.Fatalf("merge modified its argument: %v", b)
+	}
+
+	c := a.Copy()
+	c.Tick("n0")
+	if a.Get("n0") != 3 {
+		t.Fatalf("ticking a copy changed the original to %v", a)
+	}
+	c.Prune([]string{"n0", "n2"})
+	if _, ok := c["n1"]; ok || c.Get("n0") != 4 {
+		t.Fatalf("pruned to %v", c)
+	}
+}
+
+// put makes a write on node to a key holding stored, with the context the
+// client read, and returns the key's new siblings there.
+func put(node string, ctx Clock, stored []Versioned[string], value string) ([]Versioned[string], Versioned[string]) {
+	v := Versioned[string]{Version: Event(node, ctx, Join(stored)), Value: value}
+	return Sync(stored, []Versioned[string]{v}), v
+}
+
+func values(vs []Versioned[string]) []string {
+	var out []string
+	for _, v := range vs {
+		out = append(out, v.Value)
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestSiblings(t *testing.T) {
+	// Two nodes write the same key without seeing each other's write.
+	n0, a := put("n0", New(), nil, "a")
+	n1, b := put("n1", New(), nil, "b")
+	if a.Version.Obsoletes(b.Version) || b.Version.Obsoletes(a.Version) {
+		t.Fatalf("concurrent writes %v and %v obsolete each other", a.Version, b.Version)
+	}
+
+	// Once they exchange them, both keep both.
+	n0 = Sync(n0, []Versioned[string]{b})
+	n1 = Sync(n1, []Versioned[string]{a})
+	if got := values(n0); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("n0 holds %v, want both siblings", got)
+	}
+	if got := values(n1); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("n1 holds %v, want both siblings", got)
+	}
+
+	// Delivering a write again does not duplicate it.
+	if got := values(Sync(n0, []Versioned[string]{a, b})); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("redelivery left %v", got)
+	}
+
+	// A write made with the context of both replaces them, wherever it
+	// lands and in whichever order.
+	n0, c := put("n0", Join(n0), n0, "c")
+	if got := values(n0); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("n0 holds %v after overwriting both siblings", got)
+	}
+	if got := values(Sync([]Versioned[string]{c}, n1)); !slices.Equal(got, []string{"c"}) {
+		t.Fatalf("n1 holds %v after receiving the overwrite", got)
+	}
+
+	// A client writing with a stale context leaves a sibling beside the
+	// write it had not seen.
+	n0, _ = put("n0", a.Version.Clock(), n0, "d")
+	if got := values(n0); !slices.Equal(got, []string{"c", "d"}) {
+		t.Fatalf("n0 holds %v after a write with a stale context", got)
+	}
+}
+
+func TestEventCounters(t *testing.T) {
+	// The counter follows both what the client saw and what the node
+	// stored, so a dot is never reused.
+	v := Event("n0", Clock{"n0": 2}, Clock{"n0": 5, "n1": 1})
+	if v.Dot != (Dot{"n0", 6}) {
+		t.Fatalf("dot = %v, want n0:6", v.Dot)
+	}
+	if v.Context.Compare(Clock{"n0": 2}) != Equal {
+		t.Fatalf("context = %v, want the client's", v.Context)
+	}
+	if got := v.Clock(); got.Compare(Clock{"n0": 6}) != Equal {
+		t.Fatalf("clock = %v, want n0:6", got)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	in := Versioned[int]{Version: DVV{Dot: Dot{"n1", 3}, Context: Clock{"n0": 2, "n2": 0}}, Value: 7}
+	buf, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"version":[["n1",3],{"n0":2}],"value":7}`; string(buf) != want {
+		t.Fatalf("encoded as %s, want %s", buf, want)
+	}
+	var out Versioned[int]
+	if err := json.Unmarshal(buf, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Version.Dot != in.Version.Dot || out.Version.Context.Compare(in.Version.Context) != Equal || out.Value != 7 {
+		t.Fatalf("decoded %+v, want %+v", out, in)
+	}
+	if err := json.Unmarshal([]byte(`{"version":[["n1",3]],"value":7}`), &out); err == nil {
+		t.Fatal("decoded a version without a context")
+	}
+}
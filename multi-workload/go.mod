@@ -0,0 +1,19 @@
+module maelstrom-multi
+
+go 1.24.1
+
+require (
+	maelstrom-counter v0.0.0-00010101000000-000000000000
+	maelstrom-kafka v0.0.0-00010101000000-000000000000
+	maelstrom-lib v0.0.0-00010101000000-000000000000
+	maelstron-broadcast v0.0.0-00010101000000-000000000000
+)
+
+require github.com/jepsen-io/maelstrom/demo/go v0.0.0-20250204203845-8263d1dd2b7a // indirect
+
+replace (
+	maelstrom-counter => ../g-counter
+	maelstrom-kafka => ../kafka/multi-node
+	maelstrom-lib => ../lib
+	maelstron-broadcast => ../broadcast/efficient-broadcast
+)
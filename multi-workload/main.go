@@ -0,0 +1,30 @@
+// Command maelstrom-multi serves several workloads from one node process so
+// that a single cluster can back all of our demo services. Requests carry
+// namespaced types such as "kafka.send", "counter.add" or "broadcast.read".
+// Each workload runs the same handlers, schema validation included, as its
+// own node binary, and keeps its own state.
+package main
+
+import (
+	"log"
+	"os"
+
+	"maelstrom-counter/counter"
+	"maelstrom-kafka/kafka"
+	"maelstrom-lib/mux"
+	"maelstron-broadcast/broadcast"
+)
+
+func main() {
+	host := mux.New()
+
+	kafka.Register(host.Workload("kafka"))
+	counter.Register(host.Workload("counter"))
+	broadcast.Register(host.Workload("broadcast"))
+
+	// Route messages until STDIN is closed.
+	if err := host.Run(); err != nil {
+		log.Printf("ERROR: %s", err)
+		os.Exit(1)
+	}
+}
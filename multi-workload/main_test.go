@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"maelstrom-kafka/kafka"
+	"maelstrom-lib/hlc"
+	"maelstrom-lib/mux"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+func TestKafkaMessagesCarryHLC(t *testing.T) {
+	log.SetOutput(io.Discard) // the nodes log every message
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	host := mux.New()
+	host.Stdin, host.Stdout = inR, outW
+	kafka.Register(host.Workload("kafka"))
+	done := make(chan error, 1)
+	go func() { done <- host.Run() }()
+
+	lines := make(chan maelstrom.Message, 16)
+	go func() {
+		scanner := bufio.NewScanner(outR)
+		for scanner.Scan() {
+			var msg maelstrom.Message
+			if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+				continue
+			}
+			select {
+			case lines <- msg:
+			default: // keep the host writing once the test stops reading
+			}
+		}
+	}()
+	deliver := func(line string) {
+		if _, err := io.WriteString(inW, line+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// n0 has not won the election, so it turns down the forwarded append
+	// straight away. The error goes to another node and must be stamped.
+	deliver(`{"src":"c0","dest":"n0","body":{"type":"init","msg_id":1,"node_id":"n0","node_ids":["n0","n1"]}}`)
+	deliver(`{"src":"n1","dest":"n0","body":{"type":"kafka.append","msg_id":1,"key":"k","msg":1}}`)
+	timeout := time.After(5 * time.Second)
+	for {
+		var msg maelstrom.Message
+		select {
+		case msg = <-lines:
+		case <-timeout:
+			t.Fatal("no reply to n1")
+		}
+		if msg.Dest != "n1" {
+			continue // init_ok, and the elector's lin-kv requests
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := body[hlc.Field]; !ok {
+			t.Fatalf("kafka's message to n1 has no %q field: %s", hlc.Field, msg.Body)
+		}
+		break
+	}
+
+	inW.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the host did not stop")
+	}
+}
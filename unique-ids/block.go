@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+)
+
+// HighWaterKey is the lin-kv key holding the global high-water mark: every
+// integer below it has been leased to some node.
+const HighWaterKey = "unique-ids/high-water"
+
+// BlockSize is how many IDs a node leases at a time. Larger blocks mean
+// fewer KV round trips and larger gaps left behind by restarts.
+const BlockSize = 1000
+
+// leaseTimeout bounds each attempt to lease a block.
+const leaseTimeout = time.Second
+
+// BlockLeaser hands out integers from blocks leased by advancing the global
+// high-water mark with a compare-and-swap. A block belongs to whoever
+// advanced the mark, so IDs are never reissued even if a node restarts or
+// comes back under a different node ID; the rest of its block is skipped.
+// IDs are tagged with kindBlock; the high-water mark itself is not.
+type BlockLeaser struct {
+	kv   *rpc.KV
+	size int64
+
+	mu   sync.Mutex
+	next int64 // next ID to hand out
+	end  int64 // end of the current block, exclusive
+}
+
+// NewBlockLeaser returns a leaser that leases size IDs at a time from kv.
+func NewBlockLeaser(kv *rpc.KV, size int64) *BlockLeaser {
+	return &BlockLeaser{kv: kv, size: size}
+}
+
+// Generate implements Generator.
+func (b *BlockLeaser) Generate() (any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.next == b.end {
+		if err := b.lease(b.size); err != nil {
+			return nil, err
+		}
+	}
+	id := b.next
+	b.next++
+	return kindBlock<<kindShift | id, nil
+}
+
+// GenerateBatch implements BatchGenerator. A batch larger than what is left
+// of the current block leases a block big enough for all of it, so batches
+// are contiguous.
+func (b *BlockLeaser) GenerateBatch(count int) ([]any, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.end-b.next < int64(count) {
+		if err := b.lease(max(b.size, int64(count))); err != nil {
+			return nil, err
+		}
+	}
+	ids := make([]any, count)
+	for i := range ids {
+		ids[i] = kindBlock<<kindShift | b.next
+		b.next++
+	}
+	return ids, nil
+}
+
+// lease advances the high-water mark by n and makes the IDs in between the
+// current block. If a CAS times out it may or may not have applied, so the
+// next attempt simply leases afresh; at worst a block is wasted. A reply
+// arriving after its attempt timed out is dropped.
+func (b *BlockLeaser) lease(n int64) error {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), leaseTimeout)
+		hw, err := b.kv.ReadInt(ctx, HighWaterKey)
+		if maelstrom.ErrorCode(err) == maelstrom.KeyDoesNotExist {
+			hw, err = 0, nil
+		}
+		if err == nil {
+			err = b.kv.CompareAndSwap(ctx, HighWaterKey, hw, hw+int(n), true)
+		}
+		cancel()
+
+		switch {
+		case err == nil:
+			b.next, b.end = int64(hw), int64(hw)+n
+			return nil
+		case maelstrom.ErrorCode(err) == maelstrom.PreconditionFailed:
+			continue // another node leased a block first
+		default:
+			return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, fmt.Sprintf("lease ID block: %s", err))
+		}
+	}
+}
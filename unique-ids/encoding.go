@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Alphabets for encoding IDs as digit strings. Each is in ASCII order, so
+// fixed-width encodings sort like the numbers they encode.
+const (
+	// crockford is the Crockford base32 alphabet used by ULIDs.
+	crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	// base62 is the KSUID alphabet.
+	base62   = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	hexLower = "0123456789abcdef"
+)
+
+// encodings maps the names clients use to their alphabets.
+var encodings = map[string]string{
+	"base62": base62,
+	"base32": crockford,
+	"hex":    hexLower,
+}
+
+// Encoding re-encodes IDs as compact strings: an optional prefix such as
+// "ord_", the ID's bytes in a fixed number of digits of the alphabet, and
+// optionally a check digit. The bytes start with a tag for the ID's format
+// (integer, ULID, UUID or KSUID), followed by the ID itself in big-endian
+// order, so encodings of IDs of one format sort the same way the IDs do.
+// Counter IDs have no fixed-width binary form and cannot be encoded.
+type Encoding struct {
+	Name     string
+	Prefix   string
+	Checksum bool
+
+	alphabet string
+}
+
+// Tags for the format of an encoded ID.
+const (
+	tagInt   = 0
+	tagULID  = 1
+	tagUUID  = 2
+	tagKSUID = 3
+)
+
+// tagSizes is the size of the ID behind each tag, in bytes.
+var tagSizes = map[byte]int{tagInt: 8, tagULID: 16, tagUUID: 16, tagKSUID: 20}
+
+// ParseEncoding reads the "encoding", "prefix" and "checksum" fields of a
+// request. Without an encoding it returns nil: IDs are sent as generated.
+func ParseEncoding(body map[string]any) (*Encoding, error) {
+	name, _ := body["encoding"].(string)
+	prefix, _ := body["prefix"].(string)
+	checksum, _ := body["checksum"].(bool)
+	if name == "" {
+		if prefix != "" || checksum {
+			return nil, fmt.Errorf("prefix and checksum require an encoding")
+		}
+		return nil, nil
+	}
+	alphabet, ok := encodings[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %q", name)
+	}
+	return &Encoding{Name: name, Prefix: prefix, Checksum: checksum, alphabet: alphabet}, nil
+}
+
+// Encode encodes an ID as generated: an int64 or a ULID, UUID or KSUID
+// string.
+func (e *Encoding) Encode(id any) (string, error) {
+	payload, err := idBytes(id)
+	if err != nil {
+		return "", err
+	}
+	digits := encodeDigits(payload, e.alphabet, digitWidth(len(payload), e.alphabet))
+	if e.Checksum {
+		digits += string(checkDigit(digits, e.alphabet))
+	}
+	return e.Prefix + digits, nil
+}
+
+// Decode returns the ID behind an encoded string in its original form, with
+// integers as json.Number so that they survive as exact int64s.
+func (e *Encoding) Decode(s string) (any, error) {
+	digits, ok := strings.CutPrefix(s, e.Prefix)
+	if !ok {
+		return nil, fmt.Errorf("%q does not start with prefix %q", s, e.Prefix)
+	}
+	if !validDigits(digits, e.alphabet) {
+		return nil, fmt.Errorf("%q is not %s", s, e.Name)
+	}
+	if e.Checksum {
+		if digits == "" || checkDigit(digits[:len(digits)-1], e.alphabet) != digits[len(digits)-1] {
+			return nil, fmt.Errorf("%q fails its checksum", s)
+		}
+		digits = digits[:len(digits)-1]
+	}
+
+	for tag, size := range tagSizes {
+		if digitWidth(1+size, e.alphabet) != len(digits) {
+			continue
+		}
+		payload, ok := decodeDigits(digits, e.alphabet, 1+size)
+		if !ok || payload[0] != tag {
+			continue
+		}
+		raw := payload[1:]
+		switch tag {
+		case tagInt:
+			return json.Number(strconv.FormatInt(int64(binary.BigEndian.Uint64(raw)), 10)), nil
+		case tagULID:
+			return encodeDigits(raw, crockford, 26), nil
+		case tagUUID:
+			return formatUUID([16]byte(raw)), nil
+		case tagKSUID:
+			return encodeDigits(raw, base62, 27), nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not an encoded unique-ids ID", s)
+}
+
+// idBytes returns the tag and bytes of an ID.
+func idBytes(id any) ([]byte, error) {
+	switch id := id.(type) {
+	case int64:
+		return binary.BigEndian.AppendUint64([]byte{tagInt}, uint64(id)), nil
+	case string:
+		var d Description
+		if err := inspectString(&d, id); err != nil {
+			return nil, err
+		}
+		switch d.Strategy {
+		case "ulid":
+			b, _ := decodeDigits(id, crockford, 16)
+			return append([]byte{tagULID}, b...), nil
+		case "uuidv7":
+			b, _ := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+			return append([]byte{tagUUID}, b...), nil
+		case "ksuid":
+			b, _ := decodeDigits(id, base62, 20)
+			return append([]byte{tagKSUID}, b...), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot encode ID %v: only integer, ULID, UUID and KSUID IDs have a fixed-width form", id)
+}
+
+// digitWidth is the number of digits needed for any size-byte number.
+func digitWidth(size int, alphabet string) int {
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(size*8))
+	n := big.NewInt(1)
+	base := big.NewInt(int64(len(alphabet)))
+	width := 0
+	for n.Cmp(limit) < 0 {
+		n.Mul(n, base)
+		width++
+	}
+	return width
+}
+
+// checkDigit computes a Luhn mod N check digit over digits, which catches
+// any single mistyped digit and most swaps of adjacent digits.
+func checkDigit(digits, alphabet string) byte {
+	n := len(alphabet)
+	sum, factor := 0, 2
+	for i := len(digits) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, digits[i])
+		sum += addend/n + addend%n
+		factor = 3 - factor
+	}
+	return alphabet[(n-sum%n)%n]
+}
+
+// validDigits reports whether every byte of s is in alphabet.
+func validDigits(s, alphabet string) bool {
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(alphabet, s[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeDigits encodes b as a big-endian number in width digits of
+// alphabet, padding with leading zeros.
+func encodeDigits(b []byte, alphabet string, width int) string {
+	n := new(big.Int).SetBytes(b)
+	out := make([]byte, width)
+	mod := new(big.Int)
+	base := big.NewInt(int64(len(alphabet)))
+	for i := width - 1; i >= 0; i-- {
+		n.DivMod(n, base, mod)
+		out[i] = alphabet[mod.Int64()]
+	}
+	return string(out)
+}
+
+// decodeDigits is the inverse of encodeDigits, returning size bytes, and
+// reports false if the number does not fit. s must only contain digits of
+// alphabet.
+func decodeDigits(s, alphabet string, size int) ([]byte, bool) {
+	n := new(big.Int)
+	base := big.NewInt(int64(len(alphabet)))
+	for i := 0; i < len(s); i++ {
+		n.Mul(n, base)
+		n.Add(n, big.NewInt(int64(strings.IndexByte(alphabet, s[i]))))
+	}
+	if n.BitLen() > size*8 {
+		return nil, false
+	}
+	return n.FillBytes(make([]byte, size)), true
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// allEncodings returns every encoding with and without a prefix and a
+// check digit.
+func allEncodings(t *testing.T) []*Encoding {
+	t.Helper()
+	var encs []*Encoding
+	for name := range encodings {
+		for _, prefix := range []string{"", "ord_"} {
+			for _, checksum := range []bool{false, true} {
+				enc, err := ParseEncoding(map[string]any{"encoding": name, "prefix": prefix, "checksum": checksum})
+				if err != nil {
+					t.Fatal(err)
+				}
+				encs = append(encs, enc)
+			}
+		}
+	}
+	return encs
+}
+
+// encName describes an encoding in failure messages.
+func encName(e *Encoding) string {
+	return e.Name + "/" + e.Prefix + "/" + strconv.FormatBool(e.Checksum)
+}
+
+// generateAt returns the first ID gen issues with the clock at t.
+func generateAt(t *testing.T, newGen func(now func() time.Time) Generator, at time.Time) any {
+	t.Helper()
+	id, err := newGen(func() time.Time { return at }).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func newULID(now func() time.Time) Generator   { return NewULID(now) }
+func newUUIDv7(now func() time.Time) Generator { return NewUUIDv7(now) }
+func newKSUID(now func() time.Time) Generator  { return NewKSUID(now) }
+
+// boundaryIDs returns IDs of every fixed-width format at the first and last
+// timestamps each can hold, with the time they should decode to.
+func boundaryIDs(t *testing.T) map[any]time.Time {
+	t.Helper()
+	maxMillis := time.UnixMilli(1<<48 - 1)
+	ksuidFirst := time.Unix(ksuidEpoch, 0)
+	ksuidLast := time.Unix(ksuidEpoch+math.MaxUint32, 0)
+	return map[any]time.Time{
+		generateAt(t, newULID, time.UnixMilli(0)):   time.UnixMilli(0),
+		generateAt(t, newULID, maxMillis):           maxMillis,
+		generateAt(t, newUUIDv7, time.UnixMilli(0)): time.UnixMilli(0),
+		generateAt(t, newUUIDv7, maxMillis):         maxMillis,
+		generateAt(t, newKSUID, ksuidFirst):         ksuidFirst,
+		generateAt(t, newKSUID, ksuidLast):          ksuidLast,
+	}
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	ids := []any{
+		int64(0), int64(1), int64(math.MaxInt64),
+		int64(kindSnowflake<<kindShift | 42), int64(kindBlock<<kindShift | 1000),
+	}
+	boundaries := boundaryIDs(t)
+	for id, at := range boundaries {
+		d, err := Inspect(id, nil)
+		if err != nil {
+			t.Fatalf("Inspect(%v): %s", id, err)
+		}
+		if !d.Time.Equal(at) {
+			t.Errorf("%s %v decodes to time %s, want %s", d.Strategy, id, d.Time, at)
+		}
+		ids = append(ids, id)
+	}
+
+	for _, enc := range allEncodings(t) {
+		for _, id := range ids {
+			s, err := enc.Encode(id)
+			if err != nil {
+				t.Fatalf("%s: Encode(%v): %s", encName(enc), id, err)
+			}
+			if !strings.HasPrefix(s, enc.Prefix) {
+				t.Errorf("%s: %v encoded as %q, without the prefix", encName(enc), id, s)
+			}
+			got, err := enc.Decode(s)
+			if err != nil {
+				t.Fatalf("%s: Decode(%q) of %v: %s", encName(enc), s, id, err)
+			}
+			want := id
+			if n, ok := id.(int64); ok {
+				want = json.Number(strconv.FormatInt(n, 10))
+			}
+			if got != want {
+				t.Errorf("%s: %v encoded as %q decodes to %v", encName(enc), id, s, got)
+			}
+		}
+	}
+}
+
+func TestEncodingKeepsOrder(t *testing.T) {
+	start := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	now := start.Add(-time.Second)
+	clock := func() time.Time { return now }
+	snowflake, err := NewSnowflake(3, clock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gens := map[string]Generator{"snowflake": snowflake, "ulid": NewULID(clock), "uuidv7": NewUUIDv7(clock), "ksuid": NewKSUID(clock)}
+
+	for strategy, gen := range gens {
+		var ids []any
+		for i := range 300 {
+			// Several IDs per tick, and ticks far apart.
+			now = start.Add(time.Duration(i/3) * 1500 * time.Millisecond)
+			id, err := gen.Generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, id)
+		}
+		for _, enc := range allEncodings(t) {
+			var encoded []string
+			for _, id := range ids {
+				s, err := enc.Encode(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				encoded = append(encoded, s)
+			}
+			if !slices.IsSorted(encoded) {
+				t.Errorf("%s: %s encodings are out of order", encName(enc), strategy)
+			}
+		}
+	}
+}
+
+func TestChecksumCatchesCorruption(t *testing.T) {
+	ids := []any{int64(kindSnowflake<<kindShift | 123456789)}
+	for id := range boundaryIDs(t) {
+		ids = append(ids, id)
+	}
+	for _, enc := range allEncodings(t) {
+		if !enc.Checksum {
+			continue
+		}
+		for _, id := range ids {
+			s, err := enc.Encode(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Every single-character substitution, including of the check
+			// character itself, is rejected.
+			for i := len(enc.Prefix); i < len(s); i++ {
+				for _, c := range []byte(enc.alphabet) {
+					if c == s[i] {
+						continue
+					}
+					bad := s[:i] + string(c) + s[i+1:]
+					if got, err := enc.Decode(bad); err == nil {
+						t.Fatalf("%s: %q, corrupted from %q, decodes to %v", encName(enc), bad, s, got)
+					}
+				}
+			}
+			if _, err := enc.Decode(s[:len(s)-1]); err == nil {
+				t.Errorf("%s: %q without its check character decodes", encName(enc), s)
+			}
+		}
+	}
+}
+
+func TestEncodingRejects(t *testing.T) {
+	enc, err := ParseEncoding(map[string]any{"encoding": "base32", "prefix": "ord_"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := enc.Encode(int64(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []string{
+		strings.TrimPrefix(s, "ord_"),          // missing prefix
+		"inv_" + strings.TrimPrefix(s, "ord_"), // wrong prefix
+		s[:len(s)-1] + "U",                     // not in the alphabet
+		s + "0",                                // wrong width
+	} {
+		if got, err := enc.Decode(bad); err == nil {
+			t.Errorf("Decode(%q) = %v", bad, got)
+		}
+	}
+	if _, err := enc.Encode("n1-42"); err == nil {
+		t.Error("encoded a counter ID")
+	}
+	for _, body := range []map[string]any{
+		{"encoding": "base64"},
+		{"prefix": "ord_"},
+		{"checksum": true},
+	} {
+		if _, err := ParseEncoding(body); err == nil {
+			t.Errorf("ParseEncoding(%v) succeeded", body)
+		}
+	}
+}
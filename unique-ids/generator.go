@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/rpc"
+)
+
+// StrategyEnv names the environment variable that selects the default ID
+// strategy. Unset, nodes generate Snowflake IDs.
+const StrategyEnv = "UNIQUE_IDS_STRATEGY"
+
+// Generator produces IDs that are unique across the cluster. IDs are either
+// int64s or strings, depending on the strategy.
+type Generator interface {
+	Generate() (any, error)
+}
+
+// BatchGenerator is implemented by generators that can allocate many IDs
+// more cheaply than one at a time.
+type BatchGenerator interface {
+	GenerateBatch(count int) ([]any, error)
+}
+
+// MaxBatch is the largest number of IDs one generate_batch request may ask
+// for.
+const MaxBatch = 10000
+
+// GenerateBatch returns count IDs from gen in increasing order.
+func GenerateBatch(gen Generator, count int) ([]any, error) {
+	if b, ok := gen.(BatchGenerator); ok {
+		return b.GenerateBatch(count)
+	}
+	ids := make([]any, 0, count)
+	for range count {
+		id, err := gen.Generate()
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// strategies constructs a generator per strategy name for an initialized
+// node. Time-ordered generators read the time from now.
+var strategies = map[string]func(n *maelstrom.Node, now func() time.Time) (Generator, error){
+	// "n1-42": node ID and a counter. Compact, but not time-ordered and
+	// reissues IDs if the node restarts.
+	"counter": func(n *maelstrom.Node, _ func() time.Time) (Generator, error) {
+		return &Counter{prefix: n.ID() + "-"}, nil
+	},
+	// Integers from blocks leased through lin-kv; never reissued, even
+	// across restarts, but only ordered within a node.
+	"block": func(n *maelstrom.Node, _ func() time.Time) (Generator, error) {
+		return NewBlockLeaser(rpc.NewLinKV(n), BlockSize), nil
+	},
+	// 64-bit integers ordered by millisecond; see Snowflake.
+	"snowflake": func(n *maelstrom.Node, now func() time.Time) (Generator, error) {
+		return NewSnowflake(slices.Index(n.NodeIDs(), n.ID()), now)
+	},
+	// 26-character Crockford base32 strings: 48-bit ms timestamp and 80
+	// random bits, incremented within a millisecond.
+	"ulid": func(_ *maelstrom.Node, now func() time.Time) (Generator, error) {
+		return NewULID(now), nil
+	},
+	// RFC 9562 version 7 UUIDs with a 12-bit counter in rand_a.
+	"uuidv7": func(_ *maelstrom.Node, now func() time.Time) (Generator, error) {
+		return NewUUIDv7(now), nil
+	},
+	// 27-character base62 strings: 32-bit second timestamp and 128 random
+	// bits, incremented within a second.
+	"ksuid": func(_ *maelstrom.Node, now func() time.Time) (Generator, error) {
+		return NewKSUID(now), nil
+	},
+}
+
+// StrategyNames lists the available strategies in order.
+func StrategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Generators lazily creates one generator per strategy. Generators need
+// the node's ID and index, which are only known after init. Looking up a
+// generator that exists takes no lock.
+type Generators struct {
+	node *maelstrom.Node
+	now  func() time.Time
+	gens sync.Map // strategy name -> Generator
+
+	mu sync.Mutex // held while creating generators
+}
+
+// NewGenerators returns the generators for n, reading the time from now.
+func NewGenerators(n *maelstrom.Node, now func() time.Time) *Generators {
+	return &Generators{node: n, now: now}
+}
+
+// Get returns the generator for strategy, creating it on first use.
+func (g *Generators) Get(strategy string) (Generator, error) {
+	if gen, ok := g.gens.Load(strategy); ok {
+		return gen.(Generator), nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if gen, ok := g.gens.Load(strategy); ok {
+		return gen.(Generator), nil
+	}
+	if g.node.ID() == "" {
+		return nil, maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, "node not initialized")
+	}
+	newGen, ok := strategies[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown ID strategy %q", strategy)
+	}
+	gen, err := newGen(g.node, g.now)
+	if err != nil {
+		return nil, err
+	}
+	g.gens.Store(strategy, gen)
+	return gen, nil
+}
+
+// Counter issues the node's prefix followed by increasing integers,
+// allocated with a single atomic add.
+type Counter struct {
+	prefix string
+	next   atomic.Int64
+}
+
+func (c *Counter) Generate() (any, error) {
+	return c.prefix + strconv.FormatInt(c.next.Add(1)-1, 10), nil
+}
+
+// GenerateBatch reserves a contiguous range of counter values at once.
+func (c *Counter) GenerateBatch(count int) ([]any, error) {
+	end := c.next.Add(int64(count))
+	ids := make([]any, 0, count)
+	for i := end - int64(count); i < end; i++ {
+		ids = append(ids, c.prefix+strconv.FormatInt(i, 10))
+	}
+	return ids, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+var testNodeIDs = []string{"n0", "n1", "n2"}
+
+// initNode returns a node initialized as id, as after the init message.
+func initNode(id string) *maelstrom.Node {
+	n := maelstrom.NewNode()
+	n.Init(id, testNodeIDs)
+	return n
+}
+
+// generate calls gens concurrently from workers goroutines, as concurrent
+// handlers do, and returns every ID issued.
+func generate(t *testing.T, gens *Generators, strategy string, workers, each int) []any {
+	t.Helper()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		ids  []any
+		errs []error
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range each {
+				gen, err := gens.Get(strategy)
+				var id any
+				if err == nil {
+					id, err = gen.Generate()
+				}
+				mu.Lock()
+				if err != nil {
+					errs = append(errs, err)
+				} else {
+					ids = append(ids, id)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		t.Fatalf("%s: %d of %d calls failed, first: %s", strategy, len(errs), workers*each, errs[0])
+	}
+	return ids
+}
+
+// checkUnique fails the test if any ID appears twice.
+func checkUnique(t *testing.T, strategy string, ids []any) {
+	t.Helper()
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		key := fmt.Sprint(id)
+		if seen[key] {
+			t.Fatalf("%s issued %v twice", strategy, id)
+		}
+		seen[key] = true
+	}
+}
+
+// localStrategies are the strategies that need no KV service.
+var localStrategies = []string{"counter", "snowflake", "ulid", "uuidv7", "ksuid"}
+
+func TestConcurrentHandlersAcrossNodes(t *testing.T) {
+	for _, strategy := range localStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			var ids []any
+			for _, id := range testNodeIDs {
+				gens := NewGenerators(initNode(id), time.Now)
+				ids = append(ids, generate(t, gens, strategy, 16, 500)...)
+			}
+			checkUnique(t, strategy, ids)
+		})
+	}
+}
+
+func TestRestartWithSameNodeID(t *testing.T) {
+	// Counter IDs restart from zero by design; the other local strategies
+	// must not reissue IDs when a node comes back under the same ID, even
+	// straight away.
+	for _, strategy := range localStrategies {
+		if strategy == "counter" {
+			continue
+		}
+		t.Run(strategy, func(t *testing.T) {
+			var ids []any
+			for range 5 {
+				gens := NewGenerators(initNode("n1"), time.Now)
+				ids = append(ids, generate(t, gens, strategy, 4, 100)...)
+			}
+			checkUnique(t, strategy, ids)
+		})
+	}
+}
+
+func TestBlockRestartWithSameNodeID(t *testing.T) {
+	// Blocks are leased through lin-kv, so a restarted node skips what is
+	// left of its old block rather than reissuing it.
+	setup := func(n *maelstrom.Node) {
+		gens := NewGenerators(n, time.Now)
+		n.Handle("generate_batch", func(msg maelstrom.Message) error {
+			gen, err := gens.Get("block")
+			if err != nil {
+				return err
+			}
+			ids, err := GenerateBatch(gen, 300)
+			if err != nil {
+				return err
+			}
+			// Node.Reply rounds 64-bit integers through float64.
+			exact := make([]string, len(ids))
+			for i, id := range ids {
+				exact[i] = fmt.Sprint(id)
+			}
+			return n.Reply(msg, map[string]any{"type": "generate_batch_ok", "ids": exact})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	var ids []any
+	for round := range 3 {
+		for range 5 {
+			for _, id := range c.Alive() {
+				resp, err := c.RPC(ctx, "c1", id, map[string]any{"type": "generate_batch"})
+				if err != nil {
+					t.Fatal(err)
+				}
+				var body struct {
+					IDs []string `json:"ids"`
+				}
+				if err := json.Unmarshal(resp.Body, &body); err != nil {
+					t.Fatal(err)
+				}
+				for _, id := range body.IDs {
+					ids = append(ids, id)
+				}
+			}
+		}
+		if round < 2 {
+			if err := c.Restart(ctx, "n0"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	checkUnique(t, "block", ids)
+}
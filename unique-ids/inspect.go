@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Description is what can be recovered from an ID: always the strategy
+// that generated it and the version of its format, and where the format
+// records them the generating node, timestamp and sequence number.
+type Description struct {
+	Strategy  string
+	Version   int
+	Node      string // node ID, if known
+	NodeIndex int    // position in the node list; -1 if not encoded
+	Time      time.Time
+	Sequence  *int64
+}
+
+// Body returns the description as fields of an inspect_id_ok body.
+func (d Description) Body() map[string]any {
+	body := map[string]any{"strategy": d.Strategy, "version": d.Version}
+	if d.NodeIndex >= 0 {
+		body["node_index"] = d.NodeIndex
+	}
+	if d.Node != "" {
+		body["node"] = d.Node
+	}
+	if !d.Time.IsZero() {
+		body["timestamp"] = d.Time.UnixMilli()
+		body["time"] = d.Time.UTC().Format(time.RFC3339Nano)
+	}
+	if d.Sequence != nil {
+		body["sequence"] = *d.Sequence
+	}
+	return body
+}
+
+// Inspect decodes an ID produced by any strategy. Integers must be passed
+// as json.Number, or they may have been rounded on the way in. nodes is the
+// cluster's node list, used to name the node behind an index.
+func Inspect(id any, nodes []string) (Description, error) {
+	d := Description{NodeIndex: -1}
+	var err error
+	switch id := id.(type) {
+	case json.Number:
+		n, perr := strconv.ParseInt(id.String(), 10, 64)
+		if perr != nil || n < 0 {
+			return d, fmt.Errorf("%s is not a unique-ids integer ID", id)
+		}
+		err = inspectInt(&d, n)
+	case string:
+		err = inspectString(&d, id)
+	default:
+		return d, fmt.Errorf("ID must be an integer or a string, got %T", id)
+	}
+	if err != nil {
+		return d, err
+	}
+	switch {
+	case d.Node == "" && d.NodeIndex >= 0 && d.NodeIndex < len(nodes):
+		d.Node = nodes[d.NodeIndex]
+	case d.Node != "":
+		d.NodeIndex = slices.Index(nodes, d.Node)
+	}
+	return d, nil
+}
+
+// inspectInt decodes integer IDs by their kind bits.
+func inspectInt(d *Description, id int64) error {
+	const (
+		seqMask     = maxSeq
+		nodeMask    = maxNode
+		payloadMask = 1<<kindShift - 1
+	)
+	switch kind := id >> kindShift; kind {
+	case kindSequence:
+		d.Strategy, d.Version = "sequence", 1
+		d.Sequence = &id
+	case kindSnowflake:
+		seq := id & seqMask
+		d.Strategy, d.Version = "snowflake", 1
+		d.NodeIndex = int(id >> seqBits & nodeMask)
+		d.Time = Epoch.Add(time.Duration(id&payloadMask>>(nodeBits+seqBits)) * time.Millisecond)
+		d.Sequence = &seq
+	case kindBlock:
+		// Blocks are leased from a global counter, so the ID is its
+		// position in that counter and nothing else.
+		seq := id & payloadMask
+		d.Strategy, d.Version = "block", 1
+		d.Sequence = &seq
+	default:
+		return fmt.Errorf("%d has unknown ID kind %d", id, kind)
+	}
+	return nil
+}
+
+// inspectString recognizes string IDs by their shape.
+func inspectString(d *Description, id string) error {
+	d.Version = 1
+	switch {
+	case len(id) == 26 && validDigits(id, crockford) && id[0] <= '7':
+		b, _ := decodeDigits(id, crockford, 16)
+		d.Strategy = "ulid"
+		d.Time = time.UnixMilli(int64(binary.BigEndian.Uint64(b[:8]) >> 16))
+	case len(id) == 27 && validDigits(id, base62):
+		b, ok := decodeDigits(id, base62, 20)
+		if !ok {
+			return fmt.Errorf("%q overflows a KSUID", id)
+		}
+		d.Strategy = "ksuid"
+		d.Time = time.Unix(ksuidEpoch+int64(binary.BigEndian.Uint32(b[:4])), 0)
+	case len(id) == 36 && strings.Count(id, "-") == 4:
+		b, err := hex.DecodeString(strings.ReplaceAll(id, "-", ""))
+		if err != nil || formatUUID([16]byte(b)) != id || b[6]>>4 != 7 {
+			return fmt.Errorf("%q is not a version 7 UUID", id)
+		}
+		seq := int64(b[6]&0x0f)<<8 | int64(b[7])
+		d.Strategy = "uuidv7"
+		d.Time = time.UnixMilli(int64(binary.BigEndian.Uint64(b[:8]) >> 16))
+		d.Sequence = &seq
+	default:
+		// Counter IDs are the node ID, a dash and the node's counter.
+		i := strings.LastIndexByte(id, '-')
+		seq, err := strconv.ParseInt(id[i+1:], 10, 64)
+		if i <= 0 || err != nil || seq < 0 {
+			return fmt.Errorf("%q is not a unique-ids ID", id)
+		}
+		d.Strategy = "counter"
+		d.Node = id[:i]
+		d.Sequence = &seq
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInspectIntegers(t *testing.T) {
+	// The generator skips the millisecond it was created in.
+	at := Epoch.Add(1000 * time.Hour)
+	now := at
+	snowflake, err := NewSnowflake(2, func() time.Time { return now })
+	if err != nil {
+		t.Fatal(err)
+	}
+	now = at.Add(time.Millisecond)
+	flake, err := snowflake.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id       int64
+		strategy string
+		node     string
+		seq      int64
+	}{
+		{1, "sequence", "", 1},
+		{4_194_303, "sequence", "", 4_194_303},
+		// Large enough to pass for a Snowflake ID by size alone.
+		{4_194_304, "sequence", "", 4_194_304},
+		{1<<kindShift - 1, "sequence", "", 1<<kindShift - 1},
+		{flake, "snowflake", "n2", 0},
+		{kindBlock<<kindShift | 12345, "block", "", 12345},
+	}
+	for _, tt := range tests {
+		d, err := Inspect(json.Number(strconv.FormatInt(tt.id, 10)), testNodeIDs)
+		if err != nil {
+			t.Errorf("Inspect(%d): %s", tt.id, err)
+			continue
+		}
+		if d.Strategy != tt.strategy || d.Node != tt.node || d.Sequence == nil || *d.Sequence != tt.seq {
+			t.Errorf("Inspect(%d) = %s node %q seq %v, want %s node %q seq %d",
+				tt.id, d.Strategy, d.Node, d.Sequence, tt.strategy, tt.node, tt.seq)
+		}
+		if tt.strategy == "snowflake" && !d.Time.Equal(at.Add(time.Millisecond)) {
+			t.Errorf("Inspect(%d) time = %s, want %s", tt.id, d.Time, at.Add(time.Millisecond))
+		}
+	}
+
+	for _, id := range []string{"-1", "9223372036854775807", "18446744073709551616"} {
+		if _, err := Inspect(json.Number(id), testNodeIDs); err == nil {
+			t.Errorf("Inspect(%s) succeeded", id)
+		}
+	}
+}
@@ -1,34 +1,192 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"time"
 
 	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/dedup"
+	"maelstrom-lib/hlc"
+	"maelstrom-lib/schema"
 )
 
 func main() {
 	n := maelstrom.NewNode()
 
-	i := 0
+	validator := schema.NewValidator(n, schema.UniqueIDs)
+
+	// The default ID strategy can be overridden per request with a
+	// "strategy" field.
+	defaultStrategy := os.Getenv(StrategyEnv)
+	if defaultStrategy == "" {
+		defaultStrategy = "snowflake"
+	}
+	if _, ok := strategies[defaultStrategy]; !ok {
+		log.Fatalf("%s: unknown strategy %q, want one of %v", StrategyEnv, defaultStrategy, StrategyNames())
+	}
+	// Time-ordered IDs take their time from a hybrid logical clock that
+	// rides along on the Raft messages between nodes, so a node whose
+	// clock lags never issues IDs ordered before ones it has heard of. Its
+	// ceiling is saved, so a restarted node does not go back either.
+	clock := hlc.NewClock(nil, hlc.DefaultMaxOffset)
+	clock.Attach(n)
+	saved := NewSavedClock(clock, n, StateDir())
+	generators := NewGenerators(n, func() time.Time {
+		return time.UnixMilli(saved.Now().Wall)
+	})
+	sequences := NewSequences(n, StateDir())
+
+	n.Handle("init", func(msg maelstrom.Message) error {
+		if err := saved.Load(); err != nil {
+			return err
+		}
+		return sequences.Start()
+	})
+
+	// generator picks the generator for a request's strategy.
+	generator := func(body map[string]any) (Generator, error) {
+		strategy, _ := body["strategy"].(string)
+		if strategy == "" {
+			strategy = defaultStrategy
+		}
+		return generators.Get(strategy)
+	}
+
 	// Register a handler for the "generate" message that responds with an "generate_ok".
-	n.Handle("generate", func(msg maelstrom.Message) error {
+	validator.Handle("generate", func(msg maelstrom.Message) error {
 		// Unmarshal the message body as an loosely-typed map.
 		var body map[string]any
 		if err := json.Unmarshal(msg.Body, &body); err != nil {
 			return err
 		}
 
+		enc, err := ParseEncoding(body)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		var id any
+		if namespace, _ := body["namespace"].(string); namespace != "" {
+			// Gap-free numbering, keyed so that retries get the same number.
+			// Requests without an idempotency key are never retried, so
+			// their msg_id will do.
+			key, ok := dedup.KeyOf(msg)
+			if !ok {
+				key = dedup.Key{Client: msg.Src, ID: fmt.Sprintf("msg:%v", body["msg_id"])}
+			}
+			seq, err := sequences.Next(namespace, key.Client+"/"+key.ID)
+			if err != nil {
+				return err
+			}
+			id = seq
+		} else {
+			gen, err := generator(body)
+			if err != nil {
+				return err
+			}
+			if id, err = gen.Generate(); err != nil {
+				return generateError(err)
+			}
+		}
+		if enc != nil {
+			if id, err = enc.Encode(id); err != nil {
+				return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+			}
+		}
+
 		// Update the message type.
 		body["type"] = "generate_ok"
-		body["id"] = msg.Dest + strconv.Itoa(i)
-
-		i += 1
+		body["id"] = id
+		for _, field := range []string{"strategy", "namespace", "idempotency_key", "encoding", "prefix", "checksum"} {
+			delete(body, field)
+		}
 
 		// Echo the original message back with the updated message type.
-		return n.Reply(msg, body)
+		return validator.Reply(msg, body)
+	})
+
+	// Register a handler for "generate_batch", which returns count IDs in
+	// increasing order in one round trip.
+	validator.Handle("generate_batch", func(msg maelstrom.Message) error {
+		var body map[string]any
+		if err := json.Unmarshal(msg.Body, &body); err != nil {
+			return err
+		}
+
+		count := int(body["count"].(float64))
+		if count > MaxBatch {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest,
+				fmt.Sprintf("count %d exceeds the limit of %d IDs per batch", count, MaxBatch))
+		}
+
+		enc, err := ParseEncoding(body)
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		gen, err := generator(body)
+		if err != nil {
+			return err
+		}
+		ids, err := GenerateBatch(gen, count)
+		if err != nil {
+			return generateError(err)
+		}
+		if enc != nil {
+			for i, id := range ids {
+				if ids[i], err = enc.Encode(id); err != nil {
+					return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+				}
+			}
+		}
+
+		body["type"] = "generate_batch_ok"
+		body["ids"] = ids
+		for _, field := range []string{"count", "strategy", "encoding", "prefix", "checksum"} {
+			delete(body, field)
+		}
+		return validator.Reply(msg, body)
+	})
+
+	// Register a handler for "inspect_id", which decodes an ID generated by
+	// any strategy into its node, timestamp and sequence.
+	validator.Handle("inspect_id", func(msg maelstrom.Message) error {
+		// Decode numbers exactly; 64-bit IDs do not fit in a float64.
+		var body map[string]any
+		dec := json.NewDecoder(bytes.NewReader(msg.Body))
+		dec.UseNumber()
+		if err := dec.Decode(&body); err != nil {
+			return err
+		}
+
+		// Encoded IDs are decoded with the same encoding, prefix and
+		// checksum setting they were generated with.
+		id := body["id"]
+		enc, err := ParseEncoding(body)
+		if err == nil && enc != nil {
+			s, _ := id.(string)
+			id, err = enc.Decode(s)
+		}
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+
+		desc, err := Inspect(id, n.NodeIDs())
+		if err != nil {
+			return maelstrom.NewRPCError(maelstrom.MalformedRequest, err.Error())
+		}
+		reply := desc.Body()
+		reply["type"] = "inspect_id_ok"
+		reply["id"] = body["id"]
+		if enc != nil {
+			reply["decoded_id"] = id
+		}
+		return validator.Reply(msg, reply)
 	})
 
 	// Execute the node's message loop. This will run until STDIN is closed.
@@ -37,3 +195,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// generateError maps generator failures to RPC errors. A regressed clock is
+// expected to recover, so clients may retry.
+func generateError(err error) error {
+	if errors.Is(err, ErrClockRegression) {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+	}
+	return err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ULID, UUIDv7 and KSUID IDs all combine a timestamp with random bits, so
+// they are unique across nodes and restarts with overwhelming probability
+// without any coordination. Within one timestamp tick each generator counts
+// up from the previous ID instead of drawing new random bits, so IDs from
+// one node are strictly increasing.
+
+// errOverflow is returned when the random bits of a tick are exhausted and
+// the clock has not moved on.
+var errOverflow = errors.New("random bits exhausted within one clock tick")
+
+// monotonic tracks the last timestamp and random bits of a generator. The
+// random bits are too wide for one word, so unlike Snowflake and UUIDv7 it
+// swaps whole states: each ID publishes a new, never modified, one by
+// compare-and-swap on a pointer, which keeps it lock-free.
+type monotonic struct {
+	now  func() time.Time
+	tick func(time.Time) int64 // timestamp in the generator's resolution
+	size int                   // bytes of random bits
+
+	last atomic.Pointer[stamp]
+}
+
+// stamp is the timestamp and random bits of one ID.
+type stamp struct {
+	tick    int64
+	entropy []byte
+}
+
+// next returns the timestamp and random bits for the next ID. A regressing
+// clock is treated as still being on the last tick. The random bits must
+// not be modified.
+func (m *monotonic) next(fresh func([]byte)) (int64, []byte, error) {
+	// Only waits for the clock count as attempts, not lost races.
+	for attempt := 0; ; {
+		t := m.tick(m.now())
+		last := m.last.Load()
+		var s *stamp
+		switch {
+		case last == nil || t > last.tick:
+			s = &stamp{tick: t, entropy: make([]byte, m.size)}
+			fresh(s.entropy)
+		case attempt > 1000:
+			return 0, nil, errOverflow
+		default:
+			s = &stamp{tick: last.tick, entropy: clone(last.entropy)}
+			if !increment(s.entropy) {
+				// Wrapped around from all ones: wait for the tick to
+				// move on.
+				attempt++
+				time.Sleep(time.Millisecond)
+				continue
+			}
+		}
+		if m.last.CompareAndSwap(last, s) {
+			return s.tick, s.entropy, nil
+		}
+	}
+}
+
+// increment adds one to the big-endian number b and reports false if it
+// wrapped around.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func clone(b []byte) []byte { return append([]byte(nil), b...) }
+
+func randomize(b []byte) { rand.Read(b) }
+
+// ULID generates Universally Unique Lexicographically Sortable Identifiers.
+type ULID struct{ m monotonic }
+
+func NewULID(now func() time.Time) *ULID {
+	return &ULID{monotonic{
+		now:  now,
+		tick: func(t time.Time) int64 { return t.UnixMilli() },
+		size: 10,
+	}}
+}
+
+func (u *ULID) Generate() (any, error) {
+	ms, entropy, err := u.m.next(randomize)
+	if err != nil {
+		return nil, err
+	}
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(ms)<<16)
+	copy(id[6:], entropy)
+	return encodeDigits(id[:], crockford, 26), nil
+}
+
+// UUIDv7 generates time-ordered version 7 UUIDs. The 12-bit rand_a field
+// is a counter that starts at a random value below 2048 on every
+// millisecond (RFC 9562 method 1), and the 62-bit rand_b field is random.
+// Like Snowflake, it is lock-free: the millisecond and counter of the last
+// UUID are packed into one word advanced by compare-and-swap.
+type UUIDv7 struct {
+	now  func() time.Time
+	last atomic.Int64 // unix ms << 12 | counter
+}
+
+func NewUUIDv7(now func() time.Time) *UUIDv7 {
+	return &UUIDv7{now: now}
+}
+
+func (u *UUIDv7) Generate() (any, error) {
+	var stamp int64
+	// Only waits for the clock count as attempts, not lost races.
+	for attempt := 0; ; {
+		ms := u.now().UnixMilli()
+		last := u.last.Load()
+		switch {
+		case ms > last>>12:
+			var seed [2]byte
+			randomize(seed[:])
+			stamp = ms<<12 | int64(binary.BigEndian.Uint16(seed[:])&0x7ff)
+		case last&0xfff < 0xfff:
+			stamp = last + 1
+		case attempt > 1000:
+			return nil, errOverflow
+		default:
+			attempt++
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if u.last.CompareAndSwap(last, stamp) {
+			break
+		}
+	}
+
+	var id [16]byte
+	binary.BigEndian.PutUint64(id[:8], uint64(stamp>>12)<<16|0x7000|uint64(stamp&0xfff))
+	randomize(id[8:])
+	id[8] = id[8]&0x3f | 0x80 // variant 10
+	return formatUUID(id), nil
+}
+
+func formatUUID(id [16]byte) string {
+	s := hex.EncodeToString(id[:])
+	return s[:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+// ksuidEpoch is the KSUID timestamp origin, 2014-05-13.
+const ksuidEpoch = 1400000000
+
+// KSUID generates K-Sortable Unique IDentifiers.
+type KSUID struct{ m monotonic }
+
+func NewKSUID(now func() time.Time) *KSUID {
+	return &KSUID{monotonic{
+		now:  now,
+		tick: func(t time.Time) int64 { return t.Unix() - ksuidEpoch },
+		size: 16,
+	}}
+}
+
+func (k *KSUID) Generate() (any, error) {
+	secs, payload, err := k.m.next(randomize)
+	if err != nil {
+		return nil, err
+	}
+	var id [20]byte
+	binary.BigEndian.PutUint32(id[:4], uint32(secs))
+	copy(id[4:], payload)
+	return encodeDigits(id[:], base62, 27), nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMonotonicWaitsOutWrapAround(t *testing.T) {
+	// One byte of random bits, drawn as 0xfe: room for a single ID more
+	// within the tick.
+	var calls atomic.Int64
+	m := &monotonic{
+		now:  func() time.Time { return time.Unix(0, 0) },
+		tick: func(time.Time) int64 { return max(calls.Add(1)-5, 0) },
+		size: 1,
+	}
+	fresh := func(b []byte) { b[0] = 0xfe }
+
+	var got [][]byte
+	var ticks []int64
+	for range 3 {
+		tick, entropy, err := m.next(fresh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ticks, got = append(ticks, tick), append(got, entropy)
+	}
+	// The third ID waits for the clock instead of wrapping to 0x00.
+	if ticks[0] != 0 || ticks[1] != 0 || ticks[2] == 0 {
+		t.Fatalf("ticks %v, want the third on a later tick", ticks)
+	}
+	if want := [][]byte{{0xfe}, {0xff}, {0xfe}}; !bytes.Equal(bytes.Join(got, nil), bytes.Join(want, nil)) {
+		t.Fatalf("random bits %x, want %x", got, want)
+	}
+}
+
+func TestMonotonicOverflows(t *testing.T) {
+	m := &monotonic{
+		now:  func() time.Time { return time.Unix(0, 0) },
+		tick: func(t time.Time) int64 { return t.Unix() },
+		size: 1,
+	}
+	fresh := func(b []byte) { b[0] = 0xff }
+	if _, _, err := m.next(fresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m.next(fresh); err != errOverflow {
+		t.Fatalf("next with the random bits used up and a stopped clock returned %v", err)
+	}
+}
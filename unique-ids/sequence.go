@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/raft"
+	"maelstrom-lib/rpc"
+)
+
+// Sequences issue gap-free integers per namespace, 1, 2, 3, ..., for uses
+// such as invoice numbers. The nodes replicate every namespace's last number
+// with Raft, so numbers are linearizable across all nodes: requests to a
+// follower are forwarded to the leader, which issues a number by committing
+// it to the log. Raft state is kept in local files so that nodes can
+// restart.
+//
+// Failure semantics: a number is only returned once a majority of nodes has
+// recorded it. If that cannot happen in time (e.g. in a minority partition
+// or during an election), the request fails with an indefinite crash error:
+// the number may or may not have been issued to this request. Retrying with
+// the same idempotency_key returns the same number if it was, which keeps
+// the sequence gap-free as long as clients retry within the last
+// RecentAssignments numbers of a namespace. Clients that give up instead may
+// leave a gap.
+type Sequences struct {
+	n       *maelstrom.Node
+	raft    *raft.Raft
+	records map[string]*sequenceRecord // applied under Raft's lock
+}
+
+// RecentAssignments is how many of the latest numbers each namespace
+// remembers the requester of.
+const RecentAssignments = 100
+
+// sequenceTimeout bounds a whole request, including retries.
+const sequenceTimeout = 2 * time.Second
+
+type sequenceRecord struct {
+	Value  int64        `json:"value"`
+	Recent []assignment `json:"recent"`
+}
+
+type assignment struct {
+	Key   string `json:"key"`
+	Value int64  `json:"value"`
+}
+
+// nextCommand is the log entry that issues a number.
+type nextCommand struct {
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// NewSequences returns sequences replicated among the nodes with Raft,
+// storing Raft's state under dir. Call Start once the node has been
+// initialized.
+func NewSequences(n *maelstrom.Node, dir string) *Sequences {
+	s := &Sequences{n: n, records: make(map[string]*sequenceRecord)}
+	cfg := raft.DefaultConfig
+	cfg.Storage = raft.NewFileStorage(n, dir)
+	s.raft = raft.New(n, s, cfg)
+	n.Handle("sequence_next", s.handleNext)
+	return s
+}
+
+// Start starts the Raft member with every node as a member.
+func (s *Sequences) Start() error {
+	return s.raft.Start(s.n.NodeIDs())
+}
+
+// Next issues the next number in namespace to the request identified by
+// key, or returns the number already issued to it.
+func (s *Sequences) Next(namespace, key string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sequenceTimeout)
+	defer cancel()
+
+	cmd := nextCommand{Namespace: namespace, Key: key}
+	for ctx.Err() == nil {
+		res, err := s.raft.Propose(ctx, cmd)
+		if err == nil {
+			return res.(int64), nil
+		}
+
+		var notLeader *raft.NotLeaderError
+		if errors.As(err, &notLeader) && notLeader.Leader != "" {
+			if value, err := s.forward(ctx, notLeader.Leader, cmd); err == nil {
+				return value, nil
+			}
+		}
+		// No leader yet, or it changed under us: the retry either finds
+		// our assignment or issues the next number.
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return 0, maelstrom.NewRPCError(maelstrom.Crash,
+		fmt.Sprintf("sequence %q unavailable; a number may have been issued, retry with the same idempotency_key", namespace))
+}
+
+// forward asks the leader to issue the number.
+func (s *Sequences) forward(ctx context.Context, leader string, cmd nextCommand) (int64, error) {
+	body := map[string]any{"type": "sequence_next", "namespace": cmd.Namespace, "key": cmd.Key}
+	msg, err := rpc.Call(ctx, s.n, leader, body)
+	if err != nil {
+		return 0, err
+	}
+	var reply struct {
+		Value int64 `json:"value"`
+	}
+	err = json.Unmarshal(msg.Body, &reply)
+	return reply.Value, err
+}
+
+// handleNext issues a number forwarded by a follower. It only proposes
+// locally, so a request is forwarded at most once.
+func (s *Sequences) handleNext(msg maelstrom.Message) error {
+	var cmd nextCommand
+	if err := json.Unmarshal(msg.Body, &cmd); err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sequenceTimeout)
+	defer cancel()
+	res, err := s.raft.Propose(ctx, cmd)
+	if err != nil {
+		return maelstrom.NewRPCError(maelstrom.TemporarilyUnavailable, err.Error())
+	}
+	return s.n.Reply(msg, map[string]any{"type": "sequence_next_ok", "value": res.(int64)})
+}
+
+// Apply implements raft.StateMachine: it issues the next number in a
+// namespace, unless the key was recently issued one.
+func (s *Sequences) Apply(data json.RawMessage) any {
+	var cmd nextCommand
+	json.Unmarshal(data, &cmd)
+
+	rec := s.records[cmd.Namespace]
+	if rec == nil {
+		rec = &sequenceRecord{}
+		s.records[cmd.Namespace] = rec
+	}
+
+	// A retry of a request that was committed earlier.
+	for _, a := range rec.Recent {
+		if a.Key == cmd.Key {
+			return a.Value
+		}
+	}
+
+	rec.Value++
+	rec.Recent = append(rec.Recent[max(0, len(rec.Recent)-RecentAssignments+1):], assignment{Key: cmd.Key, Value: rec.Value})
+	return rec.Value
+}
+
+// Snapshot implements raft.StateMachine.
+func (s *Sequences) Snapshot() ([]byte, error) {
+	return json.Marshal(s.records)
+}
+
+// Restore implements raft.StateMachine.
+func (s *Sequences) Restore(data []byte) error {
+	records := make(map[string]*sequenceRecord)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	s.records = records
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Integer IDs are self-describing: below the unused sign bit, the top two
+// bits hold the kind of ID.
+const (
+	kindBits  = 2
+	kindShift = 63 - kindBits
+
+	// kindSequence marks gap-free sequence numbers; see Sequences. They
+	// count up from 1, so their kind bits are zero without any tagging.
+	kindSequence = 0
+	// kindSnowflake is the current Snowflake layout below.
+	kindSnowflake = 1
+	// kindBlock marks IDs leased in blocks; see BlockLeaser.
+	kindBlock = 2
+)
+
+// Snowflake IDs sort by creation time: after the kind bits come 41 bits of
+// milliseconds since Epoch (about 69 years), 8 bits of node index and 12
+// bits of sequence within the millisecond.
+const (
+	timeBits = 41
+	nodeBits = 8
+	seqBits  = 12
+
+	maxNode = 1<<nodeBits - 1
+	maxSeq  = 1<<seqBits - 1
+)
+
+// Epoch is the zero point of Snowflake timestamps.
+var Epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// maxRegression is how far the clock may step backwards before the generator
+// refuses to wait for it to catch up.
+const maxRegression = time.Second
+
+// ErrClockRegression is returned when the clock has moved too far backwards
+// to keep issuing increasing IDs.
+var ErrClockRegression = errors.New("clock moved backwards")
+
+// Snowflake generates increasing Snowflake IDs for one node. It is safe
+// for concurrent use and lock-free: the timestamp and sequence of the last
+// ID issued are packed into one word and advanced with compare-and-swap, so
+// concurrent handlers never block each other or see the same value.
+type Snowflake struct {
+	node int64
+	now  func() time.Time
+
+	// last is the timestamp of the last ID, in ms since Epoch, shifted
+	// left by seqBits, plus its sequence.
+	last atomic.Int64
+}
+
+// NewSnowflake returns a generator for the node with the given index in the
+// cluster, typically its position in n.NodeIDs(). The current millisecond
+// counts as used up, so that a generator replacing one that ran on the same
+// node in that millisecond, e.g. after a restart, cannot reissue its IDs.
+func NewSnowflake(node int, now func() time.Time) (*Snowflake, error) {
+	if node < 0 || node > maxNode {
+		return nil, fmt.Errorf("node index %d does not fit in %d bits", node, nodeBits)
+	}
+	s := &Snowflake{node: int64(node), now: now}
+	s.last.Store(-1)
+	if ms := now().Sub(Epoch).Milliseconds(); ms >= 0 && ms < 1<<timeBits {
+		s.last.Store(ms<<seqBits | maxSeq)
+	}
+	return s, nil
+}
+
+// Next returns the next ID. When the 4096 IDs of a millisecond are used up
+// it waits for the next millisecond. If the clock steps backwards, IDs keep
+// counting on the last timestamp so that they still increase, and once that
+// millisecond is exhausted it waits for the clock to catch up, or fails with
+// ErrClockRegression if the clock is too far behind.
+func (s *Snowflake) Next() (int64, error) {
+	first, _, err := s.reserve(1)
+	if err != nil {
+		return 0, err
+	}
+	return s.id(first), nil
+}
+
+// NextBatch returns count increasing IDs. Each millisecond's share of the
+// batch is reserved at once, so a batch of up to 4096 is usually
+// contiguous.
+func (s *Snowflake) NextBatch(count int) ([]int64, error) {
+	ids := make([]int64, 0, count)
+	for len(ids) < count {
+		first, n, err := s.reserve(int64(count - len(ids)))
+		if err != nil {
+			return nil, err
+		}
+		for stamp := first; stamp < first+n; stamp++ {
+			ids = append(ids, s.id(stamp))
+		}
+	}
+	return ids, nil
+}
+
+// reserve claims up to n consecutive stamps (timestamp and sequence) within
+// one millisecond, returning the first and how many it got.
+func (s *Snowflake) reserve(n int64) (first, got int64, err error) {
+	for {
+		now := s.now().Sub(Epoch).Milliseconds()
+		if now < 0 || now >= 1<<timeBits {
+			return 0, 0, fmt.Errorf("time %s is outside the Snowflake range", s.now())
+		}
+
+		last := s.last.Load()
+		switch {
+		case now > last>>seqBits:
+			first = now << seqBits
+		case last&maxSeq < maxSeq:
+			first = last + 1
+		default:
+			behind := time.Duration(last>>seqBits-now) * time.Millisecond
+			if behind >= maxRegression {
+				return 0, 0, fmt.Errorf("%w by %s", ErrClockRegression, behind)
+			}
+			time.Sleep(behind + time.Millisecond)
+			continue
+		}
+
+		got = min(n, maxSeq-first&maxSeq+1)
+		if s.last.CompareAndSwap(last, first+got-1) {
+			return first, got, nil
+		}
+	}
+}
+
+// Generate implements Generator.
+func (s *Snowflake) Generate() (any, error) {
+	return s.Next()
+}
+
+// GenerateBatch implements BatchGenerator.
+func (s *Snowflake) GenerateBatch(count int) ([]any, error) {
+	ids, err := s.NextBatch(count)
+	if err != nil {
+		return nil, err
+	}
+	batch := make([]any, len(ids))
+	for i, id := range ids {
+		batch[i] = id
+	}
+	return batch, nil
+}
+
+// id builds the ID for a stamp reserved by reserve.
+func (s *Snowflake) id(stamp int64) int64 {
+	return kindSnowflake<<kindShift | stamp>>seqBits<<(nodeBits+seqBits) | s.node<<seqBits | stamp&maxSeq
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+	"maelstrom-lib/hlc"
+)
+
+// StateDirEnv names the environment variable that sets the directory nodes
+// keep what must survive a restart in: Raft's state, and the clock ceiling.
+const StateDirEnv = "UNIQUE_IDS_STATE_DIR"
+
+// StateDir returns the directory named by StateDirEnv or, if unset, one in
+// the temporary directory named after the parent process. Maelstrom starts,
+// and restarts, every node of a test as its child, so a restarted node
+// finds its state while the next test starts afresh.
+func StateDir() string {
+	if dir := os.Getenv(StateDirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("maelstrom-unique-ids-%d", os.Getppid()))
+}
+
+// ceilingWindow is how far past the latest timestamp the saved ceiling is
+// set, trading a file write per window against how long a restarted node
+// may wait for its physical clock to pass the ceiling.
+const ceilingWindow = 200 * time.Millisecond
+
+// SavedClock hands out timestamps from a hybrid logical clock and saves a
+// ceiling above every one of them before they are used. A node that
+// restarts starts its clock at the ceiling: its physical clock may be
+// behind timestamps it issued before, because they had been pushed ahead
+// by other nodes' clocks or because the clock stepped back, and reissuing
+// them would reissue IDs.
+type SavedClock struct {
+	clock *hlc.Clock
+	node  *maelstrom.Node
+	dir   string
+
+	mu      sync.Mutex
+	loaded  bool
+	ceiling int64 // saved wall time, above every timestamp handed out
+}
+
+// NewSavedClock returns a clock saving its ceiling in a file under dir
+// named after the node's ID. Call Load once the node has been initialized.
+func NewSavedClock(clock *hlc.Clock, n *maelstrom.Node, dir string) *SavedClock {
+	return &SavedClock{clock: clock, node: n, dir: dir}
+}
+
+// Load advances the clock to the ceiling saved before a restart, if any.
+func (c *SavedClock) Load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buf, err := os.ReadFile(c.path())
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if c.ceiling, err = strconv.ParseInt(string(buf), 10, 64); err != nil {
+			return fmt.Errorf("clock ceiling: %w", err)
+		}
+		c.clock.Advance(hlc.Timestamp{Wall: c.ceiling})
+	}
+	c.loaded = true
+	return nil
+}
+
+// Now returns a fresh timestamp, having first raised the saved ceiling
+// above it if need be. Until Load, nothing is saved.
+func (c *SavedClock) Now() hlc.Timestamp {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ts := c.clock.Now()
+	if c.loaded && ts.Wall >= c.ceiling {
+		ceiling := ts.Wall + ceilingWindow.Milliseconds()
+		if err := c.save(ceiling); err != nil {
+			log.Printf("save clock ceiling: %s", err)
+		} else {
+			c.ceiling = ceiling
+		}
+	}
+	return ts
+}
+
+// save replaces the saved ceiling, so that a crash leaves either the old
+// one or the new. Expects c.mu.
+func (c *SavedClock) save(ceiling int64) error {
+	if err := os.MkdirAll(filepath.Dir(c.path()), 0o755); err != nil {
+		return err
+	}
+	tmp := c.path() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(ceiling, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path())
+}
+
+func (c *SavedClock) path() string {
+	return filepath.Join(c.dir, c.node.ID(), "clock")
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"maelstrom-lib/hlc"
+)
+
+func TestSavedClockSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	n := initNode("n1")
+	physical := int64(1000)
+	start := func() *SavedClock {
+		t.Helper()
+		clock := hlc.NewClock(func() int64 { return physical }, hlc.DefaultMaxOffset)
+		c := NewSavedClock(clock, n, dir)
+		if err := c.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	// Another node's clock pushes this one ahead of its physical time.
+	c := start()
+	c.Now()
+	if _, err := c.clock.Update(hlc.Timestamp{Wall: 1400}); err != nil {
+		t.Fatal(err)
+	}
+	last := c.Now()
+
+	// Restarted, and with its physical clock still behind, the node must
+	// not hand out any timestamp it has already.
+	physical = 1100
+	if ts := start().Now(); !last.Before(ts) {
+		t.Fatalf("after a restart the clock issued %s, not after %s", ts, last)
+	}
+}
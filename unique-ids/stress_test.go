@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"maelstrom-lib/harness"
+
+	maelstrom "github.com/jepsen-io/maelstrom/demo/go"
+)
+
+// Run these with -race: they hammer one generator from many goroutines, as
+// concurrent handlers on a busy node do.
+
+const (
+	stressWorkers = 64
+	stressEach    = 1000
+)
+
+// before reports whether a, issued by strategy, sorts strictly before b in
+// the order that strategy promises.
+func before(t *testing.T, strategy string, a, b any) bool {
+	t.Helper()
+	switch a := a.(type) {
+	case int64:
+		return a < b.(int64)
+	case string:
+		if strategy == "counter" {
+			return counterValue(t, a) < counterValue(t, b.(string))
+		}
+		return a < b.(string)
+	}
+	t.Fatalf("%s issued %v of type %T", strategy, a, a)
+	return false
+}
+
+// counterValue returns the integer after a counter ID's node prefix.
+func counterValue(t *testing.T, id string) int64 {
+	t.Helper()
+	v, err := strconv.ParseInt(id[strings.LastIndex(id, "-")+1:], 10, 64)
+	if err != nil {
+		t.Fatalf("counter ID %q: %s", id, err)
+	}
+	return v
+}
+
+// checkIncreasing fails the test unless each worker's IDs strictly
+// increase: every strategy promises that IDs from one node do.
+func checkIncreasing(t *testing.T, strategy string, perWorker [][]any) {
+	t.Helper()
+	for w, ids := range perWorker {
+		for i := 1; i < len(ids); i++ {
+			if !before(t, strategy, ids[i-1], ids[i]) {
+				t.Fatalf("%s: worker %d got %v after %v", strategy, w, ids[i], ids[i-1])
+			}
+		}
+	}
+}
+
+func TestStressOneNode(t *testing.T) {
+	for _, strategy := range localStrategies {
+		t.Run(strategy, func(t *testing.T) {
+			gens := NewGenerators(initNode("n1"), time.Now)
+			perWorker := make([][]any, stressWorkers)
+			errs := make([]error, stressWorkers)
+			var wg sync.WaitGroup
+			for w := range stressWorkers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range stressEach {
+						gen, err := gens.Get(strategy)
+						if err != nil {
+							errs[w] = err
+							return
+						}
+						id, err := gen.Generate()
+						if err != nil {
+							errs[w] = err
+							return
+						}
+						perWorker[w] = append(perWorker[w], id)
+					}
+				}()
+			}
+			wg.Wait()
+
+			var all []any
+			for w, err := range errs {
+				if err != nil {
+					t.Fatalf("%s: worker %d: %s", strategy, w, err)
+				}
+				all = append(all, perWorker[w]...)
+			}
+			checkIncreasing(t, strategy, perWorker)
+			checkUnique(t, strategy, all)
+		})
+	}
+}
+
+func TestStressBlockOneNode(t *testing.T) {
+	setup := func(n *maelstrom.Node) {
+		gens := NewGenerators(n, time.Now)
+		n.Handle("generate", func(msg maelstrom.Message) error {
+			gen, err := gens.Get("block")
+			if err != nil {
+				return err
+			}
+			id, err := gen.Generate()
+			if err != nil {
+				return err
+			}
+			// Node.Reply rounds 64-bit integers through float64.
+			return n.Reply(msg, map[string]any{"type": "generate_ok", "id": fmt.Sprint(id)})
+		})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	c, err := harness.StartInProcess(ctx, setup, harness.Options{NodeCount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Stop()
+
+	const clients, each = 32, 50
+	perClient := make([][]any, clients)
+	errs := make([]error, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := fmt.Sprintf("c%d", i+1)
+			for range each {
+				resp, err := c.RPC(ctx, client, "n0", map[string]any{"type": "generate"})
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				var body struct {
+					ID string `json:"id"`
+				}
+				if err := json.Unmarshal(resp.Body, &body); err != nil {
+					errs[i] = err
+					return
+				}
+				id, err := strconv.ParseInt(body.ID, 10, 64)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				perClient[i] = append(perClient[i], id)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var all []any
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("c%d: %s", i+1, err)
+		}
+		all = append(all, perClient[i]...)
+	}
+	checkIncreasing(t, "block", perClient)
+	checkUnique(t, "block", all)
+}